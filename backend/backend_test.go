@@ -0,0 +1,836 @@
+// Copyright 2018 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsouza/fake-gcs-server/internal/checksum"
+)
+
+func makeStorageBackends(t *testing.T) (map[string]Storage, func()) {
+	tempDir, err := ioutil.TempDir(os.TempDir(), "fakegcstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storageFS, err := NewStorageFS(nil, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Storage{
+			"memory":     NewStorageMemory(nil),
+			"filesystem": storageFS,
+		}, func() {
+			err := os.RemoveAll(tempDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+}
+
+func testForStorageBackends(t *testing.T, test func(t *testing.T, storage Storage)) {
+	backends, cleanup := makeStorageBackends(t)
+	defer cleanup()
+	for backendName, storage := range backends {
+		storage := storage
+		t.Run(fmt.Sprintf("storage backend %s", backendName), func(t *testing.T) {
+			test(t, storage)
+		})
+	}
+}
+
+func noError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("should not error, but got: %v", err)
+	}
+}
+
+func shouldError(t *testing.T, err error) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("should error, but error is nil")
+	}
+}
+
+func readStreamingObject(t *testing.T, obj StreamingObject) Object {
+	t.Helper()
+	defer obj.Content.Close()
+	content, err := ioutil.ReadAll(obj.Content)
+	noError(t, err)
+	return Object{ObjectAttrs: obj.ObjectAttrs, Content: content}
+}
+
+func uploadAndCompare(t *testing.T, storage Storage, obj Object) int64 {
+	_, err := storage.CreateObject(obj.ObjectAttrs, bytes.NewReader(obj.Content))
+	noError(t, err)
+	streamingObj, err := storage.GetObject(obj.BucketName, obj.Name)
+	noError(t, err)
+	activeObj := readStreamingObject(t, streamingObj)
+	if activeObj.Generation == 0 {
+		t.Errorf("generation is empty, but we expect a unique int")
+	}
+	if err := compareObjects(activeObj, obj); err != nil {
+		t.Errorf("object retrieved differs from the created one. Descr: %v", err)
+	}
+	streamingObj, err = storage.GetObjectWithGeneration(obj.BucketName, obj.Name, activeObj.Generation)
+	noError(t, err)
+	objFromGeneration := readStreamingObject(t, streamingObj)
+	if err := compareObjects(objFromGeneration, obj); err != nil {
+		t.Errorf("object retrieved differs from the created one. Descr: %v", err)
+	}
+	return activeObj.Generation
+}
+
+func TestObjectCRUD(t *testing.T) {
+	const bucketName = "prod-bucket"
+	const objectName = "video/hi-res/best_video_1080p.mp4"
+	content1 := []byte("content1")
+	const crc1 = "crc1"
+	const md51 = "md51"
+	content2 := []byte("content2")
+	for _, versioningEnabled := range []bool{true, false} {
+		versioningEnabled := versioningEnabled
+		testForStorageBackends(t, func(t *testing.T, storage Storage) {
+			// Get in non-existent case
+			_, err := storage.GetObject(bucketName, objectName)
+			shouldError(t, err)
+			// Delete in non-existent case
+			err = storage.DeleteObject(bucketName, objectName)
+			shouldError(t, err)
+			err = storage.CreateBucket(Bucket{Name: bucketName, VersioningEnabled: versioningEnabled})
+			noError(t, err)
+
+			initialObject := Object{ObjectAttrs: ObjectAttrs{BucketName: bucketName, Name: objectName, Crc32c: crc1, Md5Hash: md51}, Content: content1}
+			t.Logf("create an initial object on an empty bucket with versioning %t", versioningEnabled)
+			initialGeneration := uploadAndCompare(t, storage, initialObject)
+
+			t.Logf("create (update) in existent case with explicit generation and versioning %t", versioningEnabled)
+			secondVersionWithGeneration := Object{ObjectAttrs: ObjectAttrs{BucketName: bucketName, Name: objectName, Generation: 1234}, Content: content2}
+			uploadAndCompare(t, storage, secondVersionWithGeneration)
+
+			streamingObj, err := storage.GetObjectWithGeneration(initialObject.BucketName, initialObject.Name, initialGeneration)
+			if !versioningEnabled {
+				shouldError(t, err)
+			} else {
+				noError(t, err)
+				initialObjectFromGeneration := readStreamingObject(t, streamingObj)
+				if err := compareObjects(initialObjectFromGeneration, initialObject); err != nil {
+					t.Errorf("get initial generation - object retrieved differs from the created one. Descr: %v", err)
+				}
+			}
+
+			t.Logf("checking active object is the expected one when versioning is %t", versioningEnabled)
+			objs, _, err := storage.ListObjects(bucketName, ListOptions{})
+			noError(t, err)
+			if len(objs) != 1 {
+				t.Errorf("wrong number of objects returned\nwant 1\ngot  %d", len(objs))
+			}
+			if objs[0].Name != objectName {
+				t.Errorf("wrong object name\nwant %q\ngot  %q", objectName, objs[0].Name)
+			}
+			if objs[0].Size != int64(len(content2)) {
+				t.Errorf("wrong object size\nwant %d\ngot  %d", len(content2), objs[0].Size)
+			}
+
+			t.Logf("checking all object listing is the expected one when versioning is %t", versioningEnabled)
+			objs, _, err = storage.ListObjects(bucketName, ListOptions{Versions: true})
+			noError(t, err)
+			if versioningEnabled && len(objs) != 2 {
+				t.Errorf("wrong number of objects returned\nwant 2\ngot  %d", len(objs))
+			}
+			if !versioningEnabled && len(objs) != 1 {
+				t.Errorf("wrong number of objects returned\nwant 1\ngot  %d", len(objs))
+			}
+
+			err = storage.DeleteObject(bucketName, objectName)
+			noError(t, err)
+
+			_, err = storage.GetObject(bucketName, objectName)
+			shouldError(t, err)
+
+			streamingObj, err = storage.GetObjectWithGeneration(secondVersionWithGeneration.BucketName, secondVersionWithGeneration.Name, secondVersionWithGeneration.Generation)
+			if !versioningEnabled {
+				shouldError(t, err)
+				return
+			}
+			noError(t, err)
+			retrievedObject := readStreamingObject(t, streamingObj)
+			if err := compareObjects(retrievedObject, secondVersionWithGeneration); err != nil {
+				t.Errorf("get object by generation after removal - object retrieved differs from the created one. Descr: %v", err)
+			}
+			if retrievedObject.Deleted == "" {
+				t.Error("archived object should have a deletion time")
+			}
+		})
+	}
+}
+
+func TestObjectDeleteWithGeneration(t *testing.T) {
+	const bucketName = "prod-bucket"
+	const objectName = "video/hi-res/best_video_1080p.mp4"
+	testForStorageBackends(t, func(t *testing.T, storage Storage) {
+		err := storage.CreateBucket(Bucket{Name: bucketName, VersioningEnabled: true})
+		noError(t, err)
+		firstGeneration := uploadAndCompare(t, storage, Object{ObjectAttrs: ObjectAttrs{BucketName: bucketName, Name: objectName, Generation: 1111}, Content: []byte("content1")})
+		secondGeneration := uploadAndCompare(t, storage, Object{ObjectAttrs: ObjectAttrs{BucketName: bucketName, Name: objectName, Generation: 2222}, Content: []byte("content2")})
+
+		err = storage.DeleteObjectWithGeneration(bucketName, objectName, firstGeneration)
+		noError(t, err)
+		_, err = storage.GetObjectWithGeneration(bucketName, objectName, firstGeneration)
+		shouldError(t, err)
+		err = storage.DeleteObjectWithGeneration(bucketName, objectName, firstGeneration)
+		shouldError(t, err)
+
+		err = storage.DeleteObjectWithGeneration(bucketName, objectName, secondGeneration)
+		noError(t, err)
+		_, err = storage.GetObject(bucketName, objectName)
+		shouldError(t, err)
+		objs, _, err := storage.ListObjects(bucketName, ListOptions{Versions: true})
+		noError(t, err)
+		if len(objs) != 0 {
+			t.Errorf("wrong number of objects returned\nwant 0\ngot  %d", len(objs))
+		}
+	})
+}
+
+func TestFSObjectContentStoredAsIs(t *testing.T) {
+	const bucketName = "prod-bucket"
+	const objectName = "video/hi-res/best_video_1080p.mp4"
+	content := []byte("some content")
+	tempDir, err := ioutil.TempDir(os.TempDir(), "fakegcstest")
+	noError(t, err)
+	defer os.RemoveAll(tempDir)
+	storage, err := NewStorageFS(nil, tempDir)
+	noError(t, err)
+
+	_, err = storage.CreateObject(ObjectAttrs{BucketName: bucketName, Name: objectName}, bytes.NewReader(content))
+	noError(t, err)
+	data, err := ioutil.ReadFile(filepath.Join(tempDir, bucketName, url.PathEscape(objectName)))
+	noError(t, err)
+	if !bytes.Equal(data, content) {
+		t.Errorf("wrong content in the object file\nwant %q\ngot  %q", content, data)
+	}
+	objs, _, err := storage.ListObjects(bucketName, ListOptions{})
+	noError(t, err)
+	if len(objs) != 1 {
+		t.Fatalf("wrong number of objects returned\nwant 1\ngot  %d", len(objs))
+	}
+	if objs[0].Name != objectName {
+		t.Errorf("wrong object name\nwant %q\ngot  %q", objectName, objs[0].Name)
+	}
+	if objs[0].Size != int64(len(content)) {
+		t.Errorf("wrong object size\nwant %d\ngot  %d", len(content), objs[0].Size)
+	}
+}
+
+func TestFSLegacyObjectsMigrated(t *testing.T) {
+	const bucketName = "prod-bucket"
+	const objectName = "video/hi-res/best_video_1080p.mp4"
+	content := []byte("some content")
+	tempDir, err := ioutil.TempDir(os.TempDir(), "fakegcstest")
+	noError(t, err)
+	defer os.RemoveAll(tempDir)
+	bucketDir := filepath.Join(tempDir, bucketName)
+	noError(t, os.MkdirAll(bucketDir, 0o700))
+	legacy := `{"ContentType":"video/mp4","Content":"` + base64.StdEncoding.EncodeToString(content) + `","Metadata":{"key":"value"},"Generation":1234}`
+	noError(t, ioutil.WriteFile(filepath.Join(bucketDir, url.PathEscape(objectName)), []byte(legacy), 0o600))
+	storage, err := NewStorageFS(nil, tempDir)
+	noError(t, err)
+
+	objs, _, err := storage.ListObjects(bucketName, ListOptions{})
+	noError(t, err)
+	if len(objs) != 1 {
+		t.Fatalf("wrong number of objects returned\nwant 1\ngot  %d", len(objs))
+	}
+	streamingObj, err := storage.GetObject(bucketName, objectName)
+	noError(t, err)
+	obj := readStreamingObject(t, streamingObj)
+	expected := Object{
+		ObjectAttrs: ObjectAttrs{
+			BucketName:  bucketName,
+			Name:        objectName,
+			ContentType: "video/mp4",
+			Metadata:    map[string]string{"key": "value"},
+			Generation:  1234,
+			Crc32c:      checksum.EncodedCrc32cChecksum(content),
+			Md5Hash:     checksum.EncodedMd5Hash(content),
+		},
+		Content: content,
+	}
+	if err := compareObjects(obj, expected); err != nil {
+		t.Error(err)
+	}
+	if obj.Size != int64(len(content)) || obj.Metadata["key"] != "value" {
+		t.Errorf("wrong attributes after migration: %+v", obj.ObjectAttrs)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(bucketDir, url.PathEscape(objectName)))
+	noError(t, err)
+	if !bytes.Equal(data, content) {
+		t.Errorf("legacy object file wasn't rewritten with its content\nwant %q\ngot  %q", content, data)
+	}
+}
+
+func TestObjectChecksumsComputedWhileStreaming(t *testing.T) {
+	const bucketName = "some-bucket"
+	content := []byte("some nice content")
+	testForStorageBackends(t, func(t *testing.T, storage Storage) {
+		attrs, err := storage.CreateObject(ObjectAttrs{BucketName: bucketName, Name: "file.txt"}, bytes.NewReader(content))
+		noError(t, err)
+		if attrs.Size != int64(len(content)) {
+			t.Errorf("wrong object size\nwant %d\ngot  %d", len(content), attrs.Size)
+		}
+		if expected := checksum.EncodedCrc32cChecksum(content); attrs.Crc32c != expected {
+			t.Errorf("wrong crc32c\nwant %q\ngot  %q", expected, attrs.Crc32c)
+		}
+		if expected := checksum.EncodedMd5Hash(content); attrs.Md5Hash != expected {
+			t.Errorf("wrong md5 hash\nwant %q\ngot  %q", expected, attrs.Md5Hash)
+		}
+	})
+}
+
+func TestObjectQueryErrors(t *testing.T) {
+	for _, versioningEnabled := range []bool{true, false} {
+		versioningEnabled := versioningEnabled
+		testForStorageBackends(t, func(t *testing.T, storage Storage) {
+			const bucketName = "random-bucket"
+			err := storage.CreateBucket(Bucket{Name: bucketName, VersioningEnabled: versioningEnabled})
+			noError(t, err)
+			validObject := Object{ObjectAttrs: ObjectAttrs{BucketName: bucketName, Name: "random-object"}, Content: []byte("random-content")}
+			_, err = storage.CreateObject(validObject.ObjectAttrs, bytes.NewReader(validObject.Content))
+			noError(t, err)
+			_, err = storage.GetObjectWithGeneration(validObject.BucketName, validObject.Name, 33333)
+			shouldError(t, err)
+		})
+	}
+}
+
+func TestBucketCreateGetListDelete(t *testing.T) {
+	testForStorageBackends(t, func(t *testing.T, storage Storage) {
+		buckets, err := storage.ListBuckets()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(buckets) != 0 {
+			t.Fatalf("more than zero buckets found: %d, and expecting zero when starting the test", len(buckets))
+		}
+		bucketsToTest := []Bucket{
+			{Name: "prod-bucket", VersioningEnabled: false, Metageneration: 1},
+			{Name: "prod-bucket-with-versioning", VersioningEnabled: true, Metageneration: 1},
+		}
+		for _, bucket := range bucketsToTest {
+			_, err := storage.GetBucket(bucket.Name)
+			if err == nil {
+				t.Fatalf("bucket %s, exists before being created", bucket.Name)
+			}
+			// The FS backend uses filesystem timestamps to store bucket creation time.
+			// Use a large +/- 5 second window to allow for an imperfectly synchronized
+			// clock generating the filesystem timestamp and to reduce test flakes.
+			timeBeforeCreation := time.Now().Add(-5 * time.Second)
+			err = storage.CreateBucket(bucket)
+			timeAfterCreation := time.Now().Add(5 * time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bucketFromStorage, err := storage.GetBucket(bucket.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !isBucketEquivalentTo(bucketFromStorage, bucket, timeBeforeCreation, timeAfterCreation) {
+				t.Errorf("bucket %v does not have the expected props after retrieving. Expected %v and time between %v and %v",
+					bucketFromStorage, bucket, timeBeforeCreation, timeAfterCreation)
+			}
+			buckets, err = storage.ListBuckets()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(buckets) != 1 {
+				t.Errorf("found unexpected number of buckets. Expected 1, found %d", len(buckets))
+			}
+			if buckets[0].Name != bucket.Name {
+				t.Errorf("listed bucket has unexpected name. Expected %s, actual: %v", bucket.Name, buckets[0].Name)
+			}
+			err = storage.DeleteBucket(bucket.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestBucketDeleteWithArchivedObjects(t *testing.T) {
+	const bucketName = "prod-bucket"
+	const objectName = "video/hi-res/best_video_1080p.mp4"
+	testForStorageBackends(t, func(t *testing.T, storage Storage) {
+		err := storage.CreateBucket(Bucket{Name: bucketName, VersioningEnabled: true})
+		noError(t, err)
+		generation := uploadAndCompare(t, storage, Object{ObjectAttrs: ObjectAttrs{BucketName: bucketName, Name: objectName}, Content: []byte("content")})
+		err = storage.DeleteObject(bucketName, objectName)
+		noError(t, err)
+
+		err = storage.DeleteBucket(bucketName)
+		if !errors.Is(err, BucketNotEmpty) {
+			t.Errorf("wrong error deleting a bucket with archived objects\nwant %v\ngot  %v", BucketNotEmpty, err)
+		}
+		err = storage.DeleteObjectWithGeneration(bucketName, objectName, generation)
+		noError(t, err)
+		err = storage.DeleteBucket(bucketName)
+		noError(t, err)
+	})
+}
+
+func TestBucketNotFound(t *testing.T) {
+	testForStorageBackends(t, func(t *testing.T, storage Storage) {
+		_, err := storage.GetBucket("missing-bucket")
+		if !errors.Is(err, BucketNotFound) {
+			t.Errorf("wrong error getting a missing bucket\nwant %v\ngot  %v", BucketNotFound, err)
+		}
+		_, _, err = storage.ListObjects("missing-bucket", ListOptions{})
+		if !errors.Is(err, BucketNotFound) {
+			t.Errorf("wrong error listing the objects of a missing bucket\nwant %v\ngot  %v", BucketNotFound, err)
+		}
+	})
+}
+
+func isBucketEquivalentTo(a, b Bucket, earliest, latest time.Time) bool {
+	return a.Name == b.Name &&
+		a.VersioningEnabled == b.VersioningEnabled &&
+		a.Metageneration == b.Metageneration &&
+		a.TimeCreated.After(earliest) && a.TimeCreated.Before(latest)
+}
+
+func TestBucketDuplication(t *testing.T) {
+	const bucketName = "prod-bucket"
+	testForStorageBackends(t, func(t *testing.T, storage Storage) {
+		err := storage.CreateBucket(Bucket{Name: bucketName})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = storage.CreateBucket(Bucket{Name: bucketName, VersioningEnabled: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bucket, err := storage.GetBucket(bucketName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bucket.VersioningEnabled {
+			t.Error("existing bucket was replaced by the duplicate")
+		}
+	})
+}
+
+func compareObjects(o1, o2 Object) error {
+	if o1.BucketName != o2.BucketName {
+		return fmt.Errorf("bucket name differs:\nmain %q\narg  %q", o1.BucketName, o2.BucketName)
+	}
+	if o1.Name != o2.Name {
+		return fmt.Errorf("wrong object name:\nmain %q\narg  %q", o1.Name, o2.Name)
+	}
+	if o1.ContentType != o2.ContentType {
+		return fmt.Errorf("wrong object contenttype:\nmain %q\narg  %q", o1.ContentType, o2.ContentType)
+	}
+	if o2.Crc32c != "" && o1.Crc32c != o2.Crc32c {
+		return fmt.Errorf("wrong crc:\nmain %q\narg  %q", o1.Crc32c, o2.Crc32c)
+	}
+	if o2.Md5Hash != "" && o1.Md5Hash != o2.Md5Hash {
+		return fmt.Errorf("wrong md5:\nmain %q\narg  %q", o1.Md5Hash, o2.Md5Hash)
+	}
+	if o1.Generation != 0 && o2.Generation != 0 && o1.Generation != o2.Generation {
+		return fmt.Errorf("generations different from 0, but not equal:\nmain %q\narg  %q", o1.Generation, o2.Generation)
+	}
+	if !bytes.Equal(o1.Content, o2.Content) {
+		return fmt.Errorf("wrong object content:\nmain %q\narg  %q", o1.Content, o2.Content)
+	}
+	return nil
+}
+
+func TestRegisteredBackends(t *testing.T) {
+	names := Names()
+	expected := []string{FilesystemBackend, MemoryBackend}
+	if len(names) < len(expected) {
+		t.Fatalf("wrong list of registered backends\nwant at least %v\ngot  %v", expected, names)
+	}
+	for _, name := range expected {
+		if !IsRegistered(name) {
+			t.Errorf("backend %q is not registered", name)
+		}
+	}
+	if _, err := New(MemoryBackend, Config{}); err != nil {
+		t.Errorf("unexpected error creating the memory backend: %v", err)
+	}
+	if _, err := New(FilesystemBackend, Config{}); err == nil {
+		t.Error("unexpected <nil> error creating the filesystem backend with no root")
+	}
+	if _, err := New("unknown", Config{}); err == nil {
+		t.Error("unexpected <nil> error creating an unknown backend")
+	}
+}
+
+func TestBackendsNow(t *testing.T) {
+	tempDir, err := ioutil.TempDir(os.TempDir(), "fakegcstest")
+	noError(t, err)
+	defer os.RemoveAll(tempDir)
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	cfg := Config{Root: tempDir, Now: func() time.Time { return now }}
+	for _, name := range []string{MemoryBackend, FilesystemBackend} {
+		storage, err := New(name, cfg)
+		noError(t, err)
+		const bucketName = "some-bucket"
+		noError(t, storage.CreateBucket(Bucket{Name: bucketName, VersioningEnabled: true}))
+		bucket, err := storage.UpdateBucket(bucketName, BucketPatch{})
+		noError(t, err)
+		if !bucket.Updated.Equal(now) {
+			t.Errorf("%s: wrong bucket update time\nwant %s\ngot  %s", name, now, bucket.Updated)
+		}
+		_, err = storage.CreateObject(ObjectAttrs{BucketName: bucketName, Name: "file.txt"}, bytes.NewReader(nil))
+		noError(t, err)
+		attrs, err := storage.PatchObject(bucketName, "file.txt", ObjectPatch{})
+		noError(t, err)
+		if expected := now.Format(timestampFormat); attrs.Updated != expected {
+			t.Errorf("%s: wrong object update time\nwant %s\ngot  %s", name, expected, attrs.Updated)
+		}
+		noError(t, storage.DeleteObject(bucketName, "file.txt"))
+		objs, _, err := storage.ListObjects(bucketName, ListOptions{Versions: true})
+		noError(t, err)
+		if len(objs) != 1 {
+			t.Fatalf("%s: wrong number of versions\nwant 1\ngot  %d", name, len(objs))
+		}
+		if expected := now.Format(timestampFormat); objs[0].Deleted != expected {
+			t.Errorf("%s: wrong object deletion time\nwant %s\ngot  %s", name, expected, objs[0].Deleted)
+		}
+	}
+}
+
+func TestObjectListSorted(t *testing.T) {
+	const bucketName = "some-bucket"
+	names := []string{"img/b.jpg", "a.txt", "img/a.jpg", "z", "img"}
+	testForStorageBackends(t, func(t *testing.T, storage Storage) {
+		noError(t, storage.CreateBucket(Bucket{Name: bucketName, VersioningEnabled: true}))
+		for _, name := range names {
+			_, err := storage.CreateObject(ObjectAttrs{BucketName: bucketName, Name: name}, bytes.NewReader([]byte(name)))
+			noError(t, err)
+		}
+		_, err := storage.CreateObject(ObjectAttrs{BucketName: bucketName, Name: "img/a.jpg"}, bytes.NewReader([]byte("new content")))
+		noError(t, err)
+
+		objs, _, err := storage.ListObjects(bucketName, ListOptions{})
+		noError(t, err)
+		expected := []string{"a.txt", "img", "img/a.jpg", "img/b.jpg", "z"}
+		if got := objectNames(objs); !equalStrings(got, expected) {
+			t.Errorf("wrong list of objects\nwant %v\ngot  %v", expected, got)
+		}
+
+		objs, _, err = storage.ListObjects(bucketName, ListOptions{Versions: true})
+		noError(t, err)
+		expected = []string{"a.txt", "img", "img/a.jpg", "img/a.jpg", "img/b.jpg", "z"}
+		if got := objectNames(objs); !equalStrings(got, expected) {
+			t.Errorf("wrong list of objects\nwant %v\ngot  %v", expected, got)
+		}
+		if objs[2].Generation > objs[3].Generation {
+			t.Errorf("generations are not sorted: %d > %d", objs[2].Generation, objs[3].Generation)
+		}
+	})
+}
+
+func TestObjectListWithOptions(t *testing.T) {
+	const bucketName = "some-bucket"
+	names := []string{"a", "img/1", "img/2", "img/3", "img/sub/1", "img/sub/2", "imgs", "z"}
+	tests := []struct {
+		options          ListOptions
+		expectedObjects  []string
+		expectedPrefixes []string
+	}{
+		{ListOptions{}, names, []string{}},
+		{ListOptions{Prefix: "img/"}, []string{"img/1", "img/2", "img/3", "img/sub/1", "img/sub/2"}, []string{}},
+		{ListOptions{Prefix: "img/", Delimiter: "/"}, []string{"img/1", "img/2", "img/3"}, []string{"img/sub/"}},
+		{ListOptions{Delimiter: "/"}, []string{"a", "imgs", "z"}, []string{"img/"}},
+		{ListOptions{Prefix: "img/", StartOffset: "img/2"}, []string{"img/2", "img/3", "img/sub/1", "img/sub/2"}, []string{}},
+		{ListOptions{Prefix: "img", EndOffset: "img/3"}, []string{"img/1", "img/2"}, []string{}},
+		{ListOptions{StartOffset: "b", EndOffset: "z"}, []string{"img/1", "img/2", "img/3", "img/sub/1", "img/sub/2", "imgs"}, []string{}},
+		{ListOptions{Prefix: "x"}, []string{}, []string{}},
+	}
+	testForStorageBackends(t, func(t *testing.T, storage Storage) {
+		for _, name := range names {
+			_, err := storage.CreateObject(ObjectAttrs{BucketName: bucketName, Name: name}, bytes.NewReader([]byte(name)))
+			noError(t, err)
+		}
+		for _, test := range tests {
+			objs, prefixes, err := storage.ListObjects(bucketName, test.options)
+			noError(t, err)
+			if got := objectNames(objs); !equalStrings(got, test.expectedObjects) {
+				t.Errorf("wrong list of objects for %+v\nwant %v\ngot  %v", test.options, test.expectedObjects, got)
+			}
+			if !equalStrings(prefixes, test.expectedPrefixes) {
+				t.Errorf("wrong list of prefixes for %+v\nwant %v\ngot  %v", test.options, test.expectedPrefixes, prefixes)
+			}
+		}
+	})
+}
+
+func TestMemoryBucketIndexRemoval(t *testing.T) {
+	now := time.Now()
+	bucket := newBucketInMemory(Bucket{Name: "some-bucket"}, now)
+	for _, name := range []string{"a", "img/1", "img/2", "z"} {
+		bucket.addObject(Object{ObjectAttrs: ObjectAttrs{BucketName: "some-bucket", Name: name}}, now)
+	}
+	bucket.deleteObject("img/2", now)
+	if bucket.index.find("img/2") != nil {
+		t.Error("deleted object without versions left in the index")
+	}
+	if bucket.index.length != 3 {
+		t.Errorf("wrong index length\nwant 3\ngot  %d", bucket.index.length)
+	}
+}
+
+func objectNames(objs []ObjectAttrs) []string {
+	names := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		names = append(names, obj.Name)
+	}
+	return names
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestObjectMetageneration(t *testing.T) {
+	const bucketName = "some-bucket"
+	const objectName = "some-object.txt"
+	testForStorageBackends(t, func(t *testing.T, storage Storage) {
+		attrs, err := storage.CreateObject(ObjectAttrs{BucketName: bucketName, Name: objectName}, bytes.NewReader([]byte("content")))
+		noError(t, err)
+		if attrs.Metageneration != 1 {
+			t.Errorf("wrong metageneration after creation\nwant 1\ngot  %d", attrs.Metageneration)
+		}
+		value := "value"
+		for i := int64(2); i <= 3; i++ {
+			attrs, err = storage.PatchObject(bucketName, objectName, ObjectPatch{Metadata: map[string]*string{"key": &value}})
+			noError(t, err)
+			if attrs.Metageneration != i {
+				t.Errorf("wrong metageneration after patch\nwant %d\ngot  %d", i, attrs.Metageneration)
+			}
+		}
+		obj, err := storage.GetObject(bucketName, objectName)
+		noError(t, err)
+		obj.Content.Close()
+		if obj.Metageneration != 3 {
+			t.Errorf("wrong metageneration stored\nwant 3\ngot  %d", obj.Metageneration)
+		}
+		attrs, err = storage.CreateObject(ObjectAttrs{BucketName: bucketName, Name: objectName}, bytes.NewReader([]byte("new content")))
+		noError(t, err)
+		if attrs.Metageneration != 1 {
+			t.Errorf("wrong metageneration after overwrite\nwant 1\ngot  %d", attrs.Metageneration)
+		}
+	})
+}
+
+func TestObjectMetadataAttrs(t *testing.T) {
+	const bucketName = "some-bucket"
+	const objectName = "some-object.txt"
+	testForStorageBackends(t, func(t *testing.T, storage Storage) {
+		_, err := storage.CreateObject(ObjectAttrs{
+			BucketName:         bucketName,
+			Name:               objectName,
+			ContentDisposition: "attachment",
+			ContentLanguage:    "en",
+			CacheControl:       "no-cache",
+			StorageClass:       "NEARLINE",
+			CustomTime:         "2021-01-02T03:04:05.000000Z",
+			Metadata:           map[string]string{"a": "1"},
+		}, bytes.NewReader([]byte("content")))
+		noError(t, err)
+
+		language := "pt-BR"
+		hold := true
+		value := "2"
+		attrs, err := storage.PatchObject(bucketName, objectName, ObjectPatch{
+			ContentLanguage: &language,
+			TemporaryHold:   &hold,
+			Metadata:        map[string]*string{"b": &value},
+		})
+		noError(t, err)
+
+		obj, err := storage.GetObject(bucketName, objectName)
+		noError(t, err)
+		obj.Content.Close()
+		for _, got := range []ObjectAttrs{attrs, obj.ObjectAttrs} {
+			if got.ContentDisposition != "attachment" || got.CacheControl != "no-cache" || got.StorageClass != "NEARLINE" {
+				t.Errorf("unexpected change to unpatched fields: %+v", got)
+			}
+			if got.CustomTime != "2021-01-02T03:04:05.000000Z" {
+				t.Errorf("wrong custom time\nwant %q\ngot  %q", "2021-01-02T03:04:05.000000Z", got.CustomTime)
+			}
+			if got.ContentLanguage != language {
+				t.Errorf("wrong content language\nwant %q\ngot  %q", language, got.ContentLanguage)
+			}
+			if !got.TemporaryHold {
+				t.Error("expected a temporary hold on the object")
+			}
+			if len(got.Metadata) != 2 {
+				t.Errorf("wrong metadata: %v", got.Metadata)
+			}
+		}
+	})
+}
+
+func TestPatchObjectMetadata(t *testing.T) {
+	const bucketName = "some-bucket"
+	const objectName = "some-object.txt"
+	value := "new"
+	tests := []struct {
+		name     string
+		patch    ObjectPatch
+		expected map[string]string
+	}{
+		{
+			"merge",
+			ObjectPatch{Metadata: map[string]*string{"b": &value, "c": &value}},
+			map[string]string{"a": "1", "b": "new", "c": "new"},
+		},
+		{
+			"remove key",
+			ObjectPatch{Metadata: map[string]*string{"a": nil}},
+			map[string]string{"b": "2"},
+		},
+		{
+			"replace",
+			ObjectPatch{Metadata: map[string]*string{"c": &value, "a": nil}, ReplaceMetadata: true},
+			map[string]string{"c": "new"},
+		},
+		{
+			"replace with nothing",
+			ObjectPatch{ReplaceMetadata: true},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			testForStorageBackends(t, func(t *testing.T, storage Storage) {
+				_, err := storage.CreateObject(ObjectAttrs{
+					BucketName: bucketName,
+					Name:       objectName,
+					Metadata:   map[string]string{"a": "1", "b": "2"},
+				}, bytes.NewReader([]byte("content")))
+				noError(t, err)
+				attrs, err := storage.PatchObject(bucketName, objectName, test.patch)
+				noError(t, err)
+				if !reflect.DeepEqual(attrs.Metadata, test.expected) {
+					t.Errorf("wrong metadata\nwant %v\ngot  %v", test.expected, attrs.Metadata)
+				}
+			})
+		})
+	}
+}
+
+func TestBucketUpdate(t *testing.T) {
+	const bucketName = "some-bucket"
+	testForStorageBackends(t, func(t *testing.T, storage Storage) {
+		noError(t, storage.CreateBucket(Bucket{
+			Name:         bucketName,
+			Location:     "EU",
+			StorageClass: "STANDARD",
+			Labels:       map[string]string{"env": "test", "team": "storage"},
+		}))
+		versioning := true
+		storageClass := "NEARLINE"
+		owner := "someone"
+		age := int64(30)
+		lifecycle := Lifecycle{Rules: []LifecycleRule{{
+			Action:    LifecycleAction{Type: "Delete"},
+			Condition: LifecycleCondition{Age: &age, MatchesPrefix: []string{"logs/"}},
+		}}}
+		bucket, err := storage.UpdateBucket(bucketName, BucketPatch{
+			VersioningEnabled: &versioning,
+			StorageClass:      &storageClass,
+			Labels:            map[string]*string{"team": nil, "owner": &owner},
+			Lifecycle:         &lifecycle,
+		})
+		noError(t, err)
+		stored, err := storage.GetBucket(bucketName)
+		noError(t, err)
+		for _, got := range []Bucket{bucket, stored} {
+			if !got.VersioningEnabled {
+				t.Error("versioning wasn't enabled")
+			}
+			if got.StorageClass != storageClass {
+				t.Errorf("wrong storage class\nwant %q\ngot  %q", storageClass, got.StorageClass)
+			}
+			if got.Location != "EU" {
+				t.Errorf("wrong location\nwant %q\ngot  %q", "EU", got.Location)
+			}
+			expectedLabels := map[string]string{"env": "test", "owner": "someone"}
+			if !reflect.DeepEqual(got.Labels, expectedLabels) {
+				t.Errorf("wrong labels\nwant %v\ngot  %v", expectedLabels, got.Labels)
+			}
+			if !reflect.DeepEqual(got.Lifecycle, lifecycle) {
+				t.Errorf("wrong lifecycle\nwant %+v\ngot  %+v", lifecycle, got.Lifecycle)
+			}
+			if got.Metageneration != 2 {
+				t.Errorf("wrong metageneration\nwant 2\ngot  %d", got.Metageneration)
+			}
+			if got.Updated.Before(got.TimeCreated) {
+				t.Errorf("update time %s before creation time %s", got.Updated, got.TimeCreated)
+			}
+		}
+
+		_, err = storage.UpdateBucket("other-bucket", BucketPatch{})
+		if err != BucketNotFound {
+			t.Errorf("wrong error updating a missing bucket\nwant %v\ngot  %v", BucketNotFound, err)
+		}
+	})
+}
+
+func TestBucketRetentionPolicy(t *testing.T) {
+	const bucketName = "some-bucket"
+	testForStorageBackends(t, func(t *testing.T, storage Storage) {
+		noError(t, storage.CreateBucket(Bucket{Name: bucketName}))
+		policy := RetentionPolicy{
+			RetentionPeriod: 3600,
+			EffectiveTime:   time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+			IsLocked:        true,
+		}
+		hold := true
+		_, err := storage.UpdateBucket(bucketName, BucketPatch{RetentionPolicy: &policy, DefaultEventBasedHold: &hold})
+		noError(t, err)
+		bucket, err := storage.GetBucket(bucketName)
+		noError(t, err)
+		if bucket.RetentionPolicy == nil || !reflect.DeepEqual(*bucket.RetentionPolicy, policy) {
+			t.Errorf("wrong retention policy\nwant %+v\ngot  %+v", policy, bucket.RetentionPolicy)
+		}
+		if !bucket.DefaultEventBasedHold {
+			t.Error("default event-based hold wasn't enabled")
+		}
+
+		_, err = storage.UpdateBucket(bucketName, BucketPatch{RetentionPolicy: &RetentionPolicy{}})
+		noError(t, err)
+		bucket, err = storage.GetBucket(bucketName)
+		noError(t, err)
+		if bucket.RetentionPolicy != nil {
+			t.Errorf("retention policy wasn't removed: %+v", bucket.RetentionPolicy)
+		}
+	})
+}
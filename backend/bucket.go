@@ -0,0 +1,168 @@
+// Copyright 2019 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import "time"
+
+// Bucket represents the bucket that is stored within the fake server.
+type Bucket struct {
+	Name              string
+	VersioningEnabled bool
+	TimeCreated       time.Time
+	Updated           time.Time
+	Metageneration    int64
+	// Location and StorageClass are empty for buckets created without them,
+	// in which case the server reports the GCS defaults.
+	Location     string
+	StorageClass string
+	Labels       map[string]string
+	Lifecycle    Lifecycle
+
+	// RetentionPolicy is nil for buckets without a retention policy.
+	RetentionPolicy *RetentionPolicy `json:",omitempty"`
+
+	// DefaultEventBasedHold is set for buckets whose new objects are
+	// placed under an event-based hold.
+	DefaultEventBasedHold bool
+}
+
+// RetentionPolicy is the retention policy of a bucket, which prevents its
+// objects from being deleted or replaced for the retention period after
+// their creation. Locked policies can't be removed or have their retention
+// period reduced.
+type RetentionPolicy struct {
+	// RetentionPeriod is the retention period, in seconds.
+	RetentionPeriod int64
+	EffectiveTime   time.Time
+	IsLocked        bool
+}
+
+// Lifecycle holds the lifecycle rules of a bucket, which the server applies
+// to its objects.
+type Lifecycle struct {
+	Rules []LifecycleRule
+}
+
+// LifecycleRule is a lifecycle rule of a bucket: the action is taken on the
+// objects matching all the conditions.
+type LifecycleRule struct {
+	Action    LifecycleAction
+	Condition LifecycleCondition
+}
+
+// LifecycleAction is the action of a lifecycle rule. Type is either "Delete"
+// or "SetStorageClass", in which case StorageClass holds the storage class to
+// set on the matching objects.
+type LifecycleAction struct {
+	Type         string
+	StorageClass string
+}
+
+// LifecycleCondition holds the conditions of a lifecycle rule. Unset fields
+// match every object.
+type LifecycleCondition struct {
+	// Age is the number of days since the creation of the object.
+	Age *int64 `json:",omitempty"`
+
+	// CreatedBefore is a date in the YYYY-MM-DD format, matching objects
+	// created before midnight of that date, in UTC.
+	CreatedBefore string `json:",omitempty"`
+
+	// DaysSinceCustomTime is the number of days since the custom time of
+	// the object. Objects without a custom time don't match it.
+	DaysSinceCustomTime *int64 `json:",omitempty"`
+
+	// IsLive matches live objects when true, and noncurrent versions of
+	// objects when false.
+	IsLive *bool `json:",omitempty"`
+
+	// NumNewerVersions matches versions of objects that have at least the
+	// given number of newer versions.
+	NumNewerVersions int64 `json:",omitempty"`
+
+	MatchesPrefix       []string `json:",omitempty"`
+	MatchesSuffix       []string `json:",omitempty"`
+	MatchesStorageClass []string `json:",omitempty"`
+}
+
+// BucketPatch holds the changes to the attributes of a bucket made by
+// UpdateBucket. Nil fields are left unchanged.
+type BucketPatch struct {
+	VersioningEnabled *bool
+	StorageClass      *string
+	Lifecycle         *Lifecycle
+
+	// RetentionPolicy replaces the retention policy of the bucket, with a
+	// zero retention period removing it.
+	RetentionPolicy       *RetentionPolicy
+	DefaultEventBasedHold *bool
+
+	// Labels is merged into the labels of the bucket, with nil values
+	// removing labels, unless ReplaceLabels is set, in which case the non-nil
+	// values replace all the labels of the bucket.
+	Labels        map[string]*string
+	ReplaceLabels bool
+}
+
+// newBucket returns the bucket to store when creating the given one at the
+// given time, filling the attributes managed by the backend.
+func newBucket(bucket Bucket, now time.Time) Bucket {
+	if bucket.TimeCreated.IsZero() {
+		bucket.TimeCreated = now
+	}
+	bucket.Updated = bucket.TimeCreated
+	bucket.Metageneration = 1
+	return bucket
+}
+
+// apply patches the given bucket, bumping its metageneration and setting
+// its update time.
+func (p BucketPatch) apply(bucket *Bucket, updated time.Time) {
+	if p.VersioningEnabled != nil {
+		bucket.VersioningEnabled = *p.VersioningEnabled
+	}
+	setIfNotNil(&bucket.StorageClass, p.StorageClass)
+	if p.Lifecycle != nil {
+		bucket.Lifecycle = *p.Lifecycle
+	}
+	if p.RetentionPolicy != nil {
+		bucket.RetentionPolicy = nil
+		if p.RetentionPolicy.RetentionPeriod > 0 {
+			policy := *p.RetentionPolicy
+			bucket.RetentionPolicy = &policy
+		}
+	}
+	if p.DefaultEventBasedHold != nil {
+		bucket.DefaultEventBasedHold = *p.DefaultEventBasedHold
+	}
+	if len(p.Labels) > 0 || p.ReplaceLabels {
+		bucket.Labels = mergeMap(bucket.Labels, p.Labels, p.ReplaceLabels)
+	}
+	bucket.Metageneration++
+	bucket.Updated = updated
+}
+
+// mergeMap merges the changes into the given map, as described in
+// ObjectPatch and BucketPatch, returning a new map or nil if it ends up
+// empty.
+func mergeMap(current map[string]string, changes map[string]*string, replace bool) map[string]string {
+	merged := make(map[string]string, len(current)+len(changes))
+	if !replace {
+		for k, v := range current {
+			merged[k] = v
+		}
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = *v
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
@@ -0,0 +1,776 @@
+// Copyright 2018 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// storageFS is an implementation of the backend storage that stores data on disk
+//
+// The layout is the following:
+//
+// - rootDir
+//   |- #uploads
+//   |  |- upload1
+//   |  \- upload1#metadata
+//   |- bucket1
+//   \- bucket2
+//     |- #bucket
+//     |- object1
+//     |- object1#metadata
+//     |- object1#1600000000000000
+//     |- object1#1600000000000000#metadata
+//     |- object2
+//     \- object2#metadata
+//
+// Content being created is first written to a temporary "#upload" file in
+// the bucket directory and only then moved to its final location.
+//
+// The "#uploads" directory holds the sessions of resumable uploads: the
+// content received so far, and the session itself as JSON in the sibling file
+// with the "#metadata" suffix. Chunks are first written to a temporary
+// "#chunk" file, and only appended to the content once fully received.
+//
+// Bucket and object names are url path escaped, so there's no special meaning of forward slashes.
+// Escaped names never contain a "#", so it's used to name the file holding the
+// bucket attributes and to separate the object name from the generation of
+// archived objects.
+//
+// The content of each object is stored as is, and its attributes are stored
+// as JSON in the sibling file with the "#metadata" suffix, so listing objects
+// and reading their attributes never touch their content. Objects stored by
+// older versions of the server as a single JSON file, holding both the
+// attributes and the base64-encoded content, are converted to this layout
+// when the backend is created.
+type storageFS struct {
+	rootDir    string
+	mtx        sync.RWMutex
+	uploadsMtx sync.Mutex
+	now        func() time.Time
+}
+
+const (
+	bucketAttrsFile     = "#bucket"
+	generationSeparator = "#"
+	metadataSuffix      = "#metadata"
+	uploadFilePattern   = "#upload*"
+	uploadsDir          = "#uploads"
+	chunkFilePattern    = "#chunk*"
+)
+
+// NewStorageFS creates an instance of the filesystem-backed storage backend.
+func NewStorageFS(objects []Object, rootDir string) (Storage, error) {
+	return newStorageFS(objects, rootDir, time.Now)
+}
+
+// newStorageFS creates an instance of the filesystem-backed storage backend
+// that uses the given function to get the current time.
+func newStorageFS(objects []Object, rootDir string, now func() time.Time) (Storage, error) {
+	if !strings.HasSuffix(rootDir, "/") {
+		rootDir += "/"
+	}
+	err := os.MkdirAll(rootDir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	s := &storageFS{rootDir: rootDir, now: now}
+	err = s.migrateLegacyObjects()
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range objects {
+		_, err := s.CreateObject(o.ObjectAttrs, bytes.NewReader(o.Content))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *storageFS) bucketDir(bucketName string) string {
+	return filepath.Join(s.rootDir, url.PathEscape(bucketName))
+}
+
+func (s *storageFS) objectPath(bucketName, objectName string) string {
+	return filepath.Join(s.bucketDir(bucketName), url.PathEscape(objectName))
+}
+
+func (s *storageFS) archivedObjectPath(bucketName, objectName string, generation int64) string {
+	return s.objectPath(bucketName, objectName) + generationSeparator + strconv.FormatInt(generation, 10)
+}
+
+// CreateBucket creates a bucket in the fs backend. A bucket is a folder in the
+// root directory.
+func (s *storageFS) CreateBucket(bucket Bucket) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, err := s.getBucket(bucket.Name); err == nil {
+		return nil
+	}
+	return s.createBucket(bucket)
+}
+
+func (s *storageFS) createBucket(bucket Bucket) error {
+	err := os.MkdirAll(s.bucketDir(bucket.Name), 0o700)
+	if err != nil {
+		return err
+	}
+	return s.writeBucketAttrs(newBucket(bucket, s.now()))
+}
+
+func (s *storageFS) writeBucketAttrs(bucket Bucket) error {
+	encoded, err := json.Marshal(bucket)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.bucketDir(bucket.Name), bucketAttrsFile), encoded, 0o600)
+}
+
+// UpdateBucket patches the attributes of the bucket, storing them in its
+// attributes file.
+func (s *storageFS) UpdateBucket(name string, patch BucketPatch) (Bucket, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	bucket, err := s.getBucket(name)
+	if err != nil {
+		return Bucket{}, BucketNotFound
+	}
+	patch.apply(&bucket, s.now())
+	return bucket, s.writeBucketAttrs(bucket)
+}
+
+// ListBuckets returns a list of buckets from the list of directories in the
+// root directory.
+func (s *storageFS) ListBuckets() ([]Bucket, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	infos, err := ioutil.ReadDir(s.rootDir)
+	if err != nil {
+		return nil, err
+	}
+	buckets := []Bucket{}
+	for _, info := range infos {
+		if info.IsDir() && info.Name() != uploadsDir {
+			unescaped, err := url.PathUnescape(info.Name())
+			if err != nil {
+				return nil, fmt.Errorf("failed to unescape object name %s: %w", info.Name(), err)
+			}
+			bucket, err := s.getBucket(unescaped)
+			if err != nil {
+				return nil, err
+			}
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets, nil
+}
+
+func timespecToTime(ts syscall.Timespec) time.Time {
+	return time.Unix(int64(ts.Sec), int64(ts.Nsec))
+}
+
+// GetBucket returns information about the given bucket, or an error if it
+// doesn't exist.
+func (s *storageFS) GetBucket(name string) (Bucket, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.getBucket(name)
+}
+
+func (s *storageFS) getBucket(name string) (Bucket, error) {
+	dirInfo, err := os.Stat(s.bucketDir(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return Bucket{}, BucketNotFound
+	}
+	if err != nil {
+		return Bucket{}, err
+	}
+	created := timespecToTime(createTimeFromFileInfo(dirInfo))
+	bucket := Bucket{Name: name, VersioningEnabled: false, TimeCreated: created, Updated: created, Metageneration: 1}
+	encoded, err := ioutil.ReadFile(filepath.Join(s.bucketDir(name), bucketAttrsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		// buckets created outside of the server (or by older versions of
+		// it) don't have an attributes file.
+		return bucket, nil
+	}
+	if err != nil {
+		return Bucket{}, err
+	}
+	err = json.Unmarshal(encoded, &bucket)
+	if err != nil {
+		return Bucket{}, err
+	}
+	bucket.Name = name
+	return bucket, nil
+}
+
+// DeleteBucket removes the bucket from the backend.
+func (s *storageFS) DeleteBucket(name string) error {
+	objs, _, err := s.ListObjects(name, ListOptions{Versions: true})
+	if err != nil {
+		return BucketNotFound
+	}
+	if len(objs) > 0 {
+		return BucketNotEmpty
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return os.RemoveAll(s.bucketDir(name))
+}
+
+// CreateObject stores an object as a regular file in the disk, next to the
+// file holding its attributes. If versioning is enabled in the bucket, the
+// current generation of the object is moved to the archive.
+func (s *storageFS) CreateObject(attrs ObjectAttrs, content io.Reader) (ObjectAttrs, error) {
+	bucket, err := s.getOrCreateBucket(attrs.BucketName)
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	tempFile, err := ioutil.TempFile(s.bucketDir(attrs.BucketName), uploadFilePattern)
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	defer os.Remove(tempFile.Name())
+	err = CopyWithChecksums(tempFile, content, &attrs)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	attrs.Generation = getNewGenerationIfZero(attrs.Generation)
+	attrs.Metageneration = getInitialMetagenerationIfZero(attrs.Metageneration)
+	if bucket.VersioningEnabled {
+		err = s.archiveObject(attrs.BucketName, attrs.Name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return ObjectAttrs{}, err
+		}
+	}
+	path := s.objectPath(attrs.BucketName, attrs.Name)
+	err = os.Rename(tempFile.Name(), path)
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	return attrs, s.writeObjectAttrs(attrs, path)
+}
+
+func (s *storageFS) getOrCreateBucket(name string) (Bucket, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	bucket, err := s.getBucket(name)
+	if err != nil {
+		bucket = Bucket{Name: name}
+		err = s.createBucket(bucket)
+	}
+	return bucket, err
+}
+
+func (s *storageFS) writeObjectAttrs(attrs ObjectAttrs, path string) error {
+	encoded, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+metadataSuffix, encoded, 0o600)
+}
+
+// archiveObject moves the current generation of the given object to the
+// archive, flagging it as deleted.
+func (s *storageFS) archiveObject(bucketName, objectName string) error {
+	path := s.objectPath(bucketName, objectName)
+	attrs, err := s.readObjectAttrs(path, bucketName, objectName)
+	if err != nil {
+		return err
+	}
+	attrs.Deleted = s.now().Format(timestampFormat)
+	archivedPath := s.archivedObjectPath(bucketName, objectName, attrs.Generation)
+	err = os.Rename(path, archivedPath)
+	if err != nil {
+		return err
+	}
+	err = s.writeObjectAttrs(attrs, archivedPath)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path + metadataSuffix)
+}
+
+// legacyObject is the format of the objects stored by older versions of the
+// backend, in a single JSON file.
+type legacyObject struct {
+	ObjectAttrs
+	Content json.RawMessage
+}
+
+// migrateLegacyObjects converts the objects stored in the legacy format,
+// which are the ones without a metadata file, to the current layout.
+func (s *storageFS) migrateLegacyObjects() error {
+	bucketInfos, err := ioutil.ReadDir(s.rootDir)
+	if err != nil {
+		return err
+	}
+	for _, bucketInfo := range bucketInfos {
+		if !bucketInfo.IsDir() || bucketInfo.Name() == uploadsDir {
+			continue
+		}
+		dir := filepath.Join(s.rootDir, bucketInfo.Name())
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), "#") || strings.HasSuffix(info.Name(), metadataSuffix) {
+				continue
+			}
+			path := filepath.Join(dir, info.Name())
+			if _, err := os.Stat(path + metadataSuffix); !errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			err = s.migrateLegacyObject(path)
+			if err != nil {
+				return fmt.Errorf("failed to migrate object %s: %w", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// migrateLegacyObject rewrites the given legacy object file with the content
+// of the object, storing its attributes in the metadata file. Files that
+// aren't legacy objects are left untouched.
+func (s *storageFS) migrateLegacyObject(path string) error {
+	encoded, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var obj legacyObject
+	if json.Unmarshal(encoded, &obj) != nil || obj.Content == nil {
+		return nil
+	}
+	var content []byte
+	err = json.Unmarshal(obj.Content, &content)
+	if err != nil {
+		return err
+	}
+	attrs := obj.ObjectAttrs
+	attrs.Generation = getNewGenerationIfZero(attrs.Generation)
+	attrs.Metageneration = getInitialMetagenerationIfZero(attrs.Metageneration)
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), uploadFilePattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	err = CopyWithChecksums(tempFile, bytes.NewReader(content), &attrs)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = s.writeObjectAttrs(attrs, path)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), path)
+}
+
+// ListObjects lists the objects in a given bucket that match the given
+// options. Object names are filtered using the file names, so only the
+// metadata of matching objects is decoded.
+func (s *storageFS) ListObjects(bucketName string, options ListOptions) ([]ObjectAttrs, []string, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	infos, err := ioutil.ReadDir(s.bucketDir(bucketName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, BucketNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	lister := newObjectLister(options)
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name(), metadataSuffix) {
+			continue
+		}
+		blobName := strings.TrimSuffix(info.Name(), metadataSuffix)
+		escaped := blobName
+		if i := strings.Index(escaped, generationSeparator); i > -1 {
+			if !options.Versions {
+				continue
+			}
+			escaped = escaped[:i]
+		}
+		unescaped, err := url.PathUnescape(escaped)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to unescape object name %s: %w", info.Name(), err)
+		}
+		if !lister.matches(unescaped) {
+			continue
+		}
+		if prefix, ok := lister.commonPrefix(unescaped); ok {
+			lister.addPrefix(prefix)
+			continue
+		}
+		attrs, err := s.readObjectAttrs(filepath.Join(s.bucketDir(bucketName), blobName), bucketName, unescaped)
+		if err != nil {
+			return nil, nil, err
+		}
+		lister.addObject(attrs)
+	}
+	objects, prefixes := lister.result()
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].Name != objects[j].Name {
+			return objects[i].Name < objects[j].Name
+		}
+		return objects[i].Generation < objects[j].Generation
+	})
+	return objects, prefixes, nil
+}
+
+// GetObject get an object by bucket and name.
+func (s *storageFS) GetObject(bucketName, objectName string) (StreamingObject, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.openObject(s.objectPath(bucketName, objectName), bucketName, objectName)
+}
+
+// GetObjectWithGeneration retrieves an specific version of the object.
+func (s *storageFS) GetObjectWithGeneration(bucketName, objectName string, generation int64) (StreamingObject, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	path, err := s.generationPath(bucketName, objectName, generation)
+	if err != nil {
+		return StreamingObject{}, err
+	}
+	return s.openObject(path, bucketName, objectName)
+}
+
+// generationPath returns the path of the file storing the given generation of
+// the object, which is either the current or an archived one.
+func (s *storageFS) generationPath(bucketName, objectName string, generation int64) (string, error) {
+	path := s.objectPath(bucketName, objectName)
+	attrs, err := s.readObjectAttrs(path, bucketName, objectName)
+	if generation == 0 || (err == nil && attrs.Generation == generation) {
+		return path, err
+	}
+	return s.archivedObjectPath(bucketName, objectName, generation), nil
+}
+
+// openObject reads the attributes of the object and opens the file holding
+// its content, leaving it to be streamed by the caller.
+func (s *storageFS) openObject(path, bucketName, objectName string) (StreamingObject, error) {
+	attrs, err := s.readObjectAttrs(path, bucketName, objectName)
+	if err != nil {
+		return StreamingObject{}, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return StreamingObject{}, err
+	}
+	return StreamingObject{ObjectAttrs: attrs, Content: file}, nil
+}
+
+func (s *storageFS) readObjectAttrs(path, bucketName, objectName string) (ObjectAttrs, error) {
+	encoded, err := ioutil.ReadFile(path + metadataSuffix)
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	var attrs ObjectAttrs
+	err = json.Unmarshal(encoded, &attrs)
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	attrs.Name = filepath.ToSlash(objectName)
+	attrs.BucketName = bucketName
+	attrs.Metageneration = getInitialMetagenerationIfZero(attrs.Metageneration)
+	return attrs, nil
+}
+
+// DeleteObject deletes an object by bucket and name. If versioning is enabled
+// in the bucket, the object is moved to the archive instead.
+func (s *storageFS) DeleteObject(bucketName, objectName string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if objectName == "" {
+		return errors.New("can't delete object with empty name")
+	}
+	bucket, err := s.getBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	if bucket.VersioningEnabled {
+		return s.archiveObject(bucketName, objectName)
+	}
+	return s.removeObject(s.objectPath(bucketName, objectName))
+}
+
+// DeleteObjectWithGeneration permanently deletes a specific version of the
+// object, be it the current or an archived one.
+func (s *storageFS) DeleteObjectWithGeneration(bucketName, objectName string, generation int64) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if objectName == "" {
+		return errors.New("can't delete object with empty name")
+	}
+	path, err := s.generationPath(bucketName, objectName, generation)
+	if err != nil {
+		return err
+	}
+	return s.removeObject(path)
+}
+
+// removeObject removes the attributes of the object before its content, so
+// that a failure never leaves attributes pointing to missing content.
+func (s *storageFS) removeObject(path string) error {
+	err := os.Remove(path + metadataSuffix)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// PatchObject patches the given object metadata.
+func (s *storageFS) PatchObject(bucketName, objectName string, patch ObjectPatch) (ObjectAttrs, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	path := s.objectPath(bucketName, objectName)
+	attrs, err := s.readObjectAttrs(path, bucketName, objectName)
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	patch.apply(&attrs, s.now().Format(timestampFormat))
+	return attrs, s.writeObjectAttrs(attrs, path)
+}
+
+func (s *storageFS) uploadPath(id string) (string, error) {
+	escaped := url.PathEscape(id)
+	if escaped == "" || escaped == "." || escaped == ".." {
+		return "", UploadNotFound
+	}
+	return filepath.Join(s.rootDir, uploadsDir, escaped), nil
+}
+
+// CreateUpload stores the session of a resumable upload, along with an empty
+// file for its content.
+func (s *storageFS) CreateUpload(upload Upload) error {
+	path, err := s.uploadPath(upload.ID)
+	if err != nil {
+		return err
+	}
+	s.uploadsMtx.Lock()
+	defer s.uploadsMtx.Unlock()
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, nil, 0o600)
+	if err != nil {
+		return err
+	}
+	return s.writeUpload(path, upload)
+}
+
+func (s *storageFS) writeUpload(path string, upload Upload) error {
+	encoded, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+metadataSuffix, encoded, 0o600)
+}
+
+func (s *storageFS) readUpload(path string) (Upload, error) {
+	encoded, err := ioutil.ReadFile(path + metadataSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return Upload{}, UploadNotFound
+	}
+	if err != nil {
+		return Upload{}, err
+	}
+	var upload Upload
+	err = json.Unmarshal(encoded, &upload)
+	return upload, err
+}
+
+// ListUploads returns every upload stored in the uploads directory, sorted
+// by ID.
+func (s *storageFS) ListUploads() ([]Upload, error) {
+	s.uploadsMtx.Lock()
+	defer s.uploadsMtx.Unlock()
+	infos, err := ioutil.ReadDir(filepath.Join(s.rootDir, uploadsDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return []Upload{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	uploads := []Upload{}
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name(), metadataSuffix) {
+			continue
+		}
+		path := filepath.Join(s.rootDir, uploadsDir, strings.TrimSuffix(info.Name(), metadataSuffix))
+		upload, err := s.readUpload(path)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].ID < uploads[j].ID
+	})
+	return uploads, nil
+}
+
+func (s *storageFS) GetUpload(id string) (Upload, error) {
+	path, err := s.uploadPath(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	s.uploadsMtx.Lock()
+	defer s.uploadsMtx.Unlock()
+	return s.readUpload(path)
+}
+
+// UpdateUpload updates the attributes of the upload, keeping the size and
+// completion state it has in the storage.
+func (s *storageFS) UpdateUpload(upload Upload) error {
+	path, err := s.uploadPath(upload.ID)
+	if err != nil {
+		return err
+	}
+	s.uploadsMtx.Lock()
+	defer s.uploadsMtx.Unlock()
+	stored, err := s.readUpload(path)
+	if err != nil {
+		return err
+	}
+	upload.Size = stored.Size
+	upload.Completed = stored.Completed
+	return s.writeUpload(path, upload)
+}
+
+// AppendUpload spools the chunk to a temporary file, and only appends it to
+// the content of the upload once it's been fully read, so a failed chunk
+// never leaves partial data behind.
+func (s *storageFS) AppendUpload(id string, offset int64, content io.Reader) (Upload, error) {
+	path, err := s.uploadPath(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	chunk, err := ioutil.TempFile(filepath.Dir(path), chunkFilePattern)
+	if errors.Is(err, os.ErrNotExist) {
+		return Upload{}, UploadNotFound
+	}
+	if err != nil {
+		return Upload{}, err
+	}
+	defer os.Remove(chunk.Name())
+	defer chunk.Close()
+	n, readErr := io.Copy(chunk, content)
+
+	s.uploadsMtx.Lock()
+	defer s.uploadsMtx.Unlock()
+	upload, err := s.readUpload(path)
+	if err != nil {
+		return Upload{}, err
+	}
+	if upload.Completed != nil {
+		return Upload{}, UploadCompleted
+	}
+	if offset != upload.Size {
+		return Upload{}, UploadOffsetInvalid
+	}
+	if readErr != nil {
+		return Upload{}, readErr
+	}
+	_, err = chunk.Seek(0, io.SeekStart)
+	if err != nil {
+		return Upload{}, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY, 0o600)
+	if err != nil {
+		return Upload{}, err
+	}
+	defer file.Close()
+	_, err = file.Seek(upload.Size, io.SeekStart)
+	if err == nil {
+		_, err = io.Copy(file, chunk)
+	}
+	if err != nil {
+		file.Truncate(upload.Size)
+		return Upload{}, err
+	}
+	upload.Size += n
+	return upload, s.writeUpload(path, upload)
+}
+
+func (s *storageFS) OpenUpload(id string) (ReadSeekCloser, error) {
+	path, err := s.uploadPath(id)
+	if err != nil {
+		return nil, err
+	}
+	s.uploadsMtx.Lock()
+	defer s.uploadsMtx.Unlock()
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, UploadNotFound
+	}
+	return file, err
+}
+
+func (s *storageFS) CompleteUpload(id string, attrs ObjectAttrs) (Upload, error) {
+	path, err := s.uploadPath(id)
+	if err != nil {
+		return Upload{}, err
+	}
+	s.uploadsMtx.Lock()
+	defer s.uploadsMtx.Unlock()
+	upload, err := s.readUpload(path)
+	if err != nil {
+		return Upload{}, err
+	}
+	upload.Completed = &attrs
+	err = ioutil.WriteFile(path, nil, 0o600)
+	if err != nil {
+		return Upload{}, err
+	}
+	return upload, s.writeUpload(path, upload)
+}
+
+func (s *storageFS) DeleteUpload(id string) error {
+	path, err := s.uploadPath(id)
+	if err != nil {
+		return err
+	}
+	s.uploadsMtx.Lock()
+	defer s.uploadsMtx.Unlock()
+	err = os.Remove(path + metadataSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return UploadNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"math/rand"
+	"sort"
+)
+
+const (
+	maxIndexLevel   = 32
+	indexLevelRatio = 4
+)
+
+// objectIndex is a skip list that keeps the objects of a bucket ordered by
+// name, so lookups, inserts and removals are logarithmic and listing a range
+// of names only visits the entries within that range.
+type objectIndex struct {
+	head   indexEntry
+	level  int
+	length int
+	rnd    *rand.Rand
+}
+
+// indexEntry holds every generation of the object with the given name: the
+// live one (nil if the object has been deleted or overwritten with versioning
+// enabled) and the archived ones, sorted by generation.
+type indexEntry struct {
+	name     string
+	live     *Object
+	archived []Object
+	next     []*indexEntry
+}
+
+func newObjectIndex() *objectIndex {
+	return &objectIndex{
+		head:  indexEntry{next: make([]*indexEntry, maxIndexLevel)},
+		level: 1,
+		rnd:   rand.New(rand.NewSource(1)),
+	}
+}
+
+// find returns the entry with the given name, or nil if there's no such entry.
+func (idx *objectIndex) find(name string) *indexEntry {
+	entry := idx.seek(name)
+	if entry != nil && entry.name == name {
+		return entry
+	}
+	return nil
+}
+
+// seek returns the first entry with a name greater than or equal to the given
+// one, or nil if there's no such entry.
+func (idx *objectIndex) seek(name string) *indexEntry {
+	entry := &idx.head
+	for level := idx.level - 1; level >= 0; level-- {
+		for entry.next[level] != nil && entry.next[level].name < name {
+			entry = entry.next[level]
+		}
+	}
+	return entry.next[0]
+}
+
+// getOrInsert returns the entry with the given name, inserting an empty entry
+// in the index if the name is not there yet.
+func (idx *objectIndex) getOrInsert(name string) *indexEntry {
+	var update [maxIndexLevel]*indexEntry
+	entry := &idx.head
+	for level := idx.level - 1; level >= 0; level-- {
+		for entry.next[level] != nil && entry.next[level].name < name {
+			entry = entry.next[level]
+		}
+		update[level] = entry
+	}
+	if next := entry.next[0]; next != nil && next.name == name {
+		return next
+	}
+	level := idx.randomLevel()
+	if level > idx.level {
+		for i := idx.level; i < level; i++ {
+			update[i] = &idx.head
+		}
+		idx.level = level
+	}
+	newEntry := &indexEntry{name: name, next: make([]*indexEntry, level)}
+	for i := 0; i < level; i++ {
+		newEntry.next[i] = update[i].next[i]
+		update[i].next[i] = newEntry
+	}
+	idx.length++
+	return newEntry
+}
+
+// remove removes the entry with the given name from the index.
+func (idx *objectIndex) remove(name string) {
+	var update [maxIndexLevel]*indexEntry
+	entry := &idx.head
+	for level := idx.level - 1; level >= 0; level-- {
+		for entry.next[level] != nil && entry.next[level].name < name {
+			entry = entry.next[level]
+		}
+		update[level] = entry
+	}
+	target := entry.next[0]
+	if target == nil || target.name != name {
+		return
+	}
+	for i := 0; i < len(target.next); i++ {
+		update[i].next[i] = target.next[i]
+	}
+	for idx.level > 1 && idx.head.next[idx.level-1] == nil {
+		idx.level--
+	}
+	idx.length--
+}
+
+func (idx *objectIndex) randomLevel() int {
+	level := 1
+	for level < maxIndexLevel && idx.rnd.Intn(indexLevelRatio) == 0 {
+		level++
+	}
+	return level
+}
+
+// isEmpty returns whether the entry has no generations left, in which case it
+// can be removed from the index.
+func (e *indexEntry) isEmpty() bool {
+	return e.live == nil && len(e.archived) == 0
+}
+
+// generation returns the object with the given generation, be it the live or
+// an archived one.
+func (e *indexEntry) generation(generation int64) *Object {
+	if e.live != nil && e.live.Generation == generation {
+		return e.live
+	}
+	i := e.archivedIndex(generation)
+	if i < len(e.archived) && e.archived[i].Generation == generation {
+		return &e.archived[i]
+	}
+	return nil
+}
+
+func (e *indexEntry) archive(obj Object) {
+	i := e.archivedIndex(obj.Generation)
+	e.archived = append(e.archived, Object{})
+	copy(e.archived[i+1:], e.archived[i:])
+	e.archived[i] = obj
+}
+
+func (e *indexEntry) removeGeneration(generation int64) bool {
+	if e.live != nil && e.live.Generation == generation {
+		e.live = nil
+		return true
+	}
+	i := e.archivedIndex(generation)
+	if i < len(e.archived) && e.archived[i].Generation == generation {
+		e.archived = append(e.archived[:i], e.archived[i+1:]...)
+		return true
+	}
+	return false
+}
+
+func (e *indexEntry) archivedIndex(generation int64) int {
+	return sort.Search(len(e.archived), func(i int) bool {
+		return e.archived[i].Generation >= generation
+	})
+}
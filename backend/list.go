@@ -0,0 +1,123 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"sort"
+	"strings"
+)
+
+// ListOptions are the criteria used to filter the objects returned by
+// ListObjects.
+type ListOptions struct {
+	// Prefix restricts the results to objects whose names start with it.
+	Prefix string
+
+	// Delimiter rolls up objects whose names contain it after the prefix
+	// into a single prefix, returned instead of the objects.
+	Delimiter string
+
+	// Versions includes the archived generations of objects in the results.
+	Versions bool
+
+	// StartOffset and EndOffset restrict the results to objects whose names
+	// are within the range [StartOffset, EndOffset). Empty offsets don't
+	// bound the range.
+	StartOffset string
+	EndOffset   string
+}
+
+// objectLister accumulates the results of a ListObjects call, rolling up
+// objects into prefixes according to the delimiter.
+type objectLister struct {
+	options  ListOptions
+	objects  []ObjectAttrs
+	prefixes map[string]bool
+}
+
+func newObjectLister(options ListOptions) *objectLister {
+	return &objectLister{
+		options:  options,
+		objects:  []ObjectAttrs{},
+		prefixes: make(map[string]bool),
+	}
+}
+
+// start returns the lowest name that can match the options.
+func (l *objectLister) start() string {
+	if l.options.StartOffset > l.options.Prefix {
+		return l.options.StartOffset
+	}
+	return l.options.Prefix
+}
+
+// matches returns whether an object with the given name passes the prefix
+// and offset filters.
+func (l *objectLister) matches(name string) bool {
+	return strings.HasPrefix(name, l.options.Prefix) && isInOffset(name, l.options.StartOffset, l.options.EndOffset)
+}
+
+// pastEnd returns whether the given name, and any name greater than it, is
+// out of the range defined by the options.
+func (l *objectLister) pastEnd(name string) bool {
+	if l.options.EndOffset != "" && name >= l.options.EndOffset {
+		return true
+	}
+	return name > l.options.Prefix && !strings.HasPrefix(name, l.options.Prefix)
+}
+
+// commonPrefix returns the prefix that an object with the given name is
+// rolled up into, if any.
+func (l *objectLister) commonPrefix(name string) (string, bool) {
+	if l.options.Delimiter == "" {
+		return "", false
+	}
+	pos := strings.Index(name[len(l.options.Prefix):], l.options.Delimiter)
+	if pos < 0 {
+		return "", false
+	}
+	return name[:len(l.options.Prefix)+pos+len(l.options.Delimiter)], true
+}
+
+// addPrefix records a prefix in the results, as long as the prefix itself is
+// within the range defined by the offsets.
+func (l *objectLister) addPrefix(prefix string) {
+	if isInOffset(prefix, l.options.StartOffset, l.options.EndOffset) {
+		l.prefixes[prefix] = true
+	}
+}
+
+func (l *objectLister) addObject(attrs ObjectAttrs) {
+	l.objects = append(l.objects, attrs)
+}
+
+func (l *objectLister) result() ([]ObjectAttrs, []string) {
+	prefixes := make([]string, 0, len(l.prefixes))
+	for prefix := range l.prefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	return l.objects, prefixes
+}
+
+func isInOffset(name, startOffset, endOffset string) bool {
+	if startOffset != "" && name < startOffset {
+		return false
+	}
+	return endOffset == "" || name < endOffset
+}
+
+// prefixEnd returns the lowest string that is greater than every string with
+// the given prefix, or an empty string if there's no such string.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
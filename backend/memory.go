@@ -0,0 +1,330 @@
+// Copyright 2018 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+const timestampFormat = "2006-01-02T15:04:05.999999Z07:00"
+
+// storageMemory is an implementation of the backend storage that stores data
+// in memory, including the sessions of resumable uploads.
+type storageMemory struct {
+	*uploadsInMemory
+	buckets map[string]*bucketInMemory
+	mtx     sync.RWMutex
+	now     func() time.Time
+}
+
+// bucketInMemory keeps the objects of a bucket in a name-ordered index, where
+// each entry holds all generations of an object.
+type bucketInMemory struct {
+	Bucket
+	index *objectIndex
+}
+
+func newBucketInMemory(bucket Bucket, now time.Time) *bucketInMemory {
+	return &bucketInMemory{newBucket(bucket, now), newObjectIndex()}
+}
+
+// addObject stores the object, archiving the live version at the given time
+// if versioning is enabled.
+func (bm *bucketInMemory) addObject(obj Object, now time.Time) Object {
+	obj.Generation = getNewGenerationIfZero(obj.Generation)
+	obj.Metageneration = getInitialMetagenerationIfZero(obj.Metageneration)
+	entry := bm.index.getOrInsert(obj.Name)
+	if entry.live != nil && bm.VersioningEnabled {
+		bm.archive(entry, now)
+	}
+	entry.live = &obj
+	return obj
+}
+
+func getNewGenerationIfZero(generation int64) int64 {
+	if generation == 0 {
+		return time.Now().UnixNano() / 1000
+	}
+	return generation
+}
+
+// getInitialMetagenerationIfZero returns the metageneration of a new object:
+// metagenerations start at 1 and are incremented on every metadata change.
+func getInitialMetagenerationIfZero(metageneration int64) int64 {
+	if metageneration == 0 {
+		return 1
+	}
+	return metageneration
+}
+
+// getObject returns the live version of the object when generation is zero,
+// or the given generation of the object otherwise.
+func (bm *bucketInMemory) getObject(name string, generation int64) *Object {
+	entry := bm.index.find(name)
+	if entry == nil {
+		return nil
+	}
+	if generation == 0 {
+		return entry.live
+	}
+	return entry.generation(generation)
+}
+
+func (bm *bucketInMemory) deleteObject(name string, now time.Time) bool {
+	entry := bm.index.find(name)
+	if entry == nil || entry.live == nil {
+		return false
+	}
+	if bm.VersioningEnabled {
+		bm.archive(entry, now)
+	}
+	entry.live = nil
+	bm.removeIfEmpty(entry)
+	return true
+}
+
+func (bm *bucketInMemory) deleteObjectWithGeneration(name string, generation int64) bool {
+	entry := bm.index.find(name)
+	if entry == nil || !entry.removeGeneration(generation) {
+		return false
+	}
+	bm.removeIfEmpty(entry)
+	return true
+}
+
+// archive moves the live version of the object to the list of archived
+// generations, flagging it as deleted at the given time.
+func (bm *bucketInMemory) archive(entry *indexEntry, now time.Time) {
+	archived := *entry.live
+	archived.Deleted = now.Format(timestampFormat)
+	entry.archive(archived)
+	entry.live = nil
+}
+
+func (bm *bucketInMemory) removeIfEmpty(entry *indexEntry) {
+	if entry.isEmpty() {
+		bm.index.remove(entry.name)
+	}
+}
+
+// listObjects returns the objects matching the given options, sorted by name
+// and generation, along with the prefixes objects were rolled up into. Only the
+// range of the index matching the options is visited, and entries rolled up
+// into a prefix are skipped at once.
+func (bm *bucketInMemory) listObjects(options ListOptions) ([]ObjectAttrs, []string) {
+	lister := newObjectLister(options)
+	entry := bm.index.seek(lister.start())
+	for entry != nil && !lister.pastEnd(entry.name) {
+		visible := entry.live != nil || (options.Versions && len(entry.archived) > 0)
+		if !visible || !lister.matches(entry.name) {
+			entry = entry.next[0]
+			continue
+		}
+		if prefix, ok := lister.commonPrefix(entry.name); ok {
+			lister.addPrefix(prefix)
+			end := prefixEnd(prefix)
+			if end == "" {
+				break
+			}
+			entry = bm.index.seek(end)
+			continue
+		}
+		if options.Versions {
+			for _, obj := range entry.archived {
+				lister.addObject(obj.ObjectAttrs)
+			}
+		}
+		if entry.live != nil {
+			lister.addObject(entry.live.ObjectAttrs)
+		}
+		entry = entry.next[0]
+	}
+	return lister.result()
+}
+
+// NewStorageMemory creates an instance of StorageMemory.
+func NewStorageMemory(objects []Object) Storage {
+	return newStorageMemory(objects, time.Now)
+}
+
+// newStorageMemory creates an instance of StorageMemory that uses the given
+// function to get the current time.
+func newStorageMemory(objects []Object, now func() time.Time) Storage {
+	s := &storageMemory{
+		uploadsInMemory: newUploadsInMemory(),
+		buckets:         make(map[string]*bucketInMemory),
+		now:             now,
+	}
+	for _, o := range objects {
+		s.CreateObject(o.ObjectAttrs, bytes.NewReader(o.Content))
+	}
+	return s
+}
+
+// CreateBucket creates a bucket.
+func (s *storageMemory) CreateBucket(bucket Bucket) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, err := s.getBucketInMemory(bucket.Name); err == nil {
+		return nil
+	}
+	s.buckets[bucket.Name] = newBucketInMemory(bucket, s.now())
+	return nil
+}
+
+// ListBuckets lists buckets currently registered in the backend.
+func (s *storageMemory) ListBuckets() ([]Bucket, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	buckets := []Bucket{}
+	for _, bucketInMemory := range s.buckets {
+		buckets = append(buckets, bucketInMemory.Bucket)
+	}
+	return buckets, nil
+}
+
+// GetBucket retrieves the bucket information from the backend.
+func (s *storageMemory) GetBucket(name string) (Bucket, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	bucketInMemory, err := s.getBucketInMemory(name)
+	if err != nil {
+		return Bucket{}, err
+	}
+	return bucketInMemory.Bucket, nil
+}
+
+// UpdateBucket patches the attributes of the bucket.
+func (s *storageMemory) UpdateBucket(name string, patch BucketPatch) (Bucket, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	bucketInMemory, err := s.getBucketInMemory(name)
+	if err != nil {
+		return Bucket{}, BucketNotFound
+	}
+	patch.apply(&bucketInMemory.Bucket, s.now())
+	return bucketInMemory.Bucket, nil
+}
+
+func (s *storageMemory) getBucketInMemory(name string) (*bucketInMemory, error) {
+	if bucketInMemory, found := s.buckets[name]; found {
+		return bucketInMemory, nil
+	}
+	return nil, BucketNotFound
+}
+
+// DeleteBucket removes the bucket from the backend.
+func (s *storageMemory) DeleteBucket(name string) error {
+	objs, _, err := s.ListObjects(name, ListOptions{Versions: true})
+	if err != nil {
+		return BucketNotFound
+	}
+	if len(objs) > 0 {
+		return BucketNotEmpty
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.buckets, name)
+	return nil
+}
+
+// CreateObject stores an object in the backend.
+func (s *storageMemory) CreateObject(attrs ObjectAttrs, content io.Reader) (ObjectAttrs, error) {
+	var buf bytes.Buffer
+	err := CopyWithChecksums(&buf, content, &attrs)
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	bucketInMemory, err := s.getBucketInMemory(attrs.BucketName)
+	if err != nil {
+		bucketInMemory = newBucketInMemory(Bucket{Name: attrs.BucketName}, s.now())
+		s.buckets[attrs.BucketName] = bucketInMemory
+	}
+	newObj := bucketInMemory.addObject(Object{ObjectAttrs: attrs, Content: buf.Bytes()}, s.now())
+	return newObj.ObjectAttrs, nil
+}
+
+// ListObjects lists the objects in a given bucket that match the given
+// options, sorted by name and generation.
+func (s *storageMemory) ListObjects(bucketName string, options ListOptions) ([]ObjectAttrs, []string, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	bucketInMemory, err := s.getBucketInMemory(bucketName)
+	if err != nil {
+		return []ObjectAttrs{}, nil, err
+	}
+	objects, prefixes := bucketInMemory.listObjects(options)
+	return objects, prefixes, nil
+}
+
+func (s *storageMemory) GetObject(bucketName, objectName string) (StreamingObject, error) {
+	return s.GetObjectWithGeneration(bucketName, objectName, 0)
+}
+
+// GetObjectWithGeneration retrieves an specific version of the object.
+func (s *storageMemory) GetObjectWithGeneration(bucketName, objectName string, generation int64) (StreamingObject, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	bucketInMemory, err := s.getBucketInMemory(bucketName)
+	if err != nil {
+		return StreamingObject{}, err
+	}
+	obj := bucketInMemory.getObject(objectName, generation)
+	if obj == nil {
+		return StreamingObject{}, errors.New("object not found")
+	}
+	return StreamingObject{ObjectAttrs: obj.ObjectAttrs, Content: newBufferedContent(obj.Content)}, nil
+}
+
+func (s *storageMemory) DeleteObject(bucketName, objectName string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	bucketInMemory, err := s.getBucketInMemory(bucketName)
+	if err != nil {
+		return err
+	}
+	if !bucketInMemory.deleteObject(objectName, s.now()) {
+		return errors.New("object not found")
+	}
+	return nil
+}
+
+// DeleteObjectWithGeneration permanently deletes a specific version of the
+// object, be it the active or an archived one.
+func (s *storageMemory) DeleteObjectWithGeneration(bucketName, objectName string, generation int64) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	bucketInMemory, err := s.getBucketInMemory(bucketName)
+	if err != nil {
+		return err
+	}
+	if !bucketInMemory.deleteObjectWithGeneration(objectName, generation) {
+		return errors.New("object not found")
+	}
+	return nil
+}
+
+// PatchObject updates an object metadata.
+func (s *storageMemory) PatchObject(bucketName, objectName string, patch ObjectPatch) (ObjectAttrs, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	bucketInMemory, err := s.getBucketInMemory(bucketName)
+	if err != nil {
+		return ObjectAttrs{}, err
+	}
+	obj := bucketInMemory.getObject(objectName, 0)
+	if obj == nil {
+		return ObjectAttrs{}, errors.New("object not found")
+	}
+	patch.apply(&obj.ObjectAttrs, s.now().Format(timestampFormat))
+	return obj.ObjectAttrs, nil
+}
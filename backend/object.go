@@ -0,0 +1,157 @@
+// Copyright 2018 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"github.com/fsouza/fake-gcs-server/internal/checksum"
+)
+
+// ObjectAttrs represents the metadata of an object stored within the fake
+// server, without its content.
+type ObjectAttrs struct {
+	BucketName         string `json:"-"`
+	Name               string `json:"-"`
+	Size               int64
+	ContentType        string
+	ContentEncoding    string
+	ContentDisposition string
+	ContentLanguage    string
+	CacheControl       string
+	StorageClass       string
+	Crc32c             string
+	Md5Hash            string
+	ACL                []storage.ACLRule
+	Metadata           map[string]string
+	Created            string
+	Deleted            string
+	Updated            string
+	CustomTime         string
+	Generation         int64
+	Metageneration     int64
+	ComponentCount     int64
+	KmsKeyName         string
+	TemporaryHold      bool
+	EventBasedHold     bool
+}
+
+// ObjectPatch holds the changes to the metadata of an object made by
+// PatchObject. Nil fields are left unchanged.
+type ObjectPatch struct {
+	ContentType        *string
+	ContentEncoding    *string
+	ContentDisposition *string
+	ContentLanguage    *string
+	CacheControl       *string
+	StorageClass       *string
+	CustomTime         *string
+	TemporaryHold      *bool
+	EventBasedHold     *bool
+	ACL                []storage.ACLRule
+
+	// Metadata is merged into the metadata of the object, with nil values
+	// removing keys, unless ReplaceMetadata is set, in which case the
+	// non-nil values replace the whole metadata of the object.
+	Metadata        map[string]*string
+	ReplaceMetadata bool
+}
+
+// apply patches the given attributes, bumping their metageneration.
+func (p ObjectPatch) apply(attrs *ObjectAttrs, updated string) {
+	setIfNotNil(&attrs.ContentType, p.ContentType)
+	setIfNotNil(&attrs.ContentEncoding, p.ContentEncoding)
+	setIfNotNil(&attrs.ContentDisposition, p.ContentDisposition)
+	setIfNotNil(&attrs.ContentLanguage, p.ContentLanguage)
+	setIfNotNil(&attrs.CacheControl, p.CacheControl)
+	setIfNotNil(&attrs.StorageClass, p.StorageClass)
+	setIfNotNil(&attrs.CustomTime, p.CustomTime)
+	if p.TemporaryHold != nil {
+		attrs.TemporaryHold = *p.TemporaryHold
+	}
+	if p.EventBasedHold != nil {
+		attrs.EventBasedHold = *p.EventBasedHold
+	}
+	if p.ACL != nil {
+		attrs.ACL = p.ACL
+	}
+	if len(p.Metadata) > 0 || p.ReplaceMetadata {
+		attrs.Metadata = mergeMap(attrs.Metadata, p.Metadata, p.ReplaceMetadata)
+	}
+	attrs.Metageneration++
+	attrs.Updated = updated
+}
+
+func setIfNotNil(field *string, value *string) {
+	if value != nil {
+		*field = *value
+	}
+}
+
+// Object represents the object that is stored within the fake server.
+type Object struct {
+	ObjectAttrs
+	Content []byte `json:"-"`
+}
+
+// ReadSeekCloser is the interface implemented by the content of objects
+// streamed from the backend.
+type ReadSeekCloser interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// StreamingObject represents an object whose content is streamed from the
+// backend instead of held in memory. Callers must close its content.
+type StreamingObject struct {
+	ObjectAttrs
+	Content ReadSeekCloser
+}
+
+type bufferedContent struct {
+	*bytes.Reader
+}
+
+func (bufferedContent) Close() error {
+	return nil
+}
+
+func newBufferedContent(content []byte) ReadSeekCloser {
+	return bufferedContent{bytes.NewReader(content)}
+}
+
+// CopyWithChecksums copies the content to dst, filling the size of the object
+// and any checksum not provided by the caller as the content is copied. Like in
+// GCS, composite objects don't get an MD5 hash. Storage implementations can use
+// it to honor the CreateObject contract.
+func CopyWithChecksums(dst io.Writer, content io.Reader, attrs *ObjectAttrs) error {
+	hasher := checksum.NewStreamingHasher()
+	_, err := io.Copy(io.MultiWriter(dst, hasher), content)
+	if err != nil {
+		return err
+	}
+	attrs.Size = hasher.Size()
+	if attrs.Crc32c == "" {
+		attrs.Crc32c = hasher.EncodedCrc32cChecksum()
+	}
+	if attrs.Md5Hash == "" && attrs.ComponentCount == 0 {
+		attrs.Md5Hash = hasher.EncodedMd5Hash()
+	}
+	return nil
+}
+
+// ID is used for comparing objects.
+func (o *ObjectAttrs) ID() string {
+	return fmt.Sprintf("%s#%d", o.IDNoGen(), o.Generation)
+}
+
+// IDNoGen does not consider the generation field.
+func (o *ObjectAttrs) IDNoGen() string {
+	return fmt.Sprintf("%s/%s", o.BucketName, o.Name)
+}
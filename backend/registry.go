@@ -0,0 +1,114 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// FilesystemBackend is the name of the filesystem-backed storage.
+	FilesystemBackend = "filesystem"
+
+	// MemoryBackend is the name of the in-memory storage.
+	MemoryBackend = "memory"
+)
+
+// Config is the configuration passed to a Factory when creating a storage.
+type Config struct {
+	// InitialObjects are the objects the storage should be pre-loaded with.
+	InitialObjects []Object
+
+	// Root is the directory used by backends that persist data, such as the
+	// filesystem backend.
+	Root string
+
+	// Now returns the current time, used by the built-in backends for the
+	// creation and update times of buckets and the update and deletion
+	// times of objects. The default is time.Now.
+	Now func() time.Time
+}
+
+func (c Config) now() func() time.Time {
+	if c.Now != nil {
+		return c.Now
+	}
+	return time.Now
+}
+
+// Factory creates a new instance of a storage backend.
+type Factory func(Config) (Storage, error)
+
+var (
+	factoriesMtx sync.RWMutex
+	factories    = map[string]Factory{}
+)
+
+func init() {
+	Register(FilesystemBackend, func(cfg Config) (Storage, error) {
+		if cfg.Root == "" {
+			return nil, fmt.Errorf("backend %q requires a root directory", FilesystemBackend)
+		}
+		return newStorageFS(cfg.InitialObjects, cfg.Root, cfg.now())
+	})
+	Register(MemoryBackend, func(cfg Config) (Storage, error) {
+		return newStorageMemory(cfg.InitialObjects, cfg.now()), nil
+	})
+}
+
+// Register makes a storage backend available under the given name, so it can
+// be selected by name (for example, using the -backend flag of the
+// fake-gcs-server binary).
+//
+// It panics if the name is empty, the factory is nil or if a backend with the
+// same name has already been registered.
+func Register(name string, factory Factory) {
+	if name == "" {
+		panic("backend: Register called with an empty name")
+	}
+	if factory == nil {
+		panic("backend: Register called with a nil factory for " + name)
+	}
+	factoriesMtx.Lock()
+	defer factoriesMtx.Unlock()
+	if _, ok := factories[name]; ok {
+		panic("backend: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// IsRegistered returns whether a backend with the given name is registered.
+func IsRegistered(name string) bool {
+	factoriesMtx.RLock()
+	defer factoriesMtx.RUnlock()
+	_, ok := factories[name]
+	return ok
+}
+
+// Names returns the sorted list of registered backends.
+func Names() []string {
+	factoriesMtx.RLock()
+	defer factoriesMtx.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates an instance of the backend registered with the given name.
+func New(name string, cfg Config) (Storage, error) {
+	factoriesMtx.RLock()
+	factory, ok := factories[name]
+	factoriesMtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+	return factory(cfg)
+}
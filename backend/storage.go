@@ -0,0 +1,46 @@
+// Copyright 2018 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package backend provides the storage backends used by fake-gcs-server.
+package backend
+
+import "io"
+
+// Storage is the generic interface for implementing the backend storage of the
+// server.
+//
+// Object content is streamed: CreateObject reads it from the given reader,
+// computing the size and any checksum missing from the attributes, and the
+// content of objects returned by GetObject must be closed by the caller.
+// CreateObject creates the bucket of the object when it doesn't exist yet.
+// CreateBucket fills the creation and update times and the metageneration of
+// the bucket, leaving an existing bucket untouched, and UpdateBucket bumps its
+// metageneration.
+// ListObjects filters objects according to the given options, returning them
+// sorted by name and generation, along with the sorted list of prefixes
+// objects were rolled up into when a delimiter is given.
+//
+// Implementations must be safe for concurrent use, and return BucketNotFound
+// when an operation targets a bucket that doesn't exist.
+type Storage interface {
+	CreateBucket(bucket Bucket) error
+	ListBuckets() ([]Bucket, error)
+	GetBucket(name string) (Bucket, error)
+	UpdateBucket(name string, patch BucketPatch) (Bucket, error)
+	DeleteBucket(name string) error
+	CreateObject(attrs ObjectAttrs, content io.Reader) (ObjectAttrs, error)
+	ListObjects(bucketName string, options ListOptions) ([]ObjectAttrs, []string, error)
+	GetObject(bucketName, objectName string) (StreamingObject, error)
+	GetObjectWithGeneration(bucketName, objectName string, generation int64) (StreamingObject, error)
+	DeleteObject(bucketName, objectName string) error
+	DeleteObjectWithGeneration(bucketName, objectName string, generation int64) error
+	PatchObject(bucketName, objectName string, patch ObjectPatch) (ObjectAttrs, error)
+}
+
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+const BucketNotFound = Error("bucket not found")
+const BucketNotEmpty = Error("bucket must be empty prior to deletion")
@@ -0,0 +1,193 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	UploadNotFound      = Error("upload not found")
+	UploadOffsetInvalid = Error("chunk doesn't start at the end of the data received so far")
+	UploadCompleted     = Error("upload already completed")
+)
+
+// Upload is a resumable upload session, holding the attributes of the object
+// being uploaded while its content is received in chunks.
+type Upload struct {
+	ID         string
+	BucketName string
+	ObjectName string
+
+	// Attrs are the attributes the object will be created with. Its bucket
+	// and object names are ignored in favor of the ones in the upload.
+	Attrs ObjectAttrs
+
+	// ExpectedMd5Hash and ExpectedCrc32c are the base64-encoded checksums
+	// declared by the client for the whole content, if any.
+	ExpectedMd5Hash string
+	ExpectedCrc32c  string
+
+	// Size is the number of bytes received so far.
+	Size int64
+
+	Created time.Time
+	Expires time.Time
+
+	// Completed holds the attributes of the object created by the upload,
+	// once it's finalized. The content of completed uploads is discarded.
+	Completed *ObjectAttrs
+}
+
+// UploadStorage is implemented by backends that store the sessions of
+// resumable uploads, along with the content received so far, so uploads can
+// outlive the server that started them.
+//
+// AppendUpload writes a chunk at the given offset, which must be the size of
+// the upload, failing with UploadOffsetInvalid otherwise. When reading the
+// chunk fails, the data read from it is discarded, so the chunk can be sent
+// again. CompleteUpload records the object created by the upload and
+// discards its content.
+//
+// Implementations must be safe for concurrent use, and return UploadNotFound
+// when an operation targets an upload that doesn't exist.
+type UploadStorage interface {
+	CreateUpload(upload Upload) error
+	ListUploads() ([]Upload, error)
+	GetUpload(id string) (Upload, error)
+	UpdateUpload(upload Upload) error
+	AppendUpload(id string, offset int64, content io.Reader) (Upload, error)
+	OpenUpload(id string) (ReadSeekCloser, error)
+	CompleteUpload(id string, attrs ObjectAttrs) (Upload, error)
+	DeleteUpload(id string) error
+}
+
+// uploadsInMemory is an implementation of UploadStorage that keeps uploads
+// and their content in memory.
+type uploadsInMemory struct {
+	uploads map[string]*uploadInMemory
+	mtx     sync.RWMutex
+}
+
+type uploadInMemory struct {
+	Upload
+	content []byte
+}
+
+// NewUploadStorageMemory creates an UploadStorage that keeps uploads in
+// memory, for backends that don't store uploads themselves.
+func NewUploadStorageMemory() UploadStorage {
+	return newUploadsInMemory()
+}
+
+func newUploadsInMemory() *uploadsInMemory {
+	return &uploadsInMemory{uploads: make(map[string]*uploadInMemory)}
+}
+
+func (u *uploadsInMemory) CreateUpload(upload Upload) error {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	u.uploads[upload.ID] = &uploadInMemory{Upload: upload}
+	return nil
+}
+
+// ListUploads returns every upload, sorted by ID.
+func (u *uploadsInMemory) ListUploads() ([]Upload, error) {
+	u.mtx.RLock()
+	defer u.mtx.RUnlock()
+	uploads := make([]Upload, 0, len(u.uploads))
+	for _, upload := range u.uploads {
+		uploads = append(uploads, upload.Upload)
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].ID < uploads[j].ID
+	})
+	return uploads, nil
+}
+
+func (u *uploadsInMemory) GetUpload(id string) (Upload, error) {
+	u.mtx.RLock()
+	defer u.mtx.RUnlock()
+	upload, ok := u.uploads[id]
+	if !ok {
+		return Upload{}, UploadNotFound
+	}
+	return upload.Upload, nil
+}
+
+// UpdateUpload updates the attributes of the upload, keeping the size and
+// completion state it has in the storage.
+func (u *uploadsInMemory) UpdateUpload(upload Upload) error {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	stored, ok := u.uploads[upload.ID]
+	if !ok {
+		return UploadNotFound
+	}
+	upload.Size = stored.Size
+	upload.Completed = stored.Completed
+	stored.Upload = upload
+	return nil
+}
+
+func (u *uploadsInMemory) AppendUpload(id string, offset int64, content io.Reader) (Upload, error) {
+	// the chunk is read before taking the lock, as it may take a while.
+	var buf bytes.Buffer
+	_, readErr := io.Copy(&buf, content)
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	upload, ok := u.uploads[id]
+	if !ok {
+		return Upload{}, UploadNotFound
+	}
+	if upload.Completed != nil {
+		return Upload{}, UploadCompleted
+	}
+	if offset != upload.Size {
+		return Upload{}, UploadOffsetInvalid
+	}
+	if readErr != nil {
+		return Upload{}, readErr
+	}
+	upload.content = append(upload.content, buf.Bytes()...)
+	upload.Size = int64(len(upload.content))
+	return upload.Upload, nil
+}
+
+func (u *uploadsInMemory) OpenUpload(id string) (ReadSeekCloser, error) {
+	u.mtx.RLock()
+	defer u.mtx.RUnlock()
+	upload, ok := u.uploads[id]
+	if !ok {
+		return nil, UploadNotFound
+	}
+	return newBufferedContent(upload.content), nil
+}
+
+func (u *uploadsInMemory) CompleteUpload(id string, attrs ObjectAttrs) (Upload, error) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	upload, ok := u.uploads[id]
+	if !ok {
+		return Upload{}, UploadNotFound
+	}
+	upload.Completed = &attrs
+	upload.content = nil
+	return upload.Upload, nil
+}
+
+func (u *uploadsInMemory) DeleteUpload(id string) error {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	if _, ok := u.uploads[id]; !ok {
+		return UploadNotFound
+	}
+	delete(u.uploads, id)
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package backend
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func readUpload(t *testing.T, uploads UploadStorage, id string) string {
+	t.Helper()
+	content, err := uploads.OpenUpload(id)
+	noError(t, err)
+	defer content.Close()
+	data, err := ioutil.ReadAll(content)
+	noError(t, err)
+	return string(data)
+}
+
+func TestUploadStorage(t *testing.T) {
+	testForStorageBackends(t, func(t *testing.T, storage Storage) {
+		uploads, ok := storage.(UploadStorage)
+		if !ok {
+			t.Fatal("backend doesn't store uploads")
+		}
+		upload := Upload{
+			ID:         "some-upload",
+			BucketName: "some-bucket",
+			ObjectName: "some-object.txt",
+			Attrs:      ObjectAttrs{ContentType: "text/plain", Metadata: map[string]string{"key": "value"}},
+			Created:    time.Now().Truncate(time.Second),
+			Expires:    time.Now().Add(time.Hour).Truncate(time.Second),
+		}
+		noError(t, uploads.CreateUpload(upload))
+
+		upload, err := uploads.AppendUpload(upload.ID, 0, strings.NewReader("hello"))
+		noError(t, err)
+		if upload.Size != 5 {
+			t.Errorf("wrong size after first chunk\nwant 5\ngot  %d", upload.Size)
+		}
+		_, err = uploads.AppendUpload(upload.ID, 2, strings.NewReader("llo world"))
+		if !errors.Is(err, UploadOffsetInvalid) {
+			t.Errorf("wrong error for misaligned chunk\nwant %v\ngot  %v", UploadOffsetInvalid, err)
+		}
+		_, err = uploads.AppendUpload(upload.ID, 5, &failingReader{data: " wor"})
+		shouldError(t, err)
+		if data := readUpload(t, uploads, upload.ID); data != "hello" {
+			t.Errorf("wrong content after failed chunk\nwant %q\ngot  %q", "hello", data)
+		}
+		upload, err = uploads.AppendUpload(upload.ID, 5, strings.NewReader(" world"))
+		noError(t, err)
+		if data := readUpload(t, uploads, upload.ID); data != "hello world" {
+			t.Errorf("wrong content\nwant %q\ngot  %q", "hello world", data)
+		}
+
+		upload.ExpectedMd5Hash = "some-hash"
+		upload.Size = 0
+		noError(t, uploads.UpdateUpload(upload))
+		upload, err = uploads.GetUpload(upload.ID)
+		noError(t, err)
+		if upload.ExpectedMd5Hash != "some-hash" || upload.Size != 11 {
+			t.Errorf("wrong upload after update: %+v", upload)
+		}
+		if upload.Attrs.ContentType != "text/plain" || upload.Attrs.Metadata["key"] != "value" {
+			t.Errorf("wrong attributes after update: %+v", upload.Attrs)
+		}
+
+		upload, err = uploads.CompleteUpload(upload.ID, ObjectAttrs{Size: 11, Generation: 1234})
+		noError(t, err)
+		if upload.Completed == nil || upload.Completed.Generation != 1234 {
+			t.Errorf("wrong completed attributes: %+v", upload.Completed)
+		}
+		_, err = uploads.AppendUpload(upload.ID, 11, strings.NewReader("!"))
+		if !errors.Is(err, UploadCompleted) {
+			t.Errorf("wrong error appending to completed upload\nwant %v\ngot  %v", UploadCompleted, err)
+		}
+
+		list, err := uploads.ListUploads()
+		noError(t, err)
+		if len(list) != 1 || list[0].ID != upload.ID {
+			t.Errorf("wrong list of uploads: %+v", list)
+		}
+		noError(t, uploads.DeleteUpload(upload.ID))
+		_, err = uploads.GetUpload(upload.ID)
+		if !errors.Is(err, UploadNotFound) {
+			t.Errorf("wrong error for deleted upload\nwant %v\ngot  %v", UploadNotFound, err)
+		}
+		if err := uploads.DeleteUpload(upload.ID); !errors.Is(err, UploadNotFound) {
+			t.Errorf("wrong error deleting deleted upload\nwant %v\ngot  %v", UploadNotFound, err)
+		}
+	})
+}
+
+func TestFSUploadsSurviveRestart(t *testing.T) {
+	tempDir, err := ioutil.TempDir(os.TempDir(), "fakegcstest")
+	noError(t, err)
+	defer os.RemoveAll(tempDir)
+	storage, err := NewStorageFS(nil, tempDir)
+	noError(t, err)
+	noError(t, storage.CreateBucket(Bucket{Name: "some-bucket"}))
+	uploads := storage.(UploadStorage)
+	noError(t, uploads.CreateUpload(Upload{ID: "some-upload", BucketName: "some-bucket", ObjectName: "some-object.txt"}))
+	_, err = uploads.AppendUpload("some-upload", 0, strings.NewReader("hello"))
+	noError(t, err)
+
+	storage, err = NewStorageFS(nil, tempDir)
+	noError(t, err)
+	uploads = storage.(UploadStorage)
+	upload, err := uploads.GetUpload("some-upload")
+	noError(t, err)
+	if upload.Size != 5 || upload.ObjectName != "some-object.txt" {
+		t.Errorf("wrong upload after restart: %+v", upload)
+	}
+	if data := readUpload(t, uploads, "some-upload"); data != "hello" {
+		t.Errorf("wrong content after restart\nwant %q\ngot  %q", "hello", data)
+	}
+	buckets, err := storage.ListBuckets()
+	noError(t, err)
+	if len(buckets) != 1 || buckets[0].Name != "some-bucket" {
+		t.Errorf("wrong list of buckets: %+v", buckets)
+	}
+	_, err = uploads.GetUpload("..")
+	if !errors.Is(err, UploadNotFound) {
+		t.Errorf("wrong error for invalid upload ID\nwant %v\ngot  %v", UploadNotFound, err)
+	}
+}
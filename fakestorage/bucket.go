@@ -9,8 +9,11 @@ import (
 	"errors"
 	"net/http"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
 
-	"github.com/fsouza/fake-gcs-server/internal/backend"
+	"github.com/fsouza/fake-gcs-server/backend"
 	"github.com/gorilla/mux"
 )
 
@@ -23,7 +26,7 @@ var bucketRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*[a-zA-Z0-9]$`)
 //
 // Deprecated: use CreateBucketWithOpts.
 func (s *Server) CreateBucket(name string) {
-	err := s.backend.CreateBucket(name, false)
+	err := s.backend.CreateBucket(backend.Bucket{Name: name, TimeCreated: s.clock.Now()})
 	if err != nil {
 		panic(err)
 	}
@@ -34,6 +37,13 @@ func (s *Server) CreateBucket(name string) {
 type CreateBucketOpts struct {
 	Name              string
 	VersioningEnabled bool
+	Location          string
+	StorageClass      string
+	Labels            map[string]string
+	Lifecycle         backend.Lifecycle
+	// RetentionPeriod of the retention policy of the bucket, if any.
+	RetentionPeriod       time.Duration
+	DefaultEventBasedHold bool
 }
 
 // CreateBucketWithOpts creates a bucket inside the server, so any API calls that
@@ -42,18 +52,41 @@ type CreateBucketOpts struct {
 //
 // If the underlying backend returns an error, this method panics.
 func (s *Server) CreateBucketWithOpts(opts CreateBucketOpts) {
-	err := s.backend.CreateBucket(opts.Name, opts.VersioningEnabled)
+	bucket := backend.Bucket{
+		Name:                  opts.Name,
+		VersioningEnabled:     opts.VersioningEnabled,
+		Location:              opts.Location,
+		StorageClass:          opts.StorageClass,
+		Labels:                opts.Labels,
+		Lifecycle:             opts.Lifecycle,
+		TimeCreated:           s.clock.Now(),
+		DefaultEventBasedHold: opts.DefaultEventBasedHold,
+	}
+	if opts.RetentionPeriod > 0 {
+		bucket.RetentionPolicy = &backend.RetentionPolicy{
+			RetentionPeriod: int64(opts.RetentionPeriod / time.Second),
+			EffectiveTime:   bucket.TimeCreated,
+		}
+	}
+	err := s.backend.CreateBucket(bucket)
 	if err != nil {
 		panic(err)
 	}
+	s.watchLifecycle(bucket.Lifecycle)
 }
 
 func (s *Server) createBucketByPost(r *http.Request) jsonResponse {
 	// Minimal version of Bucket from google.golang.org/api/storage/v1
 
 	var data struct {
-		Name       string            `json:"name,omitempty"`
-		Versioning *bucketVersioning `json:"versioning,omitempty"`
+		Name                  string                 `json:"name,omitempty"`
+		Versioning            *bucketVersioning      `json:"versioning,omitempty"`
+		Location              string                 `json:"location,omitempty"`
+		StorageClass          string                 `json:"storageClass,omitempty"`
+		Labels                map[string]string      `json:"labels,omitempty"`
+		Lifecycle             *bucketLifecycle       `json:"lifecycle,omitempty"`
+		RetentionPolicy       *bucketRetentionPolicy `json:"retentionPolicy,omitempty"`
+		DefaultEventBasedHold bool                   `json:"defaultEventBasedHold,omitempty"`
 	}
 
 	// Read the bucket props from the request body JSON
@@ -69,45 +102,110 @@ func (s *Server) createBucketByPost(r *http.Request) jsonResponse {
 	if err := validateBucketName(name); err != nil {
 		return jsonResponse{errorMessage: err.Error(), status: http.StatusBadRequest}
 	}
+	if data.StorageClass != "" && !isValidStorageClass(data.StorageClass) {
+		return jsonResponse{errorMessage: errInvalidStorageClass.Error(), status: http.StatusBadRequest}
+	}
+	lifecycle, err := data.Lifecycle.toBackend()
+	if err != nil {
+		return jsonResponse{errorMessage: err.Error(), status: http.StatusBadRequest}
+	}
+	now := s.clock.Now()
+	retentionPolicy, err := data.RetentionPolicy.toBackend(now)
+	if err != nil {
+		return jsonResponse{errorMessage: err.Error(), status: http.StatusBadRequest}
+	}
 
 	// Create the named bucket
-	if err := s.backend.CreateBucket(name, versioning); err != nil {
+	err = s.backend.CreateBucket(backend.Bucket{
+		Name:                  name,
+		VersioningEnabled:     versioning,
+		Location:              strings.ToUpper(data.Location),
+		StorageClass:          data.StorageClass,
+		Labels:                data.Labels,
+		Lifecycle:             lifecycle,
+		TimeCreated:           now,
+		RetentionPolicy:       retentionPolicy,
+		DefaultEventBasedHold: data.DefaultEventBasedHold,
+	})
+	if err != nil {
 		return jsonResponse{errorMessage: err.Error()}
 	}
+	s.watchLifecycle(lifecycle)
 
 	// Return the created bucket:
 	bucket, err := s.backend.GetBucket(name)
 	if err != nil {
 		return jsonResponse{errorMessage: err.Error()}
 	}
-	return jsonResponse{data: newBucketResponse(bucket)}
+	return jsonResponse{data: newBucketResponse(bucket, s.URL())}
 }
 
 func (s *Server) listBuckets(r *http.Request) jsonResponse {
+	page, err := parsePageParams(r)
+	if err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
 	buckets, err := s.backend.ListBuckets()
 	if err != nil {
 		return jsonResponse{errorMessage: err.Error()}
 	}
-	return jsonResponse{data: newListBucketsResponse(buckets)}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Name < buckets[j].Name
+	})
+	var pageBuckets []backend.Bucket
+	var nextPageToken string
+	for _, bucket := range buckets {
+		item := pageToken{Name: bucket.Name}
+		if !page.token.precedes(item) {
+			continue
+		}
+		if page.full(len(pageBuckets)) {
+			nextPageToken = pageToken{Name: pageBuckets[len(pageBuckets)-1].Name}.encode()
+			break
+		}
+		pageBuckets = append(pageBuckets, bucket)
+	}
+	resp := newListBucketsResponse(pageBuckets, s.URL())
+	resp.NextPageToken = nextPageToken
+	return jsonResponse{data: resp}
 }
 
 func (s *Server) getBucket(r *http.Request) jsonResponse {
-	bucketName := mux.Vars(r)["bucketName"]
-	bucket, err := s.backend.GetBucket(bucketName)
+	bucket, resp := s.getBucketWithConditions(r, true)
+	if resp != nil {
+		return *resp
+	}
+	return jsonResponse{data: newBucketResponse(bucket, s.URL())}
+}
+
+// getBucketWithConditions returns the bucket targeted by the request, after
+// checking its metageneration preconditions.
+func (s *Server) getBucketWithConditions(r *http.Request, read bool) (backend.Bucket, *jsonResponse) {
+	conds, err := parseObjectConditions(r)
 	if err != nil {
-		return jsonResponse{status: http.StatusNotFound}
+		return backend.Bucket{}, &jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
 	}
-	return jsonResponse{data: newBucketResponse(bucket)}
+	bucket, err := s.backend.GetBucket(mux.Vars(r)["bucketName"])
+	if err != nil {
+		return backend.Bucket{}, &jsonResponse{status: http.StatusNotFound}
+	}
+	if resp := conditionsResponse(conds.evaluateBucket(bucket.Metageneration, read)); resp != nil {
+		return backend.Bucket{}, resp
+	}
+	return bucket, nil
 }
 
 func (s *Server) deleteBucket(r *http.Request) jsonResponse {
 	bucketName := mux.Vars(r)["bucketName"]
+	if _, resp := s.getBucketWithConditions(r, false); resp != nil {
+		return *resp
+	}
 	err := s.backend.DeleteBucket(bucketName)
 	if err == backend.BucketNotFound {
 		return jsonResponse{status: http.StatusNotFound}
 	}
 	if err == backend.BucketNotEmpty {
-		return jsonResponse{status: http.StatusPreconditionFailed, errorMessage: err.Error()}
+		return jsonResponse{status: http.StatusConflict, errorMessage: err.Error()}
 	}
 	if err != nil {
 		return jsonResponse{status: http.StatusInternalServerError, errorMessage: err.Error()}
@@ -115,6 +213,116 @@ func (s *Server) deleteBucket(r *http.Request) jsonResponse {
 	return jsonResponse{}
 }
 
+// immutableBucketFields are the fields of the bucket resource that can't be
+// changed by PATCH or PUT requests, unless the request sends their current
+// values.
+var immutableBucketFields = []string{
+	"id",
+	"name",
+	"location",
+	"projectNumber",
+	"timeCreated",
+}
+
+// patchBucket updates the writable fields of the bucket present in the
+// request, leaving the others unchanged. Labels set to null are removed.
+func (s *Server) patchBucket(r *http.Request) jsonResponse {
+	return s.updateBucketAttrs(r, false)
+}
+
+// updateBucket replaces all the writable fields of the bucket with the ones
+// in the request, resetting the fields the request omits to their defaults.
+func (s *Server) updateBucket(r *http.Request) jsonResponse {
+	return s.updateBucketAttrs(r, true)
+}
+
+func (s *Server) updateBucketAttrs(r *http.Request, replace bool) jsonResponse {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: "invalid bucket resource"}
+	}
+	bucket, resp := s.getBucketWithConditions(r, false)
+	if resp != nil {
+		return *resp
+	}
+	if err := checkImmutableFields(fields, newBucketResponse(bucket, s.URL()), immutableBucketFields); err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	patch, err := parseBucketPatch(fields, replace)
+	if err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	patch.RetentionPolicy, err = parseRetentionPolicyPatch(fields, bucket.RetentionPolicy, replace, s.clock.Now())
+	if errors.Is(err, errLockedRetentionPolicy) {
+		return jsonResponse{status: http.StatusForbidden, errorMessage: err.Error()}
+	}
+	if err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	bucket, err = s.backend.UpdateBucket(bucket.Name, patch)
+	if err != nil {
+		return jsonResponse{status: http.StatusNotFound}
+	}
+	s.watchLifecycle(bucket.Lifecycle)
+	return jsonResponse{data: newBucketResponse(bucket, s.URL())}
+}
+
+// parseBucketPatch builds the patch for the given fields of a bucket update
+// request. When replace is true, the fields that aren't in the request are
+// reset.
+func parseBucketPatch(fields map[string]json.RawMessage, replace bool) (backend.BucketPatch, error) {
+	var patch backend.BucketPatch
+	if raw, ok := fields["versioning"]; ok || replace {
+		var versioning bucketVersioning
+		if ok && !isJSONNull(raw) {
+			if err := json.Unmarshal(raw, &versioning); err != nil {
+				return patch, errors.New("invalid versioning")
+			}
+		}
+		patch.VersioningEnabled = &versioning.Enabled
+	}
+	if raw, ok := fields["defaultEventBasedHold"]; ok || replace {
+		var hold bool
+		if ok && !isJSONNull(raw) {
+			if err := json.Unmarshal(raw, &hold); err != nil {
+				return patch, errors.New("invalid defaultEventBasedHold")
+			}
+		}
+		patch.DefaultEventBasedHold = &hold
+	}
+	if raw, ok := fields["storageClass"]; ok || replace {
+		var storageClass string
+		if ok && !isJSONNull(raw) {
+			if err := json.Unmarshal(raw, &storageClass); err != nil || !isValidStorageClass(storageClass) {
+				return patch, errInvalidStorageClass
+			}
+		}
+		patch.StorageClass = &storageClass
+	}
+	if raw, ok := fields["lifecycle"]; ok || replace {
+		var lifecycle *bucketLifecycle
+		if ok && !isJSONNull(raw) {
+			if err := json.Unmarshal(raw, &lifecycle); err != nil {
+				return patch, errors.New("invalid lifecycle")
+			}
+		}
+		backendLifecycle, err := lifecycle.toBackend()
+		if err != nil {
+			return patch, err
+		}
+		patch.Lifecycle = &backendLifecycle
+	}
+	patch.ReplaceLabels = replace
+	if raw, ok := fields["labels"]; ok {
+		if isJSONNull(raw) {
+			patch.ReplaceLabels = true
+		} else if err := json.Unmarshal(raw, &patch.Labels); err != nil {
+			return patch, errors.New("invalid labels")
+		}
+	}
+	return patch, nil
+}
+
 func validateBucketName(bucketName string) error {
 	if !bucketRegexp.MatchString(bucketName) {
 		return errors.New("invalid bucket name")
@@ -7,7 +7,9 @@ package fakestorage
 import (
 	"context"
 	"io/ioutil"
+	"net/http"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -96,6 +98,23 @@ func TestServerClientDeleteBucket(t *testing.T) {
 		})
 	})
 
+	t.Run("it returns an error for buckets with archived objects", func(t *testing.T) {
+		const bucketName = "versioned-bucket"
+		runServersTest(t, nil, func(t *testing.T, server *Server) {
+			server.CreateBucketWithOpts(CreateBucketOpts{Name: bucketName, VersioningEnabled: true})
+			server.CreateObject(Object{BucketName: bucketName, Name: "static/js/app.js"})
+			client := server.Client()
+			err := client.Bucket(bucketName).Object("static/js/app.js").Delete(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = client.Bucket(bucketName).Delete(context.Background())
+			if !hasStatusCode(err, http.StatusConflict) {
+				t.Errorf("wrong error deleting the bucket\nwant status %d\ngot  %v", http.StatusConflict, err)
+			}
+		})
+	})
+
 	t.Run("it returns an error for unknown buckets", func(t *testing.T) {
 		const bucketName = "non-existent-bucket"
 		runServersTest(t, nil, func(t *testing.T, server *Server) {
@@ -222,6 +241,44 @@ func TestServerClientListBuckets(t *testing.T) {
 	})
 }
 
+func TestServerClientListBucketsPaginated(t *testing.T) {
+	objs := []Object{
+		{BucketName: "bucket-c", Name: "some-object.txt"},
+		{BucketName: "bucket-a", Name: "some-object.txt"},
+		{BucketName: "bucket-e", Name: "some-object.txt"},
+		{BucketName: "bucket-b", Name: "some-object.txt"},
+		{BucketName: "bucket-d", Name: "some-object.txt"},
+	}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		client := server.Client()
+		pager := iterator.NewPager(client.Buckets(context.Background(), "whatever"), 2, "")
+		var names []string
+		pages := 0
+		for {
+			var page []*storage.BucketAttrs
+			nextPageToken, err := pager.NextPage(&page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages++
+			for _, b := range page {
+				names = append(names, b.Name)
+			}
+			if nextPageToken == "" {
+				break
+			}
+		}
+		expectedNames := []string{"bucket-a", "bucket-b", "bucket-c", "bucket-d", "bucket-e"}
+		if !reflect.DeepEqual(names, expectedNames) {
+			t.Errorf("wrong buckets returned\nwant %#v\ngot  %#v", expectedNames, names)
+		}
+		if pages != 3 {
+			t.Errorf("wrong number of pages\nwant 3\ngot  %d", pages)
+		}
+	})
+}
+
 func TestServerClientListObjects(t *testing.T) {
 	objects := []Object{
 		{BucketName: "some-bucket", Name: "img/hi-res/party-01.jpg"},
@@ -262,3 +319,180 @@ func TestServerClientListObjects(t *testing.T) {
 		})
 	}
 }
+
+func TestServerClientBucketAttrsWithOpts(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		const bucketName = "bucket-with-opts"
+		server.CreateBucketWithOpts(CreateBucketOpts{
+			Name:         bucketName,
+			Location:     "EU",
+			StorageClass: "NEARLINE",
+			Labels:       map[string]string{"env": "test"},
+		})
+		client := server.Client()
+		attrs, err := client.Bucket(bucketName).Attrs(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Location != "EU" {
+			t.Errorf("wrong location\nwant %q\ngot  %q", "EU", attrs.Location)
+		}
+		if attrs.StorageClass != "NEARLINE" {
+			t.Errorf("wrong storage class\nwant %q\ngot  %q", "NEARLINE", attrs.StorageClass)
+		}
+		if !reflect.DeepEqual(attrs.Labels, map[string]string{"env": "test"}) {
+			t.Errorf("wrong labels: %v", attrs.Labels)
+		}
+		if attrs.MetaGeneration != 1 {
+			t.Errorf("wrong metageneration\nwant 1\ngot  %d", attrs.MetaGeneration)
+		}
+		if attrs.Etag == "" {
+			t.Error("unexpected empty etag")
+		}
+
+		w := client.Bucket(bucketName).Object("file.txt").NewWriter(context.Background())
+		w.Write([]byte("something"))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if w.Attrs().StorageClass != "NEARLINE" {
+			t.Errorf("object should inherit the storage class of the bucket, got %q", w.Attrs().StorageClass)
+		}
+	})
+}
+
+func TestServerClientBucketAttrsDefaults(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		const bucketName = "default-bucket"
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: bucketName})
+		attrs, err := server.Client().Bucket(bucketName).Attrs(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Location != "US" {
+			t.Errorf("wrong location\nwant %q\ngot  %q", "US", attrs.Location)
+		}
+		if attrs.StorageClass != "STANDARD" {
+			t.Errorf("wrong storage class\nwant %q\ngot  %q", "STANDARD", attrs.StorageClass)
+		}
+	})
+}
+
+func TestServerClientBucketUpdate(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		const bucketName = "bucket-to-update"
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: bucketName, Labels: map[string]string{"a": "1", "b": "2"}})
+		bucket := server.Client().Bucket(bucketName)
+		update := storage.BucketAttrsToUpdate{
+			VersioningEnabled: true,
+			StorageClass:      "COLDLINE",
+		}
+		update.SetLabel("c", "3")
+		update.DeleteLabel("a")
+		attrs, err := bucket.If(storage.BucketConditions{MetagenerationMatch: 1}).Update(context.Background(), update)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !attrs.VersioningEnabled {
+			t.Error("expected versioning to be enabled")
+		}
+		if attrs.StorageClass != "COLDLINE" {
+			t.Errorf("wrong storage class\nwant %q\ngot  %q", "COLDLINE", attrs.StorageClass)
+		}
+		expectedLabels := map[string]string{"b": "2", "c": "3"}
+		if !reflect.DeepEqual(attrs.Labels, expectedLabels) {
+			t.Errorf("wrong labels\nwant %v\ngot  %v", expectedLabels, attrs.Labels)
+		}
+		if attrs.MetaGeneration != 2 {
+			t.Errorf("wrong metageneration\nwant 2\ngot  %d", attrs.MetaGeneration)
+		}
+
+		attrs, err = bucket.Attrs(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !attrs.VersioningEnabled || !reflect.DeepEqual(attrs.Labels, expectedLabels) {
+			t.Errorf("bucket update wasn't persisted: %+v", attrs)
+		}
+
+		_, err = bucket.If(storage.BucketConditions{MetagenerationMatch: 1}).Update(context.Background(), storage.BucketAttrsToUpdate{VersioningEnabled: false})
+		if !hasStatusCode(err, http.StatusPreconditionFailed) {
+			t.Errorf("expected precondition failure, got %v", err)
+		}
+	})
+}
+
+func TestServerCreateBucketAfterUpdate(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		const bucketName = "updated-bucket"
+		server.CreateBucket(bucketName)
+		bucket := server.Client().Bucket(bucketName)
+		_, err := bucket.Update(context.Background(), storage.BucketAttrsToUpdate{VersioningEnabled: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server.CreateBucket(bucketName)
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: bucketName, Labels: map[string]string{"a": "1"}})
+		attrs, err := bucket.Attrs(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !attrs.VersioningEnabled || len(attrs.Labels) > 0 || attrs.MetaGeneration != 2 {
+			t.Errorf("existing bucket was modified: %+v", attrs)
+		}
+	})
+}
+
+func TestServerBucketPut(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		const bucketName = "bucket-to-replace"
+		server.CreateBucketWithOpts(CreateBucketOpts{
+			Name:              bucketName,
+			VersioningEnabled: true,
+			StorageClass:      "NEARLINE",
+			Labels:            map[string]string{"a": "1"},
+		})
+		var bucket bucketResponse
+		status := sendJSONRequest(t, server, http.MethodPut, "/storage/v1/b/"+bucketName, `{"labels":{"b":"2"}}`, &bucket)
+		if status != http.StatusOK {
+			t.Fatalf("wrong status\nwant %d\ngot  %d", http.StatusOK, status)
+		}
+		if bucket.Versioning.Enabled {
+			t.Error("expected versioning to be disabled")
+		}
+		if bucket.StorageClass != "STANDARD" {
+			t.Errorf("wrong storage class\nwant %q\ngot  %q", "STANDARD", bucket.StorageClass)
+		}
+		if !reflect.DeepEqual(bucket.Labels, map[string]string{"b": "2"}) {
+			t.Errorf("wrong labels: %v", bucket.Labels)
+		}
+	})
+}
+
+func TestServerBucketUpdateErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		bucketName     string
+		body           string
+		expectedStatus int
+	}{
+		{"immutable location", http.MethodPatch, "some-bucket", `{"location":"EU"}`, http.StatusBadRequest},
+		{"unchanged location", http.MethodPatch, "some-bucket", `{"location":"US"}`, http.StatusOK},
+		{"immutable name", http.MethodPut, "some-bucket", `{"name":"other-bucket"}`, http.StatusBadRequest},
+		{"invalid storage class", http.MethodPatch, "some-bucket", `{"storageClass":"FAST"}`, http.StatusBadRequest},
+		{"invalid body", http.MethodPatch, "some-bucket", `{`, http.StatusBadRequest},
+		{"missing bucket", http.MethodPatch, "missing-bucket", `{}`, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		test := test
+		runServersTest(t, nil, func(t *testing.T, server *Server) {
+			server.CreateBucketWithOpts(CreateBucketOpts{Name: "some-bucket"})
+			status := sendJSONRequest(t, server, test.method, "/storage/v1/b/"+test.bucketName, test.body, nil)
+			if status != test.expectedStatus {
+				t.Errorf("%s: wrong status\nwant %d\ngot  %d", test.name, test.expectedStatus, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"sync"
+	"time"
+)
+
+// clock is the source of the current time of the server. It follows the
+// function given in Options.Now, shifted by the durations the server was
+// advanced by with AdvanceTime.
+type clock struct {
+	mtx    sync.RWMutex
+	now    func() time.Time
+	offset time.Duration
+}
+
+func newClock(now func() time.Time) *clock {
+	if now == nil {
+		now = time.Now
+	}
+	return &clock{now: now}
+}
+
+func (c *clock) Now() time.Time {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.now().Add(c.offset)
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.offset += d
+}
@@ -0,0 +1,163 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/fsouza/fake-gcs-server/backend"
+	"github.com/fsouza/fake-gcs-server/internal/checksum"
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxComposeSources = 32
+	maxComponentCount = 1024
+)
+
+type composeRequest struct {
+	Destination   multipartMetadata `json:"destination"`
+	SourceObjects []composeSource   `json:"sourceObjects"`
+}
+
+type composeSource struct {
+	Name                string       `json:"name"`
+	Generation          json.Number  `json:"generation"`
+	ObjectPreconditions *composeCond `json:"objectPreconditions"`
+}
+
+type composeCond struct {
+	IfGenerationMatch json.Number `json:"ifGenerationMatch"`
+}
+
+// composeObject concatenates up to 32 objects of a bucket into the
+// destination object. The checksum of the composite object is combined from
+// the checksums of its sources, and its metadata comes from the destination
+// resource in the request body.
+func (s *Server) composeObject(r *http.Request) jsonResponse {
+	vars := mux.Vars(r)
+	bucketName := vars["bucketName"]
+	objectName := vars["objectName"]
+
+	var req composeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: "Invalid compose request"}
+	}
+	if err := req.Destination.validate(); err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	if len(req.SourceObjects) == 0 {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: "You must provide at least one source component"}
+	}
+	if len(req.SourceObjects) > maxComposeSources {
+		return jsonResponse{
+			status:       http.StatusBadRequest,
+			errorMessage: fmt.Sprintf("The number of source components provided (%d) exceeds the maximum (%d)", len(req.SourceObjects), maxComposeSources),
+		}
+	}
+	if resp := s.checkUploadPreconditions(r, bucketName, objectName); resp != nil {
+		return *resp
+	}
+
+	sources := make([]backend.StreamingObject, 0, len(req.SourceObjects))
+	defer func() {
+		for _, source := range sources {
+			source.Content.Close()
+		}
+	}()
+	for _, sourceObject := range req.SourceObjects {
+		source, resp := s.getComposeSource(bucketName, sourceObject)
+		if resp != nil {
+			return *resp
+		}
+		sources = append(sources, source)
+	}
+
+	var componentCount int64
+	readers := make([]io.Reader, len(sources))
+	for i, source := range sources {
+		readers[i] = source.Content
+		if source.ComponentCount > 0 {
+			componentCount += source.ComponentCount
+		} else {
+			componentCount++
+		}
+	}
+	if componentCount > maxComponentCount {
+		return jsonResponse{
+			status:       http.StatusBadRequest,
+			errorMessage: fmt.Sprintf("The composite object would have %d components, exceeding the maximum (%d)", componentCount, maxComponentCount),
+		}
+	}
+
+	obj := Object{
+		BucketName:         bucketName,
+		Name:               objectName,
+		ContentType:        req.Destination.ContentType,
+		ContentEncoding:    req.Destination.ContentEncoding,
+		ContentDisposition: req.Destination.ContentDisposition,
+		ContentLanguage:    req.Destination.ContentLanguage,
+		CacheControl:       req.Destination.CacheControl,
+		StorageClass:       req.Destination.StorageClass,
+		CustomTime:         req.Destination.CustomTime,
+		TemporaryHold:      req.Destination.TemporaryHold,
+		EventBasedHold:     req.Destination.EventBasedHold,
+		Metadata:           req.Destination.Metadata,
+		ACL:                getObjectACL(r.URL.Query().Get("destinationPredefinedAcl")),
+		Crc32c:             combinedCrc32c(sources),
+		ComponentCount:     componentCount,
+	}
+	obj, err := s.createObjectFromReader(obj, io.MultiReader(readers...))
+	if err != nil {
+		return uploadErrorResponse(err)
+	}
+	return jsonResponse{data: newObjectResponse(obj)}
+}
+
+// getComposeSource opens the given source object, checking its generation
+// preconditions. The caller must close the content of the returned object.
+func (s *Server) getComposeSource(bucketName string, source composeSource) (backend.StreamingObject, *jsonResponse) {
+	var obj backend.StreamingObject
+	var err error
+	if source.Generation != "" {
+		generation, parseErr := strconv.ParseInt(source.Generation.String(), 10, 64)
+		if parseErr != nil {
+			return obj, &jsonResponse{status: http.StatusBadRequest, errorMessage: errInvalidGeneration.Error()}
+		}
+		obj, err = s.backend.GetObjectWithGeneration(bucketName, source.Name, generation)
+	} else {
+		obj, err = s.backend.GetObject(bucketName, source.Name)
+	}
+	if err != nil {
+		return obj, &jsonResponse{status: http.StatusNotFound, errorMessage: fmt.Sprintf("Source object %s not found", source.Name)}
+	}
+	if source.ObjectPreconditions != nil && source.ObjectPreconditions.IfGenerationMatch != "" {
+		ifGenerationMatch, err := strconv.ParseInt(source.ObjectPreconditions.IfGenerationMatch.String(), 10, 64)
+		if err != nil || ifGenerationMatch != obj.Generation {
+			obj.Content.Close()
+			return backend.StreamingObject{}, &jsonResponse{status: http.StatusPreconditionFailed, errorMessage: "Precondition failed"}
+		}
+	}
+	return obj, nil
+}
+
+// combinedCrc32c combines the CRC32C checksums of the given objects into the
+// checksum of their concatenation. It returns an empty string if any of the
+// checksums is missing, leaving it to be computed from the content.
+func combinedCrc32c(objects []backend.StreamingObject) string {
+	var crc uint32
+	for _, obj := range objects {
+		objCrc, err := checksum.DecodeCrc32cChecksum(obj.Crc32c)
+		if err != nil {
+			return ""
+		}
+		crc = checksum.CombineCrc32c(crc, objCrc, obj.Size)
+	}
+	return checksum.EncodedCrc32cValue(crc)
+}
@@ -0,0 +1,175 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+func TestServerClientObjectCompose(t *testing.T) {
+	const bucketName = "some-bucket"
+	objs := []Object{
+		{BucketName: bucketName, Name: "logs/1.log", Content: []byte("first line\n")},
+		{BucketName: bucketName, Name: "logs/2.log", Content: []byte("second line\n")},
+		{BucketName: bucketName, Name: "logs/3.log", Content: []byte("third line\n")},
+	}
+	const expectedContent = "first line\nsecond line\nthird line\n"
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		client := server.Client()
+		bucket := client.Bucket(bucketName)
+		composer := bucket.Object("logs/all.log").ComposerFrom(
+			bucket.Object("logs/1.log"),
+			bucket.Object("logs/2.log"),
+			bucket.Object("logs/3.log"),
+		)
+		composer.ContentType = "text/plain"
+		composer.Metadata = map[string]string{"kind": "logs"}
+		attrs, err := composer.Run(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, err := server.GetObject(bucketName, "logs/all.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.ComponentCount != 3 {
+			t.Errorf("wrong component count\nwant 3\ngot  %d", obj.ComponentCount)
+		}
+		if obj.Md5Hash != "" || len(attrs.MD5) > 0 {
+			t.Errorf("unexpected md5 hash for composite object: %q", obj.Md5Hash)
+		}
+		if attrs.ContentType != "text/plain" {
+			t.Errorf("wrong content type\nwant %q\ngot  %q", "text/plain", attrs.ContentType)
+		}
+		if !reflect.DeepEqual(attrs.Metadata, map[string]string{"kind": "logs"}) {
+			t.Errorf("wrong metadata\nwant %v\ngot  %v", map[string]string{"kind": "logs"}, attrs.Metadata)
+		}
+		if expected := uint32Checksum([]byte(expectedContent)); attrs.CRC32C != expected {
+			t.Errorf("wrong crc32c\nwant %d\ngot  %d", expected, attrs.CRC32C)
+		}
+		if attrs.Size != int64(len(expectedContent)) {
+			t.Errorf("wrong size\nwant %d\ngot  %d", len(expectedContent), attrs.Size)
+		}
+
+		reader, err := bucket.Object("logs/all.log").NewReader(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expectedContent {
+			t.Errorf("wrong content\nwant %q\ngot  %q", expectedContent, data)
+		}
+
+		composer = bucket.Object("logs/twice.log").ComposerFrom(
+			bucket.Object("logs/all.log"),
+			bucket.Object("logs/1.log"),
+		)
+		_, err = composer.Run(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, err = server.GetObject(bucketName, "logs/twice.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.ComponentCount != 4 {
+			t.Errorf("wrong component count\nwant 4\ngot  %d", obj.ComponentCount)
+		}
+	})
+}
+
+func TestServerClientObjectComposeErrors(t *testing.T) {
+	const bucketName = "some-bucket"
+	objs := []Object{
+		{BucketName: bucketName, Name: "1.txt", Content: []byte("1")},
+		{BucketName: bucketName, Name: "2.txt", Content: []byte("2")},
+	}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		client := server.Client()
+		bucket := client.Bucket(bucketName)
+		attrs, err := bucket.Object("1.txt").Attrs(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tooManySources []*storage.ObjectHandle
+		for i := 0; i < 33; i++ {
+			tooManySources = append(tooManySources, bucket.Object("1.txt"))
+		}
+
+		tests := []struct {
+			name           string
+			sources        []*storage.ObjectHandle
+			expectedStatus int
+		}{
+			{
+				"generation precondition",
+				[]*storage.ObjectHandle{
+					bucket.Object("1.txt").If(storage.Conditions{GenerationMatch: attrs.Generation + 1}),
+					bucket.Object("2.txt"),
+				},
+				http.StatusPreconditionFailed,
+			},
+			{
+				"missing source",
+				[]*storage.ObjectHandle{bucket.Object("1.txt"), bucket.Object("3.txt")},
+				http.StatusNotFound,
+			},
+			{
+				"missing generation",
+				[]*storage.ObjectHandle{bucket.Object("1.txt").Generation(attrs.Generation + 1)},
+				http.StatusNotFound,
+			},
+			{
+				"too many sources",
+				tooManySources,
+				http.StatusBadRequest,
+			},
+		}
+		for _, test := range tests {
+			test := test
+			t.Run(test.name, func(t *testing.T) {
+				_, err := bucket.Object("composed.txt").ComposerFrom(test.sources...).Run(context.TODO())
+				var apiErr *googleapi.Error
+				if !errors.As(err, &apiErr) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if apiErr.Code != test.expectedStatus {
+					t.Errorf("wrong status code\nwant %d\ngot  %d", test.expectedStatus, apiErr.Code)
+				}
+			})
+		}
+	})
+}
+
+func TestServerObjectComposeInvalidBody(t *testing.T) {
+	server := NewServer([]Object{{BucketName: "some-bucket", Name: "1.txt"}})
+	defer server.Stop()
+	client := server.HTTPClient()
+	for _, body := range []string{"not json", `{"sourceObjects":[]}`} {
+		resp, err := client.Post(server.URL()+"/storage/v1/b/some-bucket/o/composed.txt/compose", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("wrong status code for %q\nwant %d\ngot  %d", body, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
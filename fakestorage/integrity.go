@@ -0,0 +1,127 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fsouza/fake-gcs-server/internal/checksum"
+)
+
+var (
+	errInvalidHash      = errors.New("invalid hash")
+	errChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// expectedHashes are the checksums that the client declared for the content
+// of an upload, which the content must match for the upload to succeed. Both
+// hashes are base64-encoded, and empty hashes aren't checked.
+type expectedHashes struct {
+	md5Hash string
+	crc32c  string
+}
+
+// add records the given hashes, which must be valid base64-encoded MD5 and
+// CRC32C hashes, normalizing their encoding. Empty values are ignored.
+func (h *expectedHashes) add(md5Hash, crc32c string) error {
+	if md5Hash != "" {
+		decoded, err := base64.StdEncoding.DecodeString(md5Hash)
+		if err != nil || len(decoded) != 16 {
+			return fmt.Errorf("%w: invalid MD5 hash %q", errInvalidHash, md5Hash)
+		}
+		h.md5Hash = checksum.EncodedHash(decoded)
+	}
+	if crc32c != "" {
+		value, err := checksum.DecodeCrc32cChecksum(crc32c)
+		if err != nil {
+			return fmt.Errorf("%w: invalid CRC32C checksum %q", errInvalidHash, crc32c)
+		}
+		h.crc32c = checksum.EncodedCrc32cValue(value)
+	}
+	return nil
+}
+
+// addFromHeader records the hashes sent in the X-Goog-Hash and Content-MD5
+// headers of a request.
+func (h *expectedHashes) addFromHeader(header http.Header) error {
+	for _, value := range header.Values("X-Goog-Hash") {
+		for _, item := range strings.Split(value, ",") {
+			parts := strings.SplitN(strings.TrimSpace(item), "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("%w: invalid X-Goog-Hash %q", errInvalidHash, value)
+			}
+			var err error
+			switch parts[0] {
+			case "md5":
+				err = h.add(parts[1], "")
+			case "crc32c":
+				err = h.add("", parts[1])
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return h.add(header.Get("Content-MD5"), "")
+}
+
+func (h expectedHashes) isEmpty() bool {
+	return h.md5Hash == "" && h.crc32c == ""
+}
+
+// validatingReader returns a reader that yields the content of the given
+// reader, failing with errChecksumMismatch instead of io.EOF if the content
+// doesn't match the expected hashes, so backends discard it instead of
+// storing the object.
+func (h expectedHashes) validatingReader(r io.Reader) io.Reader {
+	if h.isEmpty() {
+		return r
+	}
+	return &hashValidatingReader{r: r, expected: h, hasher: checksum.NewStreamingHasher()}
+}
+
+type hashValidatingReader struct {
+	r        io.Reader
+	expected expectedHashes
+	hasher   *checksum.StreamingHasher
+}
+
+func (r *hashValidatingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.hasher.Write(p[:n])
+	if err == io.EOF {
+		if validationErr := r.validate(); validationErr != nil {
+			return n, validationErr
+		}
+	}
+	return n, err
+}
+
+func (r *hashValidatingReader) validate() error {
+	if md5Hash := r.hasher.EncodedMd5Hash(); r.expected.md5Hash != "" && r.expected.md5Hash != md5Hash {
+		return fmt.Errorf("%w: provided MD5 hash %q doesn't match calculated MD5 hash %q", errChecksumMismatch, r.expected.md5Hash, md5Hash)
+	}
+	if crc32c := r.hasher.EncodedCrc32cChecksum(); r.expected.crc32c != "" && r.expected.crc32c != crc32c {
+		return fmt.Errorf("%w: provided CRC32C %q doesn't match calculated CRC32C %q", errChecksumMismatch, r.expected.crc32c, crc32c)
+	}
+	return nil
+}
+
+// uploadErrorResponse returns the response for an error that happened while
+// storing the content of an upload.
+func uploadErrorResponse(err error) jsonResponse {
+	if errors.Is(err, errChecksumMismatch) {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	if errors.Is(err, errObjectRetained) {
+		return jsonResponse{status: http.StatusForbidden, errorMessage: err.Error()}
+	}
+	return jsonResponse{errorMessage: err.Error()}
+}
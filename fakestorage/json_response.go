@@ -33,6 +33,9 @@ func jsonToHTTPHandler(h jsonHandler) http.HandlerFunc {
 		}
 
 		w.WriteHeader(status)
+		if status == http.StatusNotModified {
+			return
+		}
 		json.NewEncoder(w).Encode(data)
 	}
 }
@@ -0,0 +1,301 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fsouza/fake-gcs-server/backend"
+)
+
+const (
+	lifecycleActionDelete          = "Delete"
+	lifecycleActionSetStorageClass = "SetStorageClass"
+
+	// defaultLifecycleInterval is how often lifecycle rules are applied in
+	// the background when Options.LifecycleInterval isn't set.
+	defaultLifecycleInterval = time.Minute
+
+	createdBeforeFormat = "2006-01-02"
+	day                 = 24 * time.Hour
+)
+
+type bucketLifecycle struct {
+	Rule []lifecycleRule `json:"rule,omitempty"`
+}
+
+type lifecycleRule struct {
+	Action    lifecycleAction    `json:"action"`
+	Condition lifecycleCondition `json:"condition"`
+}
+
+type lifecycleAction struct {
+	Type         string `json:"type"`
+	StorageClass string `json:"storageClass,omitempty"`
+}
+
+type lifecycleCondition struct {
+	Age                 *int64   `json:"age,omitempty"`
+	CreatedBefore       string   `json:"createdBefore,omitempty"`
+	DaysSinceCustomTime *int64   `json:"daysSinceCustomTime,omitempty"`
+	IsLive              *bool    `json:"isLive,omitempty"`
+	NumNewerVersions    int64    `json:"numNewerVersions,omitempty"`
+	MatchesPrefix       []string `json:"matchesPrefix,omitempty"`
+	MatchesSuffix       []string `json:"matchesSuffix,omitempty"`
+	MatchesStorageClass []string `json:"matchesStorageClass,omitempty"`
+}
+
+// newBucketLifecycle returns the lifecycle resource of a bucket, or nil if the
+// bucket has no lifecycle rules.
+func newBucketLifecycle(lifecycle backend.Lifecycle) *bucketLifecycle {
+	if len(lifecycle.Rules) == 0 {
+		return nil
+	}
+	rules := make([]lifecycleRule, len(lifecycle.Rules))
+	for i, rule := range lifecycle.Rules {
+		cond := rule.Condition
+		rules[i] = lifecycleRule{
+			Action: lifecycleAction{Type: rule.Action.Type, StorageClass: rule.Action.StorageClass},
+			Condition: lifecycleCondition{
+				Age:                 cond.Age,
+				CreatedBefore:       cond.CreatedBefore,
+				DaysSinceCustomTime: cond.DaysSinceCustomTime,
+				IsLive:              cond.IsLive,
+				NumNewerVersions:    cond.NumNewerVersions,
+				MatchesPrefix:       cond.MatchesPrefix,
+				MatchesSuffix:       cond.MatchesSuffix,
+				MatchesStorageClass: cond.MatchesStorageClass,
+			},
+		}
+	}
+	return &bucketLifecycle{Rule: rules}
+}
+
+// toBackend validates the lifecycle configuration sent by clients, returning
+// it in the format stored by the backend.
+func (l *bucketLifecycle) toBackend() (backend.Lifecycle, error) {
+	var lifecycle backend.Lifecycle
+	if l == nil {
+		return lifecycle, nil
+	}
+	for _, rule := range l.Rule {
+		if err := rule.validate(); err != nil {
+			return lifecycle, err
+		}
+		cond := rule.Condition
+		lifecycle.Rules = append(lifecycle.Rules, backend.LifecycleRule{
+			Action: backend.LifecycleAction{Type: rule.Action.Type, StorageClass: rule.Action.StorageClass},
+			Condition: backend.LifecycleCondition{
+				Age:                 cond.Age,
+				CreatedBefore:       cond.CreatedBefore,
+				DaysSinceCustomTime: cond.DaysSinceCustomTime,
+				IsLive:              cond.IsLive,
+				NumNewerVersions:    cond.NumNewerVersions,
+				MatchesPrefix:       cond.MatchesPrefix,
+				MatchesSuffix:       cond.MatchesSuffix,
+				MatchesStorageClass: cond.MatchesStorageClass,
+			},
+		})
+	}
+	return lifecycle, nil
+}
+
+func (r lifecycleRule) validate() error {
+	switch r.Action.Type {
+	case lifecycleActionDelete:
+	case lifecycleActionSetStorageClass:
+		if !isValidStorageClass(r.Action.StorageClass) {
+			return errInvalidStorageClass
+		}
+	default:
+		return fmt.Errorf("invalid lifecycle action %q", r.Action.Type)
+	}
+	cond := r.Condition
+	if cond.Age == nil && cond.CreatedBefore == "" && cond.DaysSinceCustomTime == nil &&
+		cond.IsLive == nil && cond.NumNewerVersions == 0 && len(cond.MatchesPrefix) == 0 &&
+		len(cond.MatchesSuffix) == 0 && len(cond.MatchesStorageClass) == 0 {
+		return errors.New("lifecycle rules require at least one condition")
+	}
+	if (cond.Age != nil && *cond.Age < 0) || (cond.DaysSinceCustomTime != nil && *cond.DaysSinceCustomTime < 0) || cond.NumNewerVersions < 0 {
+		return errors.New("lifecycle conditions can't be negative")
+	}
+	if cond.CreatedBefore != "" {
+		if _, err := time.Parse(createdBeforeFormat, cond.CreatedBefore); err != nil {
+			return fmt.Errorf("invalid createdBefore date %q", cond.CreatedBefore)
+		}
+	}
+	return nil
+}
+
+// AdvanceTime moves the clock of the server forward by the given duration,
+// then applies the lifecycle rules of the buckets, so their effects can be
+// checked deterministically.
+func (s *Server) AdvanceTime(d time.Duration) error {
+	s.clock.advance(d)
+	return s.ApplyLifecycleRules()
+}
+
+// ApplyLifecycleRules applies the lifecycle rules of all the buckets at the
+// current time of the server. Rules are also applied periodically in the
+// background, as configured by Options.LifecycleInterval.
+func (s *Server) ApplyLifecycleRules() error {
+	s.lifecycleMtx.Lock()
+	defer s.lifecycleMtx.Unlock()
+	buckets, err := s.backend.ListBuckets()
+	if err != nil {
+		return err
+	}
+	now := s.clock.Now()
+	for _, bucket := range buckets {
+		if len(bucket.Lifecycle.Rules) == 0 {
+			continue
+		}
+		if err := s.applyBucketLifecycle(bucket, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyBucketLifecycle takes the action of the first lifecycle rule of the
+// bucket matching each of its objects, with Delete actions taking precedence
+// over SetStorageClass. Like in GCS, deleting the live version of an object
+// in a versioned bucket makes it noncurrent, and retained objects aren't
+// deleted. Storage classes are only changed on live objects.
+func (s *Server) applyBucketLifecycle(bucket backend.Bucket, now time.Time) error {
+	objs, _, err := s.backend.ListObjects(bucket.Name, backend.ListOptions{Versions: true})
+	if err != nil {
+		return err
+	}
+	newerVersions := make([]int64, len(objs))
+	seen := map[string]int64{}
+	for i := len(objs) - 1; i >= 0; i-- {
+		newerVersions[i] = seen[objs[i].Name]
+		seen[objs[i].Name]++
+	}
+	for i, obj := range objs {
+		live := convertTimeWithoutError(obj.Deleted).IsZero()
+		action, ok := matchLifecycleRules(bucket.Lifecycle.Rules, obj, live, newerVersions[i], now)
+		if !ok {
+			continue
+		}
+		switch {
+		case action.Type == lifecycleActionDelete && s.isRetained(bucket, obj):
+		case action.Type == lifecycleActionDelete && live:
+			err = s.backend.DeleteObject(bucket.Name, obj.Name)
+		case action.Type == lifecycleActionDelete:
+			err = s.backend.DeleteObjectWithGeneration(bucket.Name, obj.Name, obj.Generation)
+		case live && getStorageClassIfEmpty(obj.StorageClass) != action.StorageClass:
+			_, err = s.backend.PatchObject(bucket.Name, obj.Name, backend.ObjectPatch{StorageClass: &action.StorageClass})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchLifecycleRules returns the action to take on the given object, if
+// any of the rules match it.
+func matchLifecycleRules(rules []backend.LifecycleRule, obj backend.ObjectAttrs, live bool, newerVersions int64, now time.Time) (backend.LifecycleAction, bool) {
+	var action backend.LifecycleAction
+	matched := false
+	for _, rule := range rules {
+		if !lifecycleConditionMatches(rule.Condition, obj, live, newerVersions, now) {
+			continue
+		}
+		if rule.Action.Type == lifecycleActionDelete {
+			return rule.Action, true
+		}
+		if !matched {
+			action, matched = rule.Action, true
+		}
+	}
+	return action, matched
+}
+
+func lifecycleConditionMatches(cond backend.LifecycleCondition, obj backend.ObjectAttrs, live bool, newerVersions int64, now time.Time) bool {
+	created := convertTimeWithoutError(obj.Created)
+	if cond.Age != nil && now.Sub(created) < time.Duration(*cond.Age)*day {
+		return false
+	}
+	if cond.CreatedBefore != "" {
+		date, _ := time.Parse(createdBeforeFormat, cond.CreatedBefore)
+		if !created.Before(date) {
+			return false
+		}
+	}
+	if cond.DaysSinceCustomTime != nil {
+		if obj.CustomTime == "" || now.Sub(convertTimeWithoutError(obj.CustomTime)) < time.Duration(*cond.DaysSinceCustomTime)*day {
+			return false
+		}
+	}
+	if cond.IsLive != nil && *cond.IsLive != live {
+		return false
+	}
+	if newerVersions < cond.NumNewerVersions {
+		return false
+	}
+	if len(cond.MatchesPrefix) > 0 && !matchesAny(obj.Name, cond.MatchesPrefix, strings.HasPrefix) {
+		return false
+	}
+	if len(cond.MatchesSuffix) > 0 && !matchesAny(obj.Name, cond.MatchesSuffix, strings.HasSuffix) {
+		return false
+	}
+	if len(cond.MatchesStorageClass) > 0 && !matchesAny(getStorageClassIfEmpty(obj.StorageClass), cond.MatchesStorageClass, func(a, b string) bool { return a == b }) {
+		return false
+	}
+	return true
+}
+
+func matchesAny(value string, patterns []string, match func(string, string) bool) bool {
+	for _, pattern := range patterns {
+		if match(value, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// startLifecycle starts the background loop that applies the lifecycle rules,
+// unless it's already running.
+func (s *Server) startLifecycle() {
+	s.startOnce.Do(func() {
+		go s.runLifecycle(s.lifecycleInterval())
+	})
+}
+
+// watchLifecycle starts the background loop when the given lifecycle
+// configuration has rules.
+func (s *Server) watchLifecycle(lifecycle backend.Lifecycle) {
+	if len(lifecycle.Rules) > 0 {
+		s.startLifecycle()
+	}
+}
+
+// runLifecycle applies the lifecycle rules periodically, until the server is
+// stopped.
+func (s *Server) runLifecycle(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.ApplyLifecycleRules()
+		case <-s.stopLifecycle:
+			return
+		}
+	}
+}
+
+func (s *Server) lifecycleInterval() time.Duration {
+	if s.options.LifecycleInterval > 0 {
+		return s.options.LifecycleInterval
+	}
+	return defaultLifecycleInterval
+}
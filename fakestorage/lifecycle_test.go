@@ -0,0 +1,273 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/fsouza/fake-gcs-server/backend"
+)
+
+func TestServerClientBucketLifecycleDelete(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		const bucketName = "lifecycle-bucket"
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: bucketName})
+		server.CreateObject(Object{BucketName: bucketName, Name: "file.txt", Content: []byte("something")})
+		client := server.Client()
+		lifecycle := storage.Lifecycle{Rules: []storage.LifecycleRule{{
+			Action:    storage.LifecycleAction{Type: storage.DeleteAction},
+			Condition: storage.LifecycleCondition{AgeInDays: 30},
+		}}}
+		attrs, err := client.Bucket(bucketName).Update(context.Background(), storage.BucketAttrsToUpdate{Lifecycle: &lifecycle})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(attrs.Lifecycle.Rules) != 1 || attrs.Lifecycle.Rules[0].Condition.AgeInDays != 30 {
+			t.Errorf("wrong lifecycle returned: %+v", attrs.Lifecycle)
+		}
+
+		if err := server.AdvanceTime(29 * day); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.GetObject(bucketName, "file.txt"); err != nil {
+			t.Fatalf("object deleted before reaching the age in the rule: %v", err)
+		}
+		if err := server.AdvanceTime(2 * day); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.GetObject(bucketName, "file.txt"); err == nil {
+			t.Error("object wasn't deleted after reaching the age in the rule")
+		}
+	})
+}
+
+func TestServerBucketLifecycleMatches(t *testing.T) {
+	objs := []Object{
+		{BucketName: "some-bucket", Name: "logs/app.log", Content: []byte("log")},
+		{BucketName: "some-bucket", Name: "data/file.txt", Content: []byte("data")},
+		{BucketName: "some-bucket", Name: "data/file.tmp", Content: []byte("tmp")},
+	}
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		var bucket bucketResponse
+		status := sendJSONRequest(t, server, http.MethodPatch, "/storage/v1/b/some-bucket", `{"lifecycle":{"rule":[
+			{"action":{"type":"SetStorageClass","storageClass":"NEARLINE"},"condition":{"matchesPrefix":["logs/"]}},
+			{"action":{"type":"Delete"},"condition":{"matchesSuffix":[".tmp"],"isLive":true}}
+		]}}`, &bucket)
+		if status != http.StatusOK {
+			t.Fatalf("wrong status\nwant %d\ngot  %d", http.StatusOK, status)
+		}
+		if bucket.Lifecycle == nil || len(bucket.Lifecycle.Rule) != 2 {
+			t.Fatalf("wrong lifecycle returned: %+v", bucket.Lifecycle)
+		}
+		if err := server.ApplyLifecycleRules(); err != nil {
+			t.Fatal(err)
+		}
+
+		storageClasses := map[string]string{
+			"logs/app.log":  "NEARLINE",
+			"data/file.txt": "STANDARD",
+		}
+		for name, expected := range storageClasses {
+			obj, err := server.GetObject("some-bucket", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if getStorageClassIfEmpty(obj.StorageClass) != expected {
+				t.Errorf("wrong storage class for %s\nwant %q\ngot  %q", name, expected, obj.StorageClass)
+			}
+		}
+		if _, err := server.GetObject("some-bucket", "data/file.tmp"); err == nil {
+			t.Error("object matching the delete rule wasn't deleted")
+		}
+	})
+}
+
+func TestServerBucketLifecycleVersions(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		const bucketName = "versioned-bucket"
+		server.CreateBucketWithOpts(CreateBucketOpts{
+			Name:              bucketName,
+			VersioningEnabled: true,
+			Lifecycle: backend.Lifecycle{Rules: []backend.LifecycleRule{{
+				Action:    backend.LifecycleAction{Type: lifecycleActionDelete},
+				Condition: backend.LifecycleCondition{NumNewerVersions: 2},
+			}}},
+		})
+		var generations []int64
+		for _, content := range []string{"first", "second", "third"} {
+			obj, err := server.createObject(Object{BucketName: bucketName, Name: "file.txt", Content: []byte(content)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			generations = append(generations, obj.Generation)
+		}
+		if err := server.ApplyLifecycleRules(); err != nil {
+			t.Fatal(err)
+		}
+		objs, _, err := server.ListObjectsWithOptions(bucketName, ListOptions{Versions: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objs) != 2 {
+			t.Fatalf("wrong number of versions\nwant 2\ngot  %d", len(objs))
+		}
+		for i, obj := range objs {
+			if obj.Generation != generations[i+1] {
+				t.Errorf("wrong generation at %d\nwant %d\ngot  %d", i, generations[i+1], obj.Generation)
+			}
+		}
+	})
+}
+
+func TestServerBucketLifecycleTimeConditions(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		const bucketName = "some-bucket"
+		now := server.clock.Now()
+		server.CreateBucketWithOpts(CreateBucketOpts{
+			Name: bucketName,
+			Lifecycle: backend.Lifecycle{Rules: []backend.LifecycleRule{
+				{
+					Action:    backend.LifecycleAction{Type: lifecycleActionDelete},
+					Condition: backend.LifecycleCondition{CreatedBefore: now.Add(-2 * day).Format(createdBeforeFormat)},
+				},
+				{
+					Action:    backend.LifecycleAction{Type: lifecycleActionSetStorageClass, StorageClass: "ARCHIVE"},
+					Condition: backend.LifecycleCondition{DaysSinceCustomTime: int64Ptr(3)},
+				},
+			}},
+		})
+		server.CreateObject(Object{BucketName: bucketName, Name: "old.txt", Created: now.Add(-7 * day)})
+		server.CreateObject(Object{BucketName: bucketName, Name: "new.txt"})
+		server.CreateObject(Object{BucketName: bucketName, Name: "custom.txt", CustomTime: now})
+
+		if err := server.AdvanceTime(2 * day); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.GetObject(bucketName, "old.txt"); err == nil {
+			t.Error("object created before the date in the rule wasn't deleted")
+		}
+		if _, err := server.GetObject(bucketName, "new.txt"); err != nil {
+			t.Errorf("object created after the date in the rule was deleted: %v", err)
+		}
+		obj, err := server.GetObject(bucketName, "custom.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.StorageClass != "STANDARD" {
+			t.Errorf("storage class changed too early to %q", obj.StorageClass)
+		}
+
+		if err := server.AdvanceTime(day); err != nil {
+			t.Fatal(err)
+		}
+		obj, err = server.GetObject(bucketName, "custom.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.StorageClass != "ARCHIVE" {
+			t.Errorf("wrong storage class\nwant %q\ngot  %q", "ARCHIVE", obj.StorageClass)
+		}
+	})
+}
+
+func TestServerInjectedClock(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	server, err := NewServerWithOptions(Options{
+		NoListener: true,
+		Now:        func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	server.CreateBucketWithOpts(CreateBucketOpts{Name: "some-bucket"})
+	server.CreateObject(Object{BucketName: "some-bucket", Name: "first.txt"})
+	server.AdvanceTime(time.Hour)
+	server.CreateObject(Object{BucketName: "some-bucket", Name: "second.txt"})
+
+	expectedTimes := map[string]time.Time{
+		"first.txt":  now,
+		"second.txt": now.Add(time.Hour),
+	}
+	for name, expected := range expectedTimes {
+		obj, err := server.GetObject("some-bucket", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !obj.Created.Equal(expected) {
+			t.Errorf("wrong creation time for %s\nwant %s\ngot  %s", name, expected, obj.Created)
+		}
+	}
+}
+
+func TestServerInjectedClockUpdates(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	server, err := NewServerWithOptions(Options{
+		NoListener: true,
+		Now:        func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	server.CreateBucketWithOpts(CreateBucketOpts{Name: "some-bucket", VersioningEnabled: true})
+	server.CreateObject(Object{BucketName: "some-bucket", Name: "file.txt"})
+	obj := server.Client().Bucket("some-bucket").Object("file.txt")
+
+	server.AdvanceTime(time.Hour)
+	attrs, err := obj.Update(context.Background(), storage.ObjectAttrsToUpdate{ContentType: "text/csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := now.Add(time.Hour); !attrs.Updated.Equal(expected) {
+		t.Errorf("wrong update time\nwant %s\ngot  %s", expected, attrs.Updated)
+	}
+
+	server.AdvanceTime(time.Hour)
+	if err := obj.Delete(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	objs, _, err := server.ListObjectsWithOptions("some-bucket", ListOptions{Versions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 {
+		t.Fatalf("wrong number of versions\nwant 1\ngot  %d", len(objs))
+	}
+	if expected := now.Add(2 * time.Hour); !objs[0].Deleted.Equal(expected) {
+		t.Errorf("wrong deletion time\nwant %s\ngot  %s", expected, objs[0].Deleted)
+	}
+}
+
+func TestServerBucketLifecycleValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"invalid action", `{"lifecycle":{"rule":[{"action":{"type":"Archive"},"condition":{"age":1}}]}}`},
+		{"missing storage class", `{"lifecycle":{"rule":[{"action":{"type":"SetStorageClass"},"condition":{"age":1}}]}}`},
+		{"no conditions", `{"lifecycle":{"rule":[{"action":{"type":"Delete"},"condition":{}}]}}`},
+		{"negative age", `{"lifecycle":{"rule":[{"action":{"type":"Delete"},"condition":{"age":-1}}]}}`},
+		{"invalid date", `{"lifecycle":{"rule":[{"action":{"type":"Delete"},"condition":{"createdBefore":"yesterday"}}]}}`},
+	}
+	for _, test := range tests {
+		test := test
+		runServersTest(t, nil, func(t *testing.T, server *Server) {
+			server.CreateBucketWithOpts(CreateBucketOpts{Name: "some-bucket"})
+			status := sendJSONRequest(t, server, http.MethodPatch, "/storage/v1/b/some-bucket", test.body, nil)
+			if status != http.StatusBadRequest {
+				t.Errorf("%s: wrong status\nwant %d\ngot  %d", test.name, http.StatusBadRequest, status)
+			}
+		})
+	}
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
@@ -5,19 +5,20 @@
 package fakestorage
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"math"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
-	"sort"
+	"net/textproto"
 	"strconv"
-	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
-	"github.com/fsouza/fake-gcs-server/internal/backend"
+	"github.com/fsouza/fake-gcs-server/backend"
 	"github.com/gorilla/mux"
 )
 
@@ -25,11 +26,20 @@ var errInvalidGeneration = errors.New("invalid generation ID")
 
 // Object represents the object that is stored within the fake server.
 type Object struct {
-	BucketName      string
-	Name            string
-	ContentType     string
-	ContentEncoding string
-	Content         []byte
+	BucketName         string
+	Name               string
+	ContentType        string
+	ContentEncoding    string
+	ContentDisposition string
+	ContentLanguage    string
+	CacheControl       string
+	// StorageClass of the object, filled by the server with the default
+	// storage class of the bucket when empty.
+	StorageClass string
+	Content      []byte
+	// Size of Content. Filled by the server, as objects returned by
+	// ListObjectsWithOptions don't include their content.
+	Size int64
 	// Crc32c checksum of Content. calculated by server when it's upload methods are used.
 	Crc32c  string
 	Md5Hash string
@@ -40,38 +50,86 @@ type Object struct {
 	Updated    time.Time
 	Deleted    time.Time
 	Generation int64
-	Metadata   map[string]string
+	// Metageneration is incremented on every change to the metadata of the
+	// object, starting at 1.
+	Metageneration int64
+	Metadata       map[string]string
+	// Number of components of composite objects, zero for objects that
+	// weren't created by compose.
+	ComponentCount int64
+	// Name of the Cloud KMS key used to encrypt the object, empty for
+	// objects encrypted with Google-managed keys.
+	KmsKeyName string
+	// CustomTime is a user-specified timestamp for the object, used by
+	// lifecycle conditions.
+	CustomTime time.Time
+	// Objects under a temporary or event-based hold can't be deleted or
+	// replaced.
+	TemporaryHold  bool
+	EventBasedHold bool
+	// RetentionExpirationTime is the time until which the object can't be
+	// deleted or replaced, as set by the retention policy of its bucket. It's
+	// filled by the server, and ignored when creating objects.
+	RetentionExpirationTime time.Time
 }
 
 // MarshalJSON for Object to use ACLRule instead of storage.ACLRule
 func (o Object) MarshalJSON() ([]byte, error) {
 	temp := struct {
-		BucketName      string            `json:"bucket"`
-		Name            string            `json:"name"`
-		ContentType     string            `json:"contentType"`
-		ContentEncoding string            `json:"contentEncoding"`
-		Content         []byte            `json:"-"`
-		Crc32c          string            `json:"crc32c,omitempty"`
-		Md5Hash         string            `json:"md5Hash,omitempty"`
-		ACL             []aclRule         `json:"acl,omitempty"`
-		Created         time.Time         `json:"created,omitempty"`
-		Updated         time.Time         `json:"updated,omitempty"`
-		Deleted         time.Time         `json:"deleted,omitempty"`
-		Generation      int64             `json:"generation,omitempty,string"`
-		Metadata        map[string]string `json:"metadata,omitempty"`
+		BucketName         string            `json:"bucket"`
+		Name               string            `json:"name"`
+		ContentType        string            `json:"contentType"`
+		ContentEncoding    string            `json:"contentEncoding"`
+		ContentDisposition string            `json:"contentDisposition,omitempty"`
+		ContentLanguage    string            `json:"contentLanguage,omitempty"`
+		CacheControl       string            `json:"cacheControl,omitempty"`
+		StorageClass       string            `json:"storageClass,omitempty"`
+		Content            []byte            `json:"-"`
+		Size               int64             `json:"size,string"`
+		Crc32c             string            `json:"crc32c,omitempty"`
+		Md5Hash            string            `json:"md5Hash,omitempty"`
+		ACL                []aclRule         `json:"acl,omitempty"`
+		Created            time.Time         `json:"created,omitempty"`
+		Updated            time.Time         `json:"updated,omitempty"`
+		Deleted            time.Time         `json:"deleted,omitempty"`
+		Generation         int64             `json:"generation,omitempty,string"`
+		Metageneration     int64             `json:"metageneration,omitempty,string"`
+		Metadata           map[string]string `json:"metadata,omitempty"`
+		ComponentCount     int64             `json:"componentCount,omitempty"`
+		KmsKeyName         string            `json:"kmsKeyName,omitempty"`
+		CustomTime         *time.Time        `json:"customTime,omitempty"`
+		TemporaryHold      bool              `json:"temporaryHold,omitempty"`
+		EventBasedHold     bool              `json:"eventBasedHold,omitempty"`
+		RetentionExpiry    *time.Time        `json:"retentionExpirationTime,omitempty"`
 	}{
-		BucketName:      o.BucketName,
-		Name:            o.Name,
-		ContentType:     o.ContentType,
-		ContentEncoding: o.ContentEncoding,
-		Content:         o.Content,
-		Crc32c:          o.Crc32c,
-		Md5Hash:         o.Md5Hash,
-		Created:         o.Created,
-		Updated:         o.Updated,
-		Deleted:         o.Deleted,
-		Generation:      o.Generation,
-		Metadata:        o.Metadata,
+		BucketName:         o.BucketName,
+		Name:               o.Name,
+		ContentType:        o.ContentType,
+		ContentEncoding:    o.ContentEncoding,
+		ContentDisposition: o.ContentDisposition,
+		ContentLanguage:    o.ContentLanguage,
+		CacheControl:       o.CacheControl,
+		StorageClass:       o.StorageClass,
+		Content:            o.Content,
+		Size:               o.Size,
+		Crc32c:             o.Crc32c,
+		Md5Hash:            o.Md5Hash,
+		Created:            o.Created,
+		Updated:            o.Updated,
+		Deleted:            o.Deleted,
+		Generation:         o.Generation,
+		Metageneration:     o.Metageneration,
+		Metadata:           o.Metadata,
+		ComponentCount:     o.ComponentCount,
+		KmsKeyName:         o.KmsKeyName,
+		TemporaryHold:      o.TemporaryHold,
+		EventBasedHold:     o.EventBasedHold,
+	}
+	if !o.CustomTime.IsZero() {
+		temp.CustomTime = &o.CustomTime
+	}
+	if !o.RetentionExpirationTime.IsZero() {
+		temp.RetentionExpiry = &o.RetentionExpirationTime
 	}
 	temp.ACL = make([]aclRule, len(o.ACL))
 	for i, ACL := range o.ACL {
@@ -83,19 +141,31 @@ func (o Object) MarshalJSON() ([]byte, error) {
 // UnmarshalJSON for Object to use ACLRule instead of storage.ACLRule
 func (o *Object) UnmarshalJSON(data []byte) error {
 	temp := struct {
-		BucketName      string            `json:"bucket"`
-		Name            string            `json:"name"`
-		ContentType     string            `json:"contentType"`
-		ContentEncoding string            `json:"contentEncoding"`
-		Content         []byte            `json:"-"`
-		Crc32c          string            `json:"crc32c,omitempty"`
-		Md5Hash         string            `json:"md5Hash,omitempty"`
-		ACL             []aclRule         `json:"acl,omitempty"`
-		Created         time.Time         `json:"created,omitempty"`
-		Updated         time.Time         `json:"updated,omitempty"`
-		Deleted         time.Time         `json:"deleted,omitempty"`
-		Generation      int64             `json:"generation,omitempty,string"`
-		Metadata        map[string]string `json:"metadata,omitempty"`
+		BucketName         string            `json:"bucket"`
+		Name               string            `json:"name"`
+		ContentType        string            `json:"contentType"`
+		ContentEncoding    string            `json:"contentEncoding"`
+		ContentDisposition string            `json:"contentDisposition,omitempty"`
+		ContentLanguage    string            `json:"contentLanguage,omitempty"`
+		CacheControl       string            `json:"cacheControl,omitempty"`
+		StorageClass       string            `json:"storageClass,omitempty"`
+		Content            []byte            `json:"-"`
+		Size               int64             `json:"size,string"`
+		Crc32c             string            `json:"crc32c,omitempty"`
+		Md5Hash            string            `json:"md5Hash,omitempty"`
+		ACL                []aclRule         `json:"acl,omitempty"`
+		Created            time.Time         `json:"created,omitempty"`
+		Updated            time.Time         `json:"updated,omitempty"`
+		Deleted            time.Time         `json:"deleted,omitempty"`
+		Generation         int64             `json:"generation,omitempty,string"`
+		Metageneration     int64             `json:"metageneration,omitempty,string"`
+		Metadata           map[string]string `json:"metadata,omitempty"`
+		ComponentCount     int64             `json:"componentCount,omitempty"`
+		KmsKeyName         string            `json:"kmsKeyName,omitempty"`
+		CustomTime         *time.Time        `json:"customTime,omitempty"`
+		TemporaryHold      bool              `json:"temporaryHold,omitempty"`
+		EventBasedHold     bool              `json:"eventBasedHold,omitempty"`
+		RetentionExpiry    *time.Time        `json:"retentionExpirationTime,omitempty"`
 	}{}
 	if err := json.Unmarshal(data, &temp); err != nil {
 		return err
@@ -104,14 +174,30 @@ func (o *Object) UnmarshalJSON(data []byte) error {
 	o.Name = temp.Name
 	o.ContentType = temp.ContentType
 	o.ContentEncoding = temp.ContentEncoding
+	o.ContentDisposition = temp.ContentDisposition
+	o.ContentLanguage = temp.ContentLanguage
+	o.CacheControl = temp.CacheControl
+	o.StorageClass = temp.StorageClass
 	o.Content = temp.Content
+	o.Size = temp.Size
 	o.Crc32c = temp.Crc32c
 	o.Md5Hash = temp.Md5Hash
 	o.Created = temp.Created
 	o.Updated = temp.Updated
 	o.Deleted = temp.Deleted
 	o.Generation = temp.Generation
+	o.Metageneration = temp.Metageneration
 	o.Metadata = temp.Metadata
+	o.ComponentCount = temp.ComponentCount
+	o.KmsKeyName = temp.KmsKeyName
+	o.TemporaryHold = temp.TemporaryHold
+	o.EventBasedHold = temp.EventBasedHold
+	if temp.RetentionExpiry != nil {
+		o.RetentionExpirationTime = *temp.RetentionExpiry
+	}
+	if temp.CustomTime != nil {
+		o.CustomTime = *temp.CustomTime
+	}
 	o.ACL = make([]storage.ACLRule, len(temp.ACL))
 	for i, ACL := range temp.ACL {
 		o.ACL[i] = storage.ACLRule(ACL)
@@ -197,21 +283,6 @@ func (o *Object) id() string {
 	return o.BucketName + "/" + o.Name
 }
 
-type objectList []Object
-
-func (o objectList) Len() int {
-	return len(o)
-}
-
-func (o objectList) Less(i int, j int) bool {
-	return o[i].Name < o[j].Name
-}
-
-func (o *objectList) Swap(i int, j int) {
-	d := *o
-	d[i], d[j] = d[j], d[i]
-}
-
 // CreateObject stores the given object internally.
 //
 // If the bucket within the object doesn't exist, it also creates it. If the
@@ -223,13 +294,43 @@ func (s *Server) CreateObject(obj Object) {
 	}
 }
 
+// createObject stores the given object as is, replacing the existing one
+// even if it's retained, so tests can set up objects freely.
 func (s *Server) createObject(obj Object) (Object, error) {
-	newObj, err := s.backend.CreateObject(toBackendObjects([]Object{obj})[0])
+	return s.storeObject(obj, bytes.NewReader(obj.Content))
+}
+
+// createObjectFromReader stores the given object, streaming its content from
+// the given reader instead of obj.Content. New objects are placed under an
+// event-based hold if that's the default of their bucket. It fails with
+// errObjectRetained when replacing an object that is under a hold or
+// retention period.
+func (s *Server) createObjectFromReader(obj Object, content io.Reader) (Object, error) {
+	if err := s.checkObjectRetention(obj.BucketName, obj.Name, 0); err != nil {
+		return Object{}, err
+	}
+	if bucket, err := s.backend.GetBucket(obj.BucketName); err == nil && bucket.DefaultEventBasedHold {
+		obj.EventBasedHold = true
+	}
+	return s.storeObject(obj, content)
+}
+
+// storeObject stores the given object. Objects created without a storage
+// class get the default storage class of their bucket.
+func (s *Server) storeObject(obj Object, content io.Reader) (Object, error) {
+	bucket, _ := s.backend.GetBucket(obj.BucketName)
+	if obj.StorageClass == "" {
+		obj.StorageClass = getStorageClassIfEmpty(bucket.StorageClass)
+	}
+	obj = obj.withTimesIfZero(s.clock.Now())
+	newObj, err := s.backend.CreateObject(toBackendObjects([]Object{obj})[0].ObjectAttrs, content)
 	if err != nil {
 		return Object{}, err
 	}
 
-	return fromBackendObjects([]backend.Object{newObj})[0], nil
+	created := fromBackendObjectsAttrs([]backend.ObjectAttrs{newObj})[0]
+	created.RetentionExpirationTime = retentionExpirationTime(bucket, created.Created)
+	return created, nil
 }
 
 type ListOptions struct {
@@ -241,7 +342,8 @@ type ListOptions struct {
 }
 
 // ListObjects returns a sorted list of objects that match the given criteria,
-// or an error if the bucket doesn't exist.
+// or an error if the bucket doesn't exist. The returned objects don't include
+// their content.
 //
 // Deprecated: use ListObjectsWithOptions.
 func (s *Server) ListObjects(bucketName, prefix, delimiter string, versions bool) ([]Object, []string, error) {
@@ -252,50 +354,55 @@ func (s *Server) ListObjects(bucketName, prefix, delimiter string, versions bool
 	})
 }
 
+// ListObjectsWithOptions returns a sorted list of objects that match the
+// given options, or an error if the bucket doesn't exist. The returned objects
+// don't include their content, use GetObject to retrieve it.
 func (s *Server) ListObjectsWithOptions(bucketName string, options ListOptions) ([]Object, []string, error) {
-	backendObjects, err := s.backend.ListObjects(bucketName, options.Versions)
+	backendObjects, prefixes, err := s.backend.ListObjects(bucketName, backend.ListOptions{
+		Prefix:      options.Prefix,
+		Delimiter:   options.Delimiter,
+		Versions:    options.Versions,
+		StartOffset: options.StartOffset,
+		EndOffset:   options.EndOffset,
+	})
 	if err != nil {
 		return nil, nil, err
 	}
-	objects := fromBackendObjects(backendObjects)
-	olist := objectList(objects)
-	sort.Sort(&olist)
-	var respObjects []Object
-	prefixes := make(map[string]bool)
-	for _, obj := range olist {
-		if strings.HasPrefix(obj.Name, options.Prefix) {
-			objName := strings.Replace(obj.Name, options.Prefix, "", 1)
-			delimPos := strings.Index(objName, options.Delimiter)
-			if options.Delimiter != "" && delimPos > -1 {
-				prefix := obj.Name[:len(options.Prefix)+delimPos+1]
-				if isInOffset(prefix, options.StartOffset, options.EndOffset) {
-					prefixes[prefix] = true
-				}
-			} else {
-				if isInOffset(obj.Name, options.StartOffset, options.EndOffset) {
-					respObjects = append(respObjects, obj)
-				}
-			}
+	objects := fromBackendObjectsAttrs(backendObjects)
+	if bucket, err := s.backend.GetBucket(bucketName); err == nil {
+		for i := range objects {
+			objects[i].RetentionExpirationTime = retentionExpirationTime(bucket, objects[i].Created)
 		}
 	}
-	respPrefixes := make([]string, 0, len(prefixes))
-	for p := range prefixes {
-		respPrefixes = append(respPrefixes, p)
+	return objects, prefixes, nil
+}
+
+// formatTimeIfNotZero formats the given time for the backend, or returns an
+// empty string if it's zero.
+func formatTimeIfNotZero(date time.Time) string {
+	if date.IsZero() {
+		return ""
 	}
-	sort.Strings(respPrefixes)
-	return respObjects, respPrefixes, nil
+	return date.Format(timestampFormat)
 }
 
-func isInOffset(name, startOffset, endOffset string) bool {
-	if endOffset != "" && startOffset != "" {
-		return strings.Compare(name, endOffset) < 0 && strings.Compare(name, startOffset) >= 0
-	} else if endOffset != "" {
-		return strings.Compare(name, endOffset) < 0
-	} else if startOffset != "" {
-		return strings.Compare(name, startOffset) >= 0
-	} else {
-		return true
+func getStorageClassIfEmpty(storageClass string) string {
+	if storageClass == "" {
+		return defaultStorageClass
+	}
+	return storageClass
+}
+
+// withTimesIfZero returns a copy of the object with the creation and update
+// times set to the given time if they're zero.
+func (o Object) withTimesIfZero(now time.Time) Object {
+	if o.Created.IsZero() {
+		o.Created = now
+	}
+	if o.Updated.IsZero() {
+		o.Updated = now
 	}
+	return o
 }
 
 func getCurrentIfZero(date time.Time) time.Time {
@@ -309,44 +416,79 @@ func toBackendObjects(objects []Object) []backend.Object {
 	backendObjects := []backend.Object{}
 	for _, o := range objects {
 		backendObjects = append(backendObjects, backend.Object{
-			BucketName:      o.BucketName,
-			Name:            o.Name,
-			Content:         o.Content,
-			ContentType:     o.ContentType,
-			ContentEncoding: o.ContentEncoding,
-			Crc32c:          o.Crc32c,
-			Md5Hash:         o.Md5Hash,
-			ACL:             o.ACL,
-			Created:         getCurrentIfZero(o.Created).Format(timestampFormat),
-			Deleted:         o.Deleted.Format(timestampFormat),
-			Updated:         getCurrentIfZero(o.Updated).Format(timestampFormat),
-			Generation:      o.Generation,
-			Metadata:        o.Metadata,
+			ObjectAttrs: backend.ObjectAttrs{
+				BucketName:         o.BucketName,
+				Name:               o.Name,
+				ContentType:        o.ContentType,
+				ContentEncoding:    o.ContentEncoding,
+				ContentDisposition: o.ContentDisposition,
+				ContentLanguage:    o.ContentLanguage,
+				CacheControl:       o.CacheControl,
+				StorageClass:       o.StorageClass,
+				Crc32c:             o.Crc32c,
+				Md5Hash:            o.Md5Hash,
+				ACL:                o.ACL,
+				Created:            getCurrentIfZero(o.Created).Format(timestampFormat),
+				Deleted:            o.Deleted.Format(timestampFormat),
+				Updated:            getCurrentIfZero(o.Updated).Format(timestampFormat),
+				Generation:         o.Generation,
+				Metageneration:     o.Metageneration,
+				Metadata:           o.Metadata,
+				ComponentCount:     o.ComponentCount,
+				KmsKeyName:         o.KmsKeyName,
+				CustomTime:         formatTimeIfNotZero(o.CustomTime),
+				TemporaryHold:      o.TemporaryHold,
+				EventBasedHold:     o.EventBasedHold,
+			},
+			Content: o.Content,
 		})
 	}
 	return backendObjects
 }
 
-func fromBackendObjects(objects []backend.Object) []Object {
-	backendObjects := []Object{}
-	for _, o := range objects {
-		backendObjects = append(backendObjects, Object{
-			BucketName:      o.BucketName,
-			Name:            o.Name,
-			Content:         o.Content,
-			ContentType:     o.ContentType,
-			ContentEncoding: o.ContentEncoding,
-			Crc32c:          o.Crc32c,
-			Md5Hash:         o.Md5Hash,
-			ACL:             o.ACL,
-			Created:         convertTimeWithoutError(o.Created),
-			Deleted:         convertTimeWithoutError(o.Deleted),
-			Updated:         convertTimeWithoutError(o.Updated),
-			Generation:      o.Generation,
-			Metadata:        o.Metadata,
+// fromBackendStreamingObject reads the whole content of the given object,
+// closing it.
+func fromBackendStreamingObject(o backend.StreamingObject) (Object, error) {
+	defer o.Content.Close()
+	content, err := ioutil.ReadAll(o.Content)
+	if err != nil {
+		return Object{}, err
+	}
+	obj := fromBackendObjectsAttrs([]backend.ObjectAttrs{o.ObjectAttrs})[0]
+	obj.Content = content
+	return obj, nil
+}
+
+func fromBackendObjectsAttrs(objectAttrs []backend.ObjectAttrs) []Object {
+	objects := []Object{}
+	for _, o := range objectAttrs {
+		objects = append(objects, Object{
+			BucketName:         o.BucketName,
+			Name:               o.Name,
+			Size:               o.Size,
+			ContentType:        o.ContentType,
+			ContentEncoding:    o.ContentEncoding,
+			ContentDisposition: o.ContentDisposition,
+			ContentLanguage:    o.ContentLanguage,
+			CacheControl:       o.CacheControl,
+			StorageClass:       o.StorageClass,
+			Crc32c:             o.Crc32c,
+			Md5Hash:            o.Md5Hash,
+			ACL:                o.ACL,
+			Created:            convertTimeWithoutError(o.Created),
+			Deleted:            convertTimeWithoutError(o.Deleted),
+			Updated:            convertTimeWithoutError(o.Updated),
+			Generation:         o.Generation,
+			Metageneration:     o.Metageneration,
+			Metadata:           o.Metadata,
+			ComponentCount:     o.ComponentCount,
+			KmsKeyName:         o.KmsKeyName,
+			CustomTime:         convertTimeWithoutError(o.CustomTime),
+			TemporaryHold:      o.TemporaryHold,
+			EventBasedHold:     o.EventBasedHold,
 		})
 	}
-	return backendObjects
+	return objects
 }
 
 func convertTimeWithoutError(t string) time.Time {
@@ -361,8 +503,7 @@ func (s *Server) GetObject(bucketName, objectName string) (Object, error) {
 	if err != nil {
 		return Object{}, err
 	}
-	obj := fromBackendObjects([]backend.Object{backendObj})[0]
-	return obj, nil
+	return fromBackendStreamingObject(backendObj)
 }
 
 // GetObjectWithGeneration returns the object with the given name and given
@@ -374,34 +515,81 @@ func (s *Server) GetObjectWithGeneration(bucketName, objectName string, generati
 	if err != nil {
 		return Object{}, err
 	}
-	obj := fromBackendObjects([]backend.Object{backendObj})[0]
-	return obj, nil
+	return fromBackendStreamingObject(backendObj)
 }
 
-func (s *Server) objectWithGenerationOnValidGeneration(bucketName, objectName, generationStr string) (Object, error) {
+// objectWithGenerationOnValidGeneration returns the object with its content
+// still to be streamed, which must be closed by the caller.
+func (s *Server) objectWithGenerationOnValidGeneration(bucketName, objectName, generationStr string) (backend.StreamingObject, error) {
 	generation, err := strconv.ParseInt(generationStr, 10, 64)
 	if err != nil && generationStr != "" {
-		return Object{}, errInvalidGeneration
+		return backend.StreamingObject{}, errInvalidGeneration
 	} else if generation > 0 {
-		return s.GetObjectWithGeneration(bucketName, objectName, generation)
+		return s.backend.GetObjectWithGeneration(bucketName, objectName, generation)
 	}
-	return s.GetObject(bucketName, objectName)
+	return s.backend.GetObject(bucketName, objectName)
 }
 
 func (s *Server) listObjects(r *http.Request) jsonResponse {
 	bucketName := mux.Vars(r)["bucketName"]
-	objs, prefixes, err := s.ListObjectsWithOptions(bucketName, ListOptions{
+	page, err := parsePageParams(r)
+	if err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	options := ListOptions{
 		Prefix:      r.URL.Query().Get("prefix"),
 		Delimiter:   r.URL.Query().Get("delimiter"),
 		Versions:    r.URL.Query().Get("versions") == "true",
 		StartOffset: r.URL.Query().Get("startOffset"),
 		EndOffset:   r.URL.Query().Get("endOffset"),
-	})
+	}
+	if page.token != nil && page.token.Name > options.StartOffset {
+		options.StartOffset = page.token.Name
+	}
+	objs, prefixes, err := s.ListObjectsWithOptions(bucketName, options)
 
 	if err != nil {
 		return jsonResponse{status: http.StatusNotFound}
 	}
-	return jsonResponse{data: newListObjectsResponse(objs, prefixes)}
+	objs, prefixes, nextPageToken := paginateObjects(objs, prefixes, page)
+	resp := newListObjectsResponse(objs, prefixes)
+	resp.NextPageToken = nextPageToken
+	return jsonResponse{data: resp}
+}
+
+// paginateObjects merges objects and prefixes in lexicographical order, like
+// the real API does, and returns the ones that belong to the requested page,
+// along with the token for the next page, if any.
+func paginateObjects(objs []Object, prefixes []string, page pageParams) ([]Object, []string, string) {
+	var pageObjs []Object
+	var pagePrefixes []string
+	var last pageToken
+	for i, j := 0, 0; i < len(objs) || j < len(prefixes); {
+		var item pageToken
+		isObject := j >= len(prefixes) || (i < len(objs) && objs[i].Name < prefixes[j])
+		if isObject {
+			item = pageToken{Name: objs[i].Name, Generation: objs[i].Generation}
+		} else {
+			item = pageToken{Name: prefixes[j], Prefix: true}
+		}
+		if page.token.precedes(item) {
+			if page.full(len(pageObjs) + len(pagePrefixes)) {
+				return pageObjs, pagePrefixes, last.encode()
+			}
+			if isObject {
+				pageObjs = append(pageObjs, objs[i])
+			} else {
+				pagePrefixes = append(pagePrefixes, prefixes[j])
+			}
+			last = item
+		}
+		if isObject {
+			i++
+		} else {
+			j++
+		}
+	}
+	return pageObjs, pagePrefixes, ""
 }
 
 func (s *Server) getObject(w http.ResponseWriter, r *http.Request) {
@@ -413,6 +601,10 @@ func (s *Server) getObject(w http.ResponseWriter, r *http.Request) {
 	handler := jsonToHTTPHandler(func(r *http.Request) jsonResponse {
 		vars := mux.Vars(r)
 
+		conds, err := parseObjectConditions(r)
+		if err != nil {
+			return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+		}
 		obj, err := s.objectWithGenerationOnValidGeneration(vars["bucketName"], vars["objectName"], r.FormValue("generation"))
 		if err != nil {
 			statusCode := http.StatusNotFound
@@ -426,11 +618,21 @@ func (s *Server) getObject(w http.ResponseWriter, r *http.Request) {
 				errorMessage: errMessage,
 			}
 		}
+		obj.Content.Close()
 		header := make(http.Header)
+		header.Set("ETag", `"`+objectEtag(obj.Generation, obj.Metageneration)+`"`)
+		status := conds.evaluate(&obj.ObjectAttrs, true)
+		if status == 0 {
+			status = evaluateHeaders(r, obj.ObjectAttrs)
+		}
+		if resp := conditionsResponse(status); resp != nil {
+			resp.header = header
+			return *resp
+		}
 		header.Set("Accept-Ranges", "bytes")
 		return jsonResponse{
 			header: header,
-			data:   newObjectResponse(obj),
+			data:   newObjectResponse(s.withRetentionExpirationTime(fromBackendObjectsAttrs([]backend.ObjectAttrs{obj.ObjectAttrs})[0])),
 		}
 	})
 
@@ -439,7 +641,28 @@ func (s *Server) getObject(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) deleteObject(r *http.Request) jsonResponse {
 	vars := mux.Vars(r)
-	err := s.backend.DeleteObject(vars["bucketName"], vars["objectName"])
+	conds, err := parseObjectConditions(r)
+	if err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	var generation int64
+	if generationStr := r.FormValue("generation"); generationStr != "" {
+		generation, err = strconv.ParseInt(generationStr, 10, 64)
+		if err != nil {
+			return jsonResponse{status: http.StatusBadRequest, errorMessage: errInvalidGeneration.Error()}
+		}
+	}
+	if resp := s.checkExistingObjectConditions(conds, vars["bucketName"], vars["objectName"], generation); resp != nil {
+		return *resp
+	}
+	if err := s.checkObjectRetention(vars["bucketName"], vars["objectName"], generation); err != nil {
+		return jsonResponse{status: http.StatusForbidden, errorMessage: err.Error()}
+	}
+	if generation != 0 {
+		err = s.backend.DeleteObjectWithGeneration(vars["bucketName"], vars["objectName"], generation)
+	} else {
+		err = s.backend.DeleteObject(vars["bucketName"], vars["objectName"])
+	}
 
 	if err != nil {
 		return jsonResponse{status: http.StatusNotFound}
@@ -461,11 +684,6 @@ func (s *Server) listObjectACL(r *http.Request) jsonResponse {
 func (s *Server) setObjectACL(r *http.Request) jsonResponse {
 	vars := mux.Vars(r)
 
-	obj, err := s.GetObject(vars["bucketName"], vars["objectName"])
-	if err != nil {
-		return jsonResponse{status: http.StatusNotFound}
-	}
-
 	var data struct {
 		Entity string
 		Role   string
@@ -481,18 +699,82 @@ func (s *Server) setObjectACL(r *http.Request) jsonResponse {
 
 	entity := storage.ACLEntity(data.Entity)
 	role := storage.ACLRole(data.Role)
-	obj.ACL = []storage.ACLRule{{
+	patch := backend.ObjectPatch{ACL: []storage.ACLRule{{
 		Entity: entity,
 		Role:   role,
-	}}
+	}}}
+	attrs, err := s.backend.PatchObject(vars["bucketName"], vars["objectName"], patch)
+	if err != nil {
+		return jsonResponse{status: http.StatusNotFound}
+	}
 
-	s.CreateObject(obj)
+	return jsonResponse{data: newACLListResponse(fromBackendObjectsAttrs([]backend.ObjectAttrs{attrs})[0])}
+}
 
-	return jsonResponse{data: newACLListResponse(obj)}
+// copyObject handles copyTo requests, which copy the object in a single call
+// and respond with the new object.
+func (s *Server) copyObject(r *http.Request) jsonResponse {
+	source, resp := s.openCopySource(r)
+	if resp != nil {
+		return *resp
+	}
+	defer source.Content.Close()
+	newObject, resp := s.writeCopyDestination(r, source)
+	if resp != nil {
+		return *resp
+	}
+	return jsonResponse{data: newObjectResponse(newObject)}
 }
 
+// rewriteObject handles rewriteTo requests, which may take multiple calls to
+// complete, as limited by maxBytesRewrittenPerCall.
 func (s *Server) rewriteObject(r *http.Request) jsonResponse {
+	rewrite, err := parseRewriteParams(r)
+	if err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	source, resp := s.openCopySource(r)
+	if resp != nil {
+		return *resp
+	}
+	defer source.Content.Close()
 	vars := mux.Vars(r)
+	progress, err := rewrite.progress(source.ObjectAttrs, vars["destinationBucket"], vars["destinationObject"])
+	if errors.Is(err, errSourceChanged) {
+		return jsonResponse{status: http.StatusPreconditionFailed, errorMessage: err.Error()}
+	}
+	if err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	if token := rewrite.next(source.ObjectAttrs, vars["destinationBucket"], vars["destinationObject"], progress); token != nil {
+		return jsonResponse{data: newPartialRewriteResponse(token.BytesRewritten, source.Size, token.encode())}
+	}
+	newObject, resp := s.writeCopyDestination(r, source)
+	if resp != nil {
+		return *resp
+	}
+	return jsonResponse{data: newObjectRewriteResponse(newObject)}
+}
+
+// openCopySource validates the parameters shared by copyTo and rewriteTo
+// requests, and opens the source object after checking the preconditions on
+// both the source and the destination.
+func (s *Server) openCopySource(r *http.Request) (backend.StreamingObject, *jsonResponse) {
+	vars := mux.Vars(r)
+	sourceConds, err := parseSourceObjectConditions(r)
+	if err != nil {
+		return backend.StreamingObject{}, &jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	conds, err := parseObjectConditions(r)
+	if err != nil {
+		return backend.StreamingObject{}, &jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	if acl := r.URL.Query().Get("destinationPredefinedAcl"); acl != "" && !isValidPredefinedACL(acl) {
+		return backend.StreamingObject{}, &jsonResponse{status: http.StatusBadRequest, errorMessage: "invalid destinationPredefinedAcl"}
+	}
+	if _, err := s.backend.GetBucket(vars["destinationBucket"]); err != nil {
+		return backend.StreamingObject{}, &jsonResponse{status: http.StatusNotFound, errorMessage: "destination bucket not found"}
+	}
 	obj, err := s.objectWithGenerationOnValidGeneration(vars["sourceBucket"], vars["sourceObject"], r.FormValue("sourceGeneration"))
 	if err != nil {
 		statusCode := http.StatusNotFound
@@ -501,41 +783,89 @@ func (s *Server) rewriteObject(r *http.Request) jsonResponse {
 			statusCode = http.StatusBadRequest
 			errMessage = err.Error()
 		}
-		return jsonResponse{errorMessage: errMessage, status: statusCode}
+		return backend.StreamingObject{}, &jsonResponse{errorMessage: errMessage, status: statusCode}
 	}
+	resp := conditionsResponse(sourceConds.evaluate(&obj.ObjectAttrs, false))
+	if resp == nil {
+		resp = s.checkObjectConditions(conds, vars["destinationBucket"], vars["destinationObject"], 0)
+	}
+	if resp == nil {
+		// fail before rewriting anything if the destination can't be replaced
+		if err := s.checkObjectRetention(vars["destinationBucket"], vars["destinationObject"], 0); err != nil {
+			resp = &jsonResponse{status: http.StatusForbidden, errorMessage: err.Error()}
+		}
+	}
+	if resp != nil {
+		obj.Content.Close()
+		return backend.StreamingObject{}, resp
+	}
+	return obj, nil
+}
 
+// writeCopyDestination creates the destination of a copyTo or rewriteTo
+// request from the source object. The source is always copied as a new
+// generation of the destination, which becomes the live version, even when
+// copying an older generation of the source in a versioned bucket.
+func (s *Server) writeCopyDestination(r *http.Request, source backend.StreamingObject) (Object, *jsonResponse) {
+	vars := mux.Vars(r)
 	var metadata multipartMetadata
-	err = json.NewDecoder(r.Body).Decode(&metadata)
+	err := json.NewDecoder(r.Body).Decode(&metadata)
 	if err != nil && err != io.EOF { // The body is optional
-		return jsonResponse{errorMessage: "Invalid metadata", status: http.StatusBadRequest}
+		return Object{}, &jsonResponse{errorMessage: "Invalid metadata", status: http.StatusBadRequest}
+	}
+	if err := metadata.validate(); err != nil {
+		return Object{}, &jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
 	}
 
 	// Only supplied metadata overwrites the new object's metdata
 	if len(metadata.Metadata) == 0 {
-		metadata.Metadata = obj.Metadata
+		metadata.Metadata = source.Metadata
 	}
 	if metadata.ContentType == "" {
-		metadata.ContentType = obj.ContentType
+		metadata.ContentType = source.ContentType
 	}
 	if metadata.ContentEncoding == "" {
-		metadata.ContentEncoding = obj.ContentEncoding
+		metadata.ContentEncoding = source.ContentEncoding
+	}
+	if metadata.ContentDisposition == "" {
+		metadata.ContentDisposition = source.ContentDisposition
+	}
+	if metadata.ContentLanguage == "" {
+		metadata.ContentLanguage = source.ContentLanguage
+	}
+	if metadata.CacheControl == "" {
+		metadata.CacheControl = source.CacheControl
+	}
+	if metadata.CustomTime.IsZero() {
+		metadata.CustomTime = convertTimeWithoutError(source.CustomTime)
 	}
 
-	dstBucket := vars["destinationBucket"]
 	newObject := Object{
-		BucketName:      dstBucket,
-		Name:            vars["destinationObject"],
-		Content:         append([]byte(nil), obj.Content...),
-		Crc32c:          obj.Crc32c,
-		Md5Hash:         obj.Md5Hash,
-		ACL:             obj.ACL,
-		ContentType:     metadata.ContentType,
-		ContentEncoding: metadata.ContentEncoding,
-		Metadata:        metadata.Metadata,
-	}
-
-	s.CreateObject(newObject)
-	return jsonResponse{data: newObjectRewriteResponse(newObject)}
+		BucketName:         vars["destinationBucket"],
+		Name:               vars["destinationObject"],
+		Crc32c:             source.Crc32c,
+		Md5Hash:            source.Md5Hash,
+		ACL:                getObjectACL(r.URL.Query().Get("destinationPredefinedAcl")),
+		ContentType:        metadata.ContentType,
+		ContentEncoding:    metadata.ContentEncoding,
+		ContentDisposition: metadata.ContentDisposition,
+		ContentLanguage:    metadata.ContentLanguage,
+		CacheControl:       metadata.CacheControl,
+		StorageClass:       metadata.StorageClass,
+		CustomTime:         metadata.CustomTime,
+		TemporaryHold:      metadata.TemporaryHold,
+		EventBasedHold:     metadata.EventBasedHold,
+		Metadata:           metadata.Metadata,
+		ComponentCount:     source.ComponentCount,
+		KmsKeyName:         r.URL.Query().Get("destinationKmsKeyName"),
+	}
+
+	newObject, err = s.createObjectFromReader(newObject, source.Content)
+	if err != nil {
+		resp := uploadErrorResponse(err)
+		return Object{}, &resp
+	}
+	return newObject, nil
 }
 
 func (s *Server) downloadObject(w http.ResponseWriter, r *http.Request) {
@@ -551,70 +881,128 @@ func (s *Server) downloadObject(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, message, statusCode)
 		return
 	}
+	defer obj.Content.Close()
+	conds, err := parseObjectConditions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("ETag", `"`+objectEtag(obj.Generation, obj.Metageneration)+`"`)
+	status := conds.evaluate(&obj.ObjectAttrs, true)
+	if status == 0 {
+		status = evaluateHeaders(r, obj.ObjectAttrs)
+	}
+	switch status {
+	case 0:
+	case http.StatusNotModified:
+		w.WriteHeader(status)
+		return
+	default:
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
 
-	status := http.StatusOK
-	ranged, start, end, content := s.handleRange(obj, r)
-	if ranged {
-		status = http.StatusPartialContent
-		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(obj.Content)))
+	w.Header().Set("X-Goog-Generation", strconv.FormatInt(obj.Generation, 10))
+	w.Header().Set("X-Goog-Stored-Content-Encoding", storedContentEncoding(obj.ContentEncoding))
+	w.Header().Set("X-Goog-Stored-Content-Length", strconv.FormatInt(obj.Size, 10))
+	w.Header().Set("X-Goog-Metageneration", strconv.FormatInt(obj.Metageneration, 10))
+	setHashHeader(w.Header(), obj.ObjectAttrs)
+	setMetadataHeaders(w.Header(), obj.ObjectAttrs)
+	w.Header().Set("Last-Modified", convertTimeWithoutError(obj.Updated).Format(http.TimeFormat))
+	if shouldTranscode(r, obj.ObjectAttrs) && s.writeTranscoded(w, r, obj) {
+		return
+	}
+
+	ranges, err := parseRange(r.Header.Get("Range"), obj.Size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", obj.Size))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
 	}
 	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
-	w.Header().Set(contentTypeHeader, obj.ContentType)
-	w.Header().Set("X-Goog-Generation", strconv.FormatInt(obj.Generation, 10))
-	w.Header().Set("Last-Modified", obj.Updated.Format(http.TimeFormat))
 	if obj.ContentEncoding != "" {
 		w.Header().Set("Content-Encoding", obj.ContentEncoding)
 	}
+	if len(ranges) > 1 {
+		s.writeMultipartRanges(w, r, obj, ranges)
+		return
+	}
+
+	status = http.StatusOK
+	content := byteRange{start: 0, end: obj.Size}
+	if len(ranges) == 1 {
+		status = http.StatusPartialContent
+		content = ranges[0]
+		w.Header().Set("Content-Range", content.contentRange(obj.Size))
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(content.length(), 10))
+	w.Header().Set(contentTypeHeader, obj.ContentType)
 	w.WriteHeader(status)
 	if r.Method == http.MethodGet {
-		w.Write(content)
-	}
-}
-
-func (s *Server) handleRange(obj Object, r *http.Request) (ranged bool, start int64, end int64, content []byte) {
-	if reqRange := r.Header.Get("Range"); reqRange != "" {
-		parts := strings.SplitN(reqRange, "=", 2)
-		if len(parts) == 2 && parts[0] == "bytes" {
-			rangeParts := strings.SplitN(parts[1], "-", 2)
-			if len(rangeParts) == 2 {
-				start, _ = strconv.ParseInt(rangeParts[0], 10, 64)
-				var err error
-				if end, err = strconv.ParseInt(rangeParts[1], 10, 64); err != nil {
-					end = int64(len(obj.Content))
-				} else if end != math.MaxInt64 {
-					end++
-				}
-				if end > int64(len(obj.Content)) {
-					end = int64(len(obj.Content))
-				}
-				return true, start, end, obj.Content[start:end]
-			}
+		if _, err := obj.Content.Seek(content.start, io.SeekStart); err == nil {
+			io.CopyN(w, obj.Content, content.length())
 		}
 	}
-	return false, 0, 0, obj.Content
 }
 
-func (s *Server) patchObject(r *http.Request) jsonResponse {
-	vars := mux.Vars(r)
-	bucketName := vars["bucketName"]
-	objectName := vars["objectName"]
-	var metadata struct {
-		Metadata map[string]string `json:"metadata"`
+// setHashHeader sets the X-Goog-Hash header with the checksums of the whole
+// object, which GCS sends even when only a range of the object is returned.
+func setHashHeader(header http.Header, attrs backend.ObjectAttrs) {
+	if attrs.Crc32c != "" {
+		header.Add("X-Goog-Hash", "crc32c="+attrs.Crc32c)
 	}
-	err := json.NewDecoder(r.Body).Decode(&metadata)
-	if err != nil {
-		return jsonResponse{
-			status:       http.StatusBadRequest,
-			errorMessage: "Metadata in the request couldn't decode",
-		}
+	if attrs.Md5Hash != "" {
+		header.Add("X-Goog-Hash", "md5="+attrs.Md5Hash)
 	}
-	backendObj, err := s.backend.PatchObject(bucketName, objectName, metadata.Metadata)
-	if err != nil {
-		return jsonResponse{
-			status:       http.StatusNotFound,
-			errorMessage: "Object not found to be PATCHed",
+}
+
+// setMetadataHeaders sets the headers that reflect the metadata of the object
+// in downloads, including its custom metadata as x-goog-meta-* headers, like
+// the XML API does.
+func setMetadataHeaders(header http.Header, attrs backend.ObjectAttrs) {
+	header.Set("X-Goog-Storage-Class", getStorageClassIfEmpty(attrs.StorageClass))
+	if attrs.CacheControl != "" {
+		header.Set("Cache-Control", attrs.CacheControl)
+	}
+	if attrs.ContentDisposition != "" {
+		header.Set("Content-Disposition", attrs.ContentDisposition)
+	}
+	if attrs.ContentLanguage != "" {
+		header.Set("Content-Language", attrs.ContentLanguage)
+	}
+	if attrs.CustomTime != "" {
+		header.Set("X-Goog-Custom-Time", convertTimeWithoutError(attrs.CustomTime).Format(time.RFC3339))
+	}
+	for key, value := range attrs.Metadata {
+		header.Set("X-Goog-Meta-"+key, value)
+	}
+}
+
+// writeMultipartRanges responds to a request for multiple ranges of an object
+// with a multipart/byteranges body, with one part for each range.
+func (s *Server) writeMultipartRanges(w http.ResponseWriter, r *http.Request, obj backend.StreamingObject, ranges []byteRange) {
+	mw := multipart.NewWriter(w)
+	w.Header().Set(contentTypeHeader, "multipart/byteranges; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusPartialContent)
+	if r.Method != http.MethodGet {
+		return
+	}
+	for _, rng := range ranges {
+		header := make(textproto.MIMEHeader)
+		if obj.ContentType != "" {
+			header.Set(contentTypeHeader, obj.ContentType)
+		}
+		header.Set("Content-Range", rng.contentRange(obj.Size))
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return
+		}
+		if _, err := obj.Content.Seek(rng.start, io.SeekStart); err != nil {
+			return
+		}
+		if _, err := io.CopyN(part, obj.Content, rng.length()); err != nil {
+			return
 		}
 	}
-	return jsonResponse{data: fromBackendObjects([]backend.Object{backendObj})[0]}
+	mw.Close()
 }
@@ -14,6 +14,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 
@@ -702,6 +703,102 @@ func TestServiceClientListObjects(t *testing.T) {
 	})
 }
 
+func TestServiceClientListObjectsPaginated(t *testing.T) {
+	runServersTest(t, getObjectsForListTests(), func(t *testing.T, server *Server) {
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: "empty-bucket"})
+		tests := getTestCasesForListTests(false, false)
+		client := server.Client()
+		for _, test := range tests {
+			test := test
+			t.Run(test.testCase, func(t *testing.T) {
+				iter := client.Bucket(test.bucketName).Objects(context.TODO(), test.query)
+				pager := iterator.NewPager(iter, 2, "")
+				var prefixes []string
+				names := []string{}
+				for {
+					var page []*storage.ObjectAttrs
+					nextPageToken, err := pager.NextPage(&page)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(page) > 2 {
+						t.Errorf("wrong page size\nwant at most 2\ngot  %d", len(page))
+					}
+					for _, obj := range page {
+						if obj.Name != "" {
+							names = append(names, obj.Name)
+						}
+						if obj.Prefix != "" {
+							prefixes = append(prefixes, obj.Prefix)
+						}
+					}
+					if nextPageToken == "" {
+						break
+					}
+				}
+				if !reflect.DeepEqual(names, test.expectedNames) {
+					t.Errorf("wrong names returned\nwant %#v\ngot  %#v", test.expectedNames, names)
+				}
+				if !reflect.DeepEqual(prefixes, test.expectedPrefixes) {
+					t.Errorf("wrong prefixes returned\nwant %#v\ngot  %#v", test.expectedPrefixes, prefixes)
+				}
+			})
+		}
+	})
+}
+
+func TestServiceClientListObjectsPaginatedVersions(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		const bucketName = "some-bucket"
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: bucketName, VersioningEnabled: true})
+		for _, name := range []string{"a", "b", "b", "b", "c"} {
+			server.CreateObject(Object{BucketName: bucketName, Name: name, Content: []byte(name)})
+		}
+		client := server.Client()
+		iter := client.Bucket(bucketName).Objects(context.TODO(), &storage.Query{Versions: true})
+		pager := iterator.NewPager(iter, 2, "")
+		var names []string
+		var generations []int64
+		for {
+			var page []*storage.ObjectAttrs
+			nextPageToken, err := pager.NextPage(&page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, obj := range page {
+				names = append(names, obj.Name)
+				generations = append(generations, obj.Generation)
+			}
+			if nextPageToken == "" {
+				break
+			}
+		}
+		expectedNames := []string{"a", "b", "b", "b", "c"}
+		if !reflect.DeepEqual(names, expectedNames) {
+			t.Errorf("wrong names returned\nwant %#v\ngot  %#v", expectedNames, names)
+		}
+		if generations[1] > generations[2] || generations[2] > generations[3] {
+			t.Errorf("wrong generations returned: %v", generations)
+		}
+	})
+}
+
+func TestServerListObjectsInvalidPageToken(t *testing.T) {
+	server := NewServer([]Object{{BucketName: "some-bucket", Name: "some-object.txt"}})
+	defer server.Stop()
+	client := server.HTTPClient()
+	for _, query := range []string{"pageToken=not-a-token", "maxResults=-1", "maxResults=abc"} {
+		resp, err := client.Get(server.URL() + "/storage/v1/b/some-bucket/o?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("wrong status code for %s\nwant %d\ngot  %d", query, http.StatusBadRequest, resp.StatusCode)
+		}
+	}
+}
+
 func TestServerClientListAfterCreate(t *testing.T) {
 	for _, versioningEnabled := range []bool{true, false} {
 		for _, withOverwrites := range []bool{true, false} {
@@ -1220,6 +1317,35 @@ func TestServerClientObjectDeleteWithVersioning(t *testing.T) {
 	})
 }
 
+func TestServerClientObjectDeleteSpecificGeneration(t *testing.T) {
+	obj := Object{BucketName: "some-bucket", Name: "img/hi-res/party-01.jpg", Content: []byte("some nice content"), Generation: 123}
+	latest := Object{BucketName: obj.BucketName, Name: obj.Name, Content: []byte("some nicer content"), Generation: 456}
+
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: obj.BucketName, VersioningEnabled: true})
+		server.CreateObject(obj)
+		server.CreateObject(latest)
+
+		client := server.Client()
+		objHandle := client.Bucket(obj.BucketName).Object(obj.Name).Generation(obj.Generation)
+		err := objHandle.Delete(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		objWithGen, err := server.GetObjectWithGeneration(obj.BucketName, obj.Name, obj.Generation)
+		if err == nil {
+			t.Fatalf("unexpected success. obj: %#v", objWithGen)
+		}
+		activeObj, err := server.GetObject(obj.BucketName, obj.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if activeObj.Generation != latest.Generation {
+			t.Errorf("wrong generation\nwant %d\ngot  %d", latest.Generation, activeObj.Generation)
+		}
+	})
+}
+
 func TestServerClientObjectDeleteErrors(t *testing.T) {
 	objs := []Object{
 		{BucketName: "some-bucket", Name: "img/hi-res/party-01.jpg"},
@@ -1256,11 +1382,9 @@ func TestServerClientObjectDeleteErrors(t *testing.T) {
 }
 
 func TestServerClientObjectSetAclPrivate(t *testing.T) {
-	objs := []Object{
-		{BucketName: "some-bucket", Name: "img/public-to-private.jpg"},
-	}
-
-	runServersTest(t, objs, func(t *testing.T, server *Server) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: "some-bucket", VersioningEnabled: true})
+		server.CreateObject(Object{BucketName: "some-bucket", Name: "img/public-to-private.jpg"})
 		t.Run("public to private", func(t *testing.T) {
 			ctx := context.Background()
 			objHandle := server.Client().Bucket("some-bucket").Object("img/public-to-private.jpg")
@@ -1290,6 +1414,21 @@ func TestServerClientObjectSetAclPrivate(t *testing.T) {
 				t.Fatal("acl role not set to RoleReader")
 				return
 			}
+
+			attrs, err := objHandle.Attrs(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attrs.Metageneration != 2 {
+				t.Errorf("wrong metageneration after setting the acl\nwant 2\ngot  %d", attrs.Metageneration)
+			}
+			objs, _, err := server.ListObjectsWithOptions("some-bucket", ListOptions{Versions: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(objs) != 1 {
+				t.Errorf("wrong number of versions after setting the acl\nwant 1\ngot  %d", len(objs))
+			}
 		})
 	})
 }
@@ -1411,3 +1550,171 @@ func TestParseRangeRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestServerObjectDownloadHashHeaders(t *testing.T) {
+	const content = "some nice content"
+	server := NewServer([]Object{{BucketName: "some-bucket", Name: "some-object.txt", Content: []byte(content)}})
+	defer server.Stop()
+	expectedHashes := []string{
+		"crc32c=" + checksum.EncodedCrc32cChecksum([]byte(content)),
+		"md5=" + checksum.EncodedMd5Hash([]byte(content)),
+	}
+	for _, url := range []string{
+		server.URL() + "/download/storage/v1/b/some-bucket/o/some-object.txt?alt=media",
+		server.URL() + "/storage/v1/b/some-bucket/o/some-object.txt?alt=media",
+		server.PublicURL() + "/some-bucket/some-object.txt",
+	} {
+		for _, rng := range []string{"", "bytes=0-3"} {
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rng != "" {
+				req.Header.Set("Range", rng)
+			}
+			resp, err := server.HTTPClient().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if hashes := resp.Header["X-Goog-Hash"]; !reflect.DeepEqual(hashes, expectedHashes) {
+				t.Errorf("%s (range %q): wrong X-Goog-Hash\nwant %q\ngot  %q", url, rng, expectedHashes, hashes)
+			}
+			if length := resp.Header.Get("X-Goog-Stored-Content-Length"); length != strconv.Itoa(len(content)) {
+				t.Errorf("%s (range %q): wrong X-Goog-Stored-Content-Length\nwant %d\ngot  %q", url, rng, len(content), length)
+			}
+			if metageneration := resp.Header.Get("X-Goog-Metageneration"); metageneration != "1" {
+				t.Errorf("%s (range %q): wrong X-Goog-Metageneration\nwant %q\ngot  %q", url, rng, "1", metageneration)
+			}
+		}
+	}
+}
+
+func TestServerClientObjectReaderDetectsCorruption(t *testing.T) {
+	objs := []Object{{
+		BucketName: "some-bucket",
+		Name:       "some-object.txt",
+		Content:    []byte("some content"),
+		Crc32c:     checksum.EncodedCrc32cChecksum([]byte("other content")),
+	}}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		reader, err := server.Client().Bucket("some-bucket").Object("some-object.txt").NewReader(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		if _, err := ioutil.ReadAll(reader); err == nil {
+			t.Error("unexpected <nil> error reading an object with a mismatched checksum")
+		}
+	})
+}
+
+func TestServerClientObjectMetadataAttrs(t *testing.T) {
+	customTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: "some-bucket"})
+		client := server.Client()
+		obj := client.Bucket("some-bucket").Object("file.txt")
+		w := obj.NewWriter(context.TODO())
+		w.ContentType = "text/plain"
+		w.CacheControl = "public, max-age=60"
+		w.ContentDisposition = "attachment; filename=file.txt"
+		w.ContentLanguage = "en"
+		w.StorageClass = "NEARLINE"
+		w.CustomTime = customTime
+		w.TemporaryHold = true
+		w.Metadata = map[string]string{"owner": "someone"}
+		if _, err := w.Write([]byte("some content")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		attrs, err := obj.Attrs(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.CacheControl != "public, max-age=60" {
+			t.Errorf("wrong cache control\nwant %q\ngot  %q", "public, max-age=60", attrs.CacheControl)
+		}
+		if attrs.ContentDisposition != "attachment; filename=file.txt" {
+			t.Errorf("wrong content disposition\nwant %q\ngot  %q", "attachment; filename=file.txt", attrs.ContentDisposition)
+		}
+		if attrs.ContentLanguage != "en" {
+			t.Errorf("wrong content language\nwant %q\ngot  %q", "en", attrs.ContentLanguage)
+		}
+		if attrs.StorageClass != "NEARLINE" {
+			t.Errorf("wrong storage class\nwant %q\ngot  %q", "NEARLINE", attrs.StorageClass)
+		}
+		if !attrs.CustomTime.Equal(customTime) {
+			t.Errorf("wrong custom time\nwant %s\ngot  %s", customTime, attrs.CustomTime)
+		}
+		if !attrs.TemporaryHold {
+			t.Error("expected a temporary hold on the object")
+		}
+
+		resp, err := server.HTTPClient().Get(server.URL() + "/download/storage/v1/b/some-bucket/o/file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		expectedHeaders := map[string]string{
+			"Cache-Control":        "public, max-age=60",
+			"Content-Disposition":  "attachment; filename=file.txt",
+			"Content-Language":     "en",
+			"X-Goog-Storage-Class": "NEARLINE",
+			"X-Goog-Custom-Time":   "2021-03-04T05:06:07Z",
+			"X-Goog-Meta-Owner":    "someone",
+		}
+		for name, value := range expectedHeaders {
+			if got := resp.Header.Get(name); got != value {
+				t.Errorf("wrong %s header\nwant %q\ngot  %q", name, value, got)
+			}
+		}
+
+		attrs, err = obj.Update(context.TODO(), storage.ObjectAttrsToUpdate{
+			CacheControl:  "no-cache",
+			TemporaryHold: false,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.CacheControl != "no-cache" {
+			t.Errorf("wrong cache control after patch\nwant %q\ngot  %q", "no-cache", attrs.CacheControl)
+		}
+		if attrs.TemporaryHold {
+			t.Error("unexpected temporary hold after patch")
+		}
+		if attrs.ContentLanguage != "en" {
+			t.Errorf("wrong content language after patch\nwant %q\ngot  %q", "en", attrs.ContentLanguage)
+		}
+
+		copier := client.Bucket("some-bucket").Object("copy.txt").CopierFrom(obj)
+		copier.StorageClass = "COLDLINE"
+		attrs, err = copier.Run(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.StorageClass != "COLDLINE" {
+			t.Errorf("wrong storage class after rewrite\nwant %q\ngot  %q", "COLDLINE", attrs.StorageClass)
+		}
+		if attrs.CacheControl != "no-cache" {
+			t.Errorf("wrong cache control after rewrite\nwant %q\ngot  %q", "no-cache", attrs.CacheControl)
+		}
+	})
+}
+
+func TestServerClientObjectInvalidStorageClass(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: "some-bucket"})
+		w := server.Client().Bucket("some-bucket").Object("file.txt").NewWriter(context.TODO())
+		w.StorageClass = "FROZEN"
+		w.Write([]byte("some content"))
+		if err := w.Close(); !hasStatusCode(err, http.StatusBadRequest) {
+			t.Errorf("expected a bad request error, got %v", err)
+		}
+	})
+}
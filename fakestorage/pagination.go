@@ -0,0 +1,92 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+var (
+	errInvalidPageToken  = errors.New("invalid page token")
+	errInvalidMaxResults = errors.New("invalid maxResults")
+)
+
+// pageToken identifies the last item returned in a page of a listing, so the
+// next page can resume right after it, even if items are added or removed in
+// between.
+type pageToken struct {
+	// Name is the name of the last object, bucket or prefix returned.
+	Name string `json:"n"`
+
+	// Generation is the generation of the last object returned, used to
+	// resume versioned listings in the middle of the history of an object.
+	Generation int64 `json:"g,omitempty"`
+
+	// Prefix indicates whether the last item returned is a prefix.
+	Prefix bool `json:"p,omitempty"`
+}
+
+func (t pageToken) encode() string {
+	data, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageToken(value string) (*pageToken, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidPageToken
+	}
+	var token pageToken
+	err = json.Unmarshal(data, &token)
+	if err != nil || token.Name == "" {
+		return nil, errInvalidPageToken
+	}
+	return &token, nil
+}
+
+// precedes returns whether the position stored in the token comes before the
+// given item, in which case the item belongs to the requested page. A nil
+// token precedes every item.
+func (t *pageToken) precedes(item pageToken) bool {
+	if t == nil || item.Name > t.Name {
+		return true
+	}
+	if item.Name < t.Name || item.Prefix || t.Prefix {
+		return false
+	}
+	return item.Generation > t.Generation
+}
+
+// pageParams holds the pagination parameters of a listing request. A
+// maxResults of zero means that every remaining item is returned.
+type pageParams struct {
+	token      *pageToken
+	maxResults int
+}
+
+func parsePageParams(r *http.Request) (pageParams, error) {
+	var params pageParams
+	var err error
+	if value := r.URL.Query().Get("maxResults"); value != "" {
+		params.maxResults, err = strconv.Atoi(value)
+		if err != nil || params.maxResults < 0 {
+			return params, errInvalidMaxResults
+		}
+	}
+	params.token, err = decodePageToken(r.URL.Query().Get("pageToken"))
+	return params, err
+}
+
+// full returns whether a page with the given number of items is complete.
+func (p pageParams) full(items int) bool {
+	return p.maxResults > 0 && items >= p.maxResults
+}
@@ -0,0 +1,234 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsouza/fake-gcs-server/backend"
+)
+
+var errInvalidPrecondition = errors.New("invalid precondition value")
+
+// objectConditions are the preconditions on the generation and
+// metageneration of an object sent in the query string of a request.
+type objectConditions struct {
+	ifGenerationMatch        *int64
+	ifGenerationNotMatch     *int64
+	ifMetagenerationMatch    *int64
+	ifMetagenerationNotMatch *int64
+}
+
+// parseObjectConditions parses the preconditions that apply to the target
+// object of a request (ifGenerationMatch, ifMetagenerationMatch and their
+// "not match" counterparts).
+func parseObjectConditions(r *http.Request) (objectConditions, error) {
+	return parseConditions(r, "")
+}
+
+// parseSourceObjectConditions parses the preconditions that apply to the
+// source object of a rewrite (ifSourceGenerationMatch,
+// ifSourceMetagenerationMatch and their "not match" counterparts).
+func parseSourceObjectConditions(r *http.Request) (objectConditions, error) {
+	return parseConditions(r, "Source")
+}
+
+func parseConditions(r *http.Request, kind string) (objectConditions, error) {
+	var conds objectConditions
+	query := r.URL.Query()
+	for _, cond := range []struct {
+		name  string
+		value **int64
+	}{
+		{"GenerationMatch", &conds.ifGenerationMatch},
+		{"GenerationNotMatch", &conds.ifGenerationNotMatch},
+		{"MetagenerationMatch", &conds.ifMetagenerationMatch},
+		{"MetagenerationNotMatch", &conds.ifMetagenerationNotMatch},
+	} {
+		value, err := parseConditionValue(query.Get("if" + kind + cond.name))
+		if err != nil {
+			return conds, err
+		}
+		*cond.value = value
+	}
+	return conds, nil
+}
+
+func parseConditionValue(value string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", errInvalidPrecondition, value)
+	}
+	return &parsed, nil
+}
+
+func (c objectConditions) isEmpty() bool {
+	return c.ifGenerationMatch == nil && c.ifGenerationNotMatch == nil &&
+		c.ifMetagenerationMatch == nil && c.ifMetagenerationNotMatch == nil
+}
+
+// evaluate returns the status code of the response to send when the object
+// doesn't satisfy the conditions, or zero if it does. A nil object represents
+// an object that doesn't exist, which only matches an ifGenerationMatch of 0.
+//
+// Like GCS, reads report unsatisfied "not match" conditions with 304 Not
+// Modified, while every other failure is reported with 412 Precondition
+// Failed.
+func (c objectConditions) evaluate(attrs *backend.ObjectAttrs, read bool) int {
+	var generation, metageneration int64
+	if attrs != nil {
+		generation = attrs.Generation
+		metageneration = attrs.Metageneration
+	}
+	if c.ifGenerationMatch != nil && generation != *c.ifGenerationMatch {
+		return http.StatusPreconditionFailed
+	}
+	if c.ifMetagenerationMatch != nil && (attrs == nil || metageneration != *c.ifMetagenerationMatch) {
+		return http.StatusPreconditionFailed
+	}
+	notMatchStatus := http.StatusPreconditionFailed
+	if read {
+		notMatchStatus = http.StatusNotModified
+	}
+	if c.ifGenerationNotMatch != nil && generation == *c.ifGenerationNotMatch {
+		return notMatchStatus
+	}
+	if c.ifMetagenerationNotMatch != nil && attrs != nil && metageneration == *c.ifMetagenerationNotMatch {
+		return notMatchStatus
+	}
+	return 0
+}
+
+// objectEtag returns the entity tag of the given generation and
+// metageneration of an object, which changes whenever either of them changes.
+func objectEtag(generation, metageneration int64) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%d/%d", generation, metageneration)))
+}
+
+// evaluateBucket is like evaluate, for a bucket with the given
+// metageneration. Buckets don't have generations, so only the metageneration
+// conditions apply.
+func (c objectConditions) evaluateBucket(metageneration int64, read bool) int {
+	c.ifGenerationMatch = nil
+	c.ifGenerationNotMatch = nil
+	return c.evaluate(&backend.ObjectAttrs{Metageneration: metageneration}, read)
+}
+
+// bucketEtag returns the entity tag of the given metageneration of a bucket.
+func bucketEtag(metageneration int64) string {
+	return base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(metageneration, 10)))
+}
+
+// evaluateHeaders evaluates the HTTP conditional headers (If-Match,
+// If-None-Match, If-Unmodified-Since and If-Modified-Since) of a read request
+// against the object, following the precedence defined in RFC 7232. It
+// returns the status code of the response to send when the object doesn't
+// satisfy them, or zero if it does.
+func evaluateHeaders(r *http.Request, attrs backend.ObjectAttrs) int {
+	etag := objectEtag(attrs.Generation, attrs.Metageneration)
+	updated := convertTimeWithoutError(attrs.Updated).Truncate(time.Second)
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagListContains(ifMatch, etag) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := parseHTTPTime(r.Header.Get("If-Unmodified-Since")); ok && updated.After(since) {
+		return http.StatusPreconditionFailed
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagListContains(ifNoneMatch, etag) {
+			return http.StatusNotModified
+		}
+	} else if since, ok := parseHTTPTime(r.Header.Get("If-Modified-Since")); ok && !updated.After(since) {
+		return http.StatusNotModified
+	}
+	return 0
+}
+
+// etagListContains returns whether the given list of entity tags, as sent in
+// If-Match and If-None-Match headers, matches the given entity tag. Weak tags
+// are compared as strong ones, as GCS objects don't have weak tags.
+func etagListContains(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if strings.Trim(candidate, `"`) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHTTPTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(value)
+	return t, err == nil
+}
+
+// checkObjectConditions checks the conditions against the given generation
+// of the object (or its live version, if generation is zero), returning the
+// response to send if they aren't satisfied. It's used for the destination
+// of writes, which may not exist yet.
+func (s *Server) checkObjectConditions(conds objectConditions, bucketName, objectName string, generation int64) *jsonResponse {
+	if conds.isEmpty() {
+		return nil
+	}
+	attrs, _ := s.getObjectAttrs(bucketName, objectName, generation)
+	return conditionsResponse(conds.evaluate(attrs, false))
+}
+
+// checkExistingObjectConditions is like checkObjectConditions, but responds
+// with 404 when the object doesn't exist.
+func (s *Server) checkExistingObjectConditions(conds objectConditions, bucketName, objectName string, generation int64) *jsonResponse {
+	if conds.isEmpty() {
+		return nil
+	}
+	attrs, err := s.getObjectAttrs(bucketName, objectName, generation)
+	if err != nil {
+		return &jsonResponse{status: http.StatusNotFound}
+	}
+	return conditionsResponse(conds.evaluate(attrs, false))
+}
+
+func (s *Server) getObjectAttrs(bucketName, objectName string, generation int64) (*backend.ObjectAttrs, error) {
+	var obj backend.StreamingObject
+	var err error
+	if generation != 0 {
+		obj, err = s.backend.GetObjectWithGeneration(bucketName, objectName, generation)
+	} else {
+		obj, err = s.backend.GetObject(bucketName, objectName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	obj.Content.Close()
+	return &obj.ObjectAttrs, nil
+}
+
+// conditionsResponse returns the response for the status returned by
+// objectConditions.evaluate, or nil if the conditions are satisfied.
+func conditionsResponse(status int) *jsonResponse {
+	switch status {
+	case 0:
+		return nil
+	case http.StatusPreconditionFailed:
+		return &jsonResponse{status: status, errorMessage: "Precondition failed"}
+	default:
+		return &jsonResponse{status: status}
+	}
+}
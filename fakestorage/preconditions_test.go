@@ -0,0 +1,278 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+func TestServerClientObjectMetagenerationPreconditions(t *testing.T) {
+	const bucketName = "some-bucket"
+	const objectName = "some-object.txt"
+	objs := []Object{{BucketName: bucketName, Name: objectName, Content: []byte("some content")}}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		client := server.Client()
+		obj := client.Bucket(bucketName).Object(objectName)
+		attrs, err := obj.Update(context.TODO(), storage.ObjectAttrsToUpdate{Metadata: map[string]string{"key": "value"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Metageneration != 2 {
+			t.Fatalf("wrong metageneration after patch\nwant 2\ngot  %d", attrs.Metageneration)
+		}
+		match := obj.If(storage.Conditions{MetagenerationMatch: 2})
+		notMatch := obj.If(storage.Conditions{MetagenerationNotMatch: 2})
+		stale := obj.If(storage.Conditions{MetagenerationMatch: 1})
+
+		tests := []struct {
+			name string
+			read bool
+			fn   func(*storage.ObjectHandle) error
+		}{
+			{
+				"get",
+				true,
+				func(o *storage.ObjectHandle) error {
+					_, err := o.Attrs(context.TODO())
+					return err
+				},
+			},
+			{
+				"download",
+				true,
+				func(o *storage.ObjectHandle) error {
+					reader, err := o.NewReader(context.TODO())
+					if err == nil {
+						reader.Close()
+					}
+					return err
+				},
+			},
+			{
+				"patch",
+				false,
+				func(o *storage.ObjectHandle) error {
+					_, err := o.Update(context.TODO(), storage.ObjectAttrsToUpdate{Metadata: map[string]string{"key": "value"}})
+					return err
+				},
+			},
+			{
+				"rewrite source",
+				false,
+				func(o *storage.ObjectHandle) error {
+					_, err := client.Bucket(bucketName).Object("copy.txt").CopierFrom(o).Run(context.TODO())
+					return err
+				},
+			},
+			{
+				"rewrite destination",
+				false,
+				func(o *storage.ObjectHandle) error {
+					_, err := o.CopierFrom(client.Bucket(bucketName).Object(objectName)).Run(context.TODO())
+					return err
+				},
+			},
+			{
+				"upload",
+				false,
+				func(o *storage.ObjectHandle) error {
+					w := o.NewWriter(context.TODO())
+					w.Write([]byte("new content"))
+					return w.Close()
+				},
+			},
+		}
+		for _, test := range tests {
+			if err := test.fn(stale); !hasStatusCode(err, http.StatusPreconditionFailed) {
+				t.Errorf("%s: expected precondition failure, got %v", test.name, err)
+			}
+			expectedStatus := http.StatusPreconditionFailed
+			if test.read {
+				expectedStatus = http.StatusNotModified
+			}
+			if err := test.fn(notMatch); !hasStatusCode(err, expectedStatus) {
+				t.Errorf("%s: expected status %d, got %v", test.name, expectedStatus, err)
+			}
+		}
+
+		// a successful precondition on each operation, except for the
+		// upload, which resets the metageneration.
+		for _, test := range tests[:len(tests)-1] {
+			attrs, err := obj.Attrs(context.TODO())
+			if err != nil {
+				t.Fatal(err)
+			}
+			current := obj.If(storage.Conditions{MetagenerationMatch: attrs.Metageneration})
+			if err := test.fn(current); err != nil {
+				t.Errorf("%s: unexpected error with matching metageneration: %v", test.name, err)
+			}
+		}
+		if err := match.Delete(context.TODO()); !hasStatusCode(err, http.StatusPreconditionFailed) {
+			t.Errorf("delete: expected precondition failure, got %v", err)
+		}
+		attrs, err = obj.Attrs(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = obj.If(storage.Conditions{MetagenerationMatch: attrs.Metageneration}).Delete(context.TODO())
+		if err != nil {
+			t.Errorf("delete: unexpected error with matching metageneration: %v", err)
+		}
+	})
+}
+
+func hasStatusCode(err error, code int) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+func TestServerClientObjectGenerationPreconditions(t *testing.T) {
+	const bucketName = "some-bucket"
+	const objectName = "some-object.txt"
+	objs := []Object{{BucketName: bucketName, Name: objectName, Content: []byte("some content")}}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		client := server.Client()
+		obj := client.Bucket(bucketName).Object(objectName)
+		attrs, err := obj.Attrs(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		generation := attrs.Generation
+		stale := obj.If(storage.Conditions{GenerationMatch: generation + 1})
+		notMatch := obj.If(storage.Conditions{GenerationNotMatch: generation})
+		current := obj.If(storage.Conditions{GenerationMatch: generation})
+
+		if _, err := stale.Attrs(context.TODO()); !hasStatusCode(err, http.StatusPreconditionFailed) {
+			t.Errorf("get: expected precondition failure, got %v", err)
+		}
+		if _, err := notMatch.Attrs(context.TODO()); !hasStatusCode(err, http.StatusNotModified) {
+			t.Errorf("get: expected not modified, got %v", err)
+		}
+		if _, err := notMatch.NewReader(context.TODO()); !hasStatusCode(err, http.StatusNotModified) {
+			t.Errorf("download: expected not modified, got %v", err)
+		}
+		if _, err := current.Attrs(context.TODO()); err != nil {
+			t.Errorf("get: unexpected error with matching generation: %v", err)
+		}
+		update := storage.ObjectAttrsToUpdate{Metadata: map[string]string{"key": "value"}}
+		if _, err := stale.Update(context.TODO(), update); !hasStatusCode(err, http.StatusPreconditionFailed) {
+			t.Errorf("patch: expected precondition failure, got %v", err)
+		}
+		if _, err := notMatch.Update(context.TODO(), update); !hasStatusCode(err, http.StatusPreconditionFailed) {
+			t.Errorf("patch: expected precondition failure, got %v", err)
+		}
+		if _, err := current.Update(context.TODO(), update); err != nil {
+			t.Errorf("patch: unexpected error with matching generation: %v", err)
+		}
+
+		dst := client.Bucket(bucketName).Object("copy.txt")
+		if _, err := dst.CopierFrom(stale).Run(context.TODO()); !hasStatusCode(err, http.StatusPreconditionFailed) {
+			t.Errorf("rewrite: expected precondition failure on the source, got %v", err)
+		}
+		if _, err := dst.If(storage.Conditions{DoesNotExist: true}).CopierFrom(current).Run(context.TODO()); err != nil {
+			t.Errorf("rewrite: unexpected error: %v", err)
+		}
+		if _, err := dst.If(storage.Conditions{DoesNotExist: true}).CopierFrom(current).Run(context.TODO()); !hasStatusCode(err, http.StatusPreconditionFailed) {
+			t.Errorf("rewrite: expected precondition failure on the destination, got %v", err)
+		}
+
+		if err := stale.Delete(context.TODO()); !hasStatusCode(err, http.StatusPreconditionFailed) {
+			t.Errorf("delete: expected precondition failure, got %v", err)
+		}
+		if err := current.Delete(context.TODO()); err != nil {
+			t.Errorf("delete: unexpected error with matching generation: %v", err)
+		}
+	})
+}
+
+func TestServerObjectConditionalHeaders(t *testing.T) {
+	const bucketName = "some-bucket"
+	const objectName = "some-object.txt"
+	updated := time.Date(2021, 3, 4, 10, 20, 30, 0, time.UTC)
+	server := NewServer([]Object{{BucketName: bucketName, Name: objectName, Content: []byte("some content"), Updated: updated}})
+	defer server.Stop()
+	client := server.HTTPClient()
+
+	obj, err := server.GetObject(bucketName, objectName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := `"` + objectEtag(obj.Generation, obj.Metageneration) + `"`
+
+	urls := map[string]string{
+		"json":     server.URL() + "/storage/v1/b/" + bucketName + "/o/" + objectName,
+		"media":    server.URL() + "/storage/v1/b/" + bucketName + "/o/" + objectName + "?alt=media",
+		"download": server.URL() + "/download/storage/v1/b/" + bucketName + "/o/" + objectName,
+		"xml":      server.PublicURL() + "/" + bucketName + "/" + objectName,
+	}
+	tests := []struct {
+		name           string
+		header         string
+		value          string
+		expectedStatus int
+	}{
+		{"no conditions", "", "", http.StatusOK},
+		{"if-match with current etag", "If-Match", etag, http.StatusOK},
+		{"if-match with wildcard", "If-Match", "*", http.StatusOK},
+		{"if-match with stale etag", "If-Match", `"stale"`, http.StatusPreconditionFailed},
+		{"if-none-match with current etag", "If-None-Match", `"stale", ` + etag, http.StatusNotModified},
+		{"if-none-match with stale etag", "If-None-Match", `"stale"`, http.StatusOK},
+		{"if-modified-since before update", "If-Modified-Since", updated.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK},
+		{"if-modified-since after update", "If-Modified-Since", updated.Format(http.TimeFormat), http.StatusNotModified},
+		{"if-unmodified-since before update", "If-Unmodified-Since", updated.Add(-time.Hour).Format(http.TimeFormat), http.StatusPreconditionFailed},
+	}
+	for route, url := range urls {
+		for _, test := range tests {
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.header != "" {
+				req.Header.Set(test.header, test.value)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.expectedStatus {
+				t.Errorf("%s, %s: wrong status code\nwant %d\ngot  %d", route, test.name, test.expectedStatus, resp.StatusCode)
+			}
+			if test.expectedStatus != http.StatusPreconditionFailed && resp.Header.Get("ETag") != etag {
+				t.Errorf("%s, %s: wrong etag\nwant %q\ngot  %q", route, test.name, etag, resp.Header.Get("ETag"))
+			}
+		}
+	}
+}
+
+func TestServerClientObjectEtagChangesWithMetadata(t *testing.T) {
+	objs := []Object{{BucketName: "some-bucket", Name: "some-object.txt", Content: []byte("some content")}}
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		obj := server.Client().Bucket("some-bucket").Object("some-object.txt")
+		attrs, err := obj.Attrs(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Etag == "" {
+			t.Fatal("unexpected empty etag")
+		}
+		updated, err := obj.Update(context.TODO(), storage.ObjectAttrsToUpdate{Metadata: map[string]string{"key": "value"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Etag == attrs.Etag {
+			t.Errorf("etag didn't change after updating the metadata: %q", attrs.Etag)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errUnsatisfiableRange = errors.New("requested range not satisfiable")
+
+// byteRange is a range of the content of an object, with end being exclusive.
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start
+}
+
+// contentRange returns the value of the Content-Range header for the range,
+// given the size of the object.
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end-1, size)
+}
+
+// parseRange parses the value of a Range header, as defined in RFC 7233,
+// returning the satisfiable ranges of an object with the given size.
+//
+// It returns no ranges when the whole object should be served, which is the
+// case when the header is empty, uses a unit other than bytes or is
+// malformed, and errUnsatisfiableRange when none of the requested ranges
+// overlap with the object content.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	specs := strings.TrimPrefix(header, "bytes=")
+	if header == "" || specs == header {
+		return nil, nil
+	}
+	var ranges []byteRange
+	var parsed int
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parsed++
+		parts := strings.SplitN(spec, "-", 2)
+		if len(parts) != 2 {
+			return nil, nil
+		}
+		startStr, endStr := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if startStr == "" {
+			// suffix range: the last N bytes of the object.
+			suffix, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, nil
+			}
+			if suffix == 0 || size == 0 {
+				continue
+			}
+			if suffix > size {
+				suffix = size
+			}
+			ranges = append(ranges, byteRange{start: size - suffix, end: size})
+			continue
+		}
+		start, err := strconv.ParseInt(startStr, 10, 64)
+		if err != nil || start < 0 {
+			return nil, nil
+		}
+		end := size
+		if endStr != "" {
+			last, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || last < start {
+				return nil, nil
+			}
+			if last < size {
+				end = last + 1
+			}
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, end: end})
+	}
+	if parsed == 0 {
+		return nil, nil
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	const size = 10
+	tests := []struct {
+		header      string
+		expected    []byteRange
+		expectedErr error
+	}{
+		{"", nil, nil},
+		{"items=0-4", nil, nil},
+		{"bytes=abc", nil, nil},
+		{"bytes=4-2", nil, nil},
+		{"bytes=", nil, nil},
+		{"bytes=0-4", []byteRange{{0, 5}}, nil},
+		{"bytes=2-", []byteRange{{2, 10}}, nil},
+		{"bytes=5-100", []byteRange{{5, 10}}, nil},
+		{"bytes=-3", []byteRange{{7, 10}}, nil},
+		{"bytes=-30", []byteRange{{0, 10}}, nil},
+		{"bytes=0-1, 4-5, -2", []byteRange{{0, 2}, {4, 6}, {8, 10}}, nil},
+		{"bytes=0-1,20-30", []byteRange{{0, 2}}, nil},
+		{"bytes=10-", nil, errUnsatisfiableRange},
+		{"bytes=20-30", nil, errUnsatisfiableRange},
+		{"bytes=-0", nil, errUnsatisfiableRange},
+	}
+	for _, test := range tests {
+		ranges, err := parseRange(test.header, size)
+		if !errors.Is(err, test.expectedErr) {
+			t.Errorf("%q: wrong error\nwant %v\ngot  %v", test.header, test.expectedErr, err)
+		}
+		if !reflect.DeepEqual(ranges, test.expected) {
+			t.Errorf("%q: wrong ranges\nwant %v\ngot  %v", test.header, test.expected, ranges)
+		}
+	}
+}
+
+func TestServerClientObjectSuffixRangeReader(t *testing.T) {
+	const content = "some really nice but long content stored in my object"
+	objs := []Object{{BucketName: "some-bucket", Name: "some-object.txt", Content: []byte(content)}}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		obj := server.Client().Bucket("some-bucket").Object("some-object.txt")
+		reader, err := obj.NewRangeReader(context.TODO(), -6, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := content[len(content)-6:]; string(data) != expected {
+			t.Errorf("wrong data returned\nwant %q\ngot  %q", expected, string(data))
+		}
+	})
+}
+
+func TestServerObjectUnsatisfiableRange(t *testing.T) {
+	server := NewServer([]Object{{BucketName: "some-bucket", Name: "some-object.txt", Content: []byte("some content")}})
+	defer server.Stop()
+	req, err := http.NewRequest(http.MethodGet, server.URL()+"/download/storage/v1/b/some-bucket/o/some-object.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=100-")
+	resp, err := server.HTTPClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+	}
+	if expected := "bytes */12"; resp.Header.Get("Content-Range") != expected {
+		t.Errorf("wrong Content-Range\nwant %q\ngot  %q", expected, resp.Header.Get("Content-Range"))
+	}
+}
+
+func TestServerObjectMultipleRanges(t *testing.T) {
+	const content = "some really nice content"
+	server := NewServer([]Object{{BucketName: "some-bucket", Name: "some-object.txt", ContentType: "text/plain", Content: []byte(content)}})
+	defer server.Stop()
+	req, err := http.NewRequest(http.MethodGet, server.PublicURL()+"/some-bucket/some-object.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=0-3,5-10,-7")
+	resp, err := server.HTTPClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("wrong status code\nwant %d\ngot  %d", http.StatusPartialContent, resp.StatusCode)
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/byteranges" {
+		t.Fatalf("wrong content type\nwant %q\ngot  %q", "multipart/byteranges", mediaType)
+	}
+	expectedParts := []struct {
+		contentRange string
+		data         string
+	}{
+		{"bytes 0-3/24", "some"},
+		{"bytes 5-10/24", "really"},
+		{"bytes 17-23/24", "content"},
+	}
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	for _, expected := range expectedParts {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cr := part.Header.Get("Content-Range"); cr != expected.contentRange {
+			t.Errorf("wrong Content-Range\nwant %q\ngot  %q", expected.contentRange, cr)
+		}
+		if ct := part.Header.Get("Content-Type"); ct != "text/plain" {
+			t.Errorf("wrong Content-Type\nwant %q\ngot  %q", "text/plain", ct)
+		}
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected.data {
+			t.Errorf("wrong data\nwant %q\ngot  %q", expected.data, string(data))
+		}
+	}
+	if _, err := reader.NextPart(); err == nil {
+		t.Error("unexpected extra part in the response")
+	}
+}
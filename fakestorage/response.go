@@ -4,49 +4,103 @@
 
 package fakestorage
 
-import "github.com/fsouza/fake-gcs-server/internal/backend"
+import (
+	"net/url"
+	"time"
+
+	"github.com/fsouza/fake-gcs-server/backend"
+)
 
 const timestampFormat = "2006-01-02T15:04:05.999999Z07:00"
 
 type listResponse struct {
-	Kind     string        `json:"kind"`
-	Items    []interface{} `json:"items"`
-	Prefixes []string      `json:"prefixes,omitempty"`
+	Kind          string        `json:"kind"`
+	Items         []interface{} `json:"items"`
+	Prefixes      []string      `json:"prefixes,omitempty"`
+	NextPageToken string        `json:"nextPageToken,omitempty"`
 }
 
-func newListBucketsResponse(buckets []backend.Bucket) listResponse {
+// defaultLocation is the location of buckets created without one.
+const defaultLocation = "US"
+
+// projectNumber is the number of the project that every bucket in the server
+// belongs to.
+const projectNumber = "0"
+
+func newListBucketsResponse(buckets []backend.Bucket, baseURL string) listResponse {
 	resp := listResponse{
 		Kind:  "storage#buckets",
 		Items: make([]interface{}, len(buckets)),
 	}
 	for i, bucket := range buckets {
-		resp.Items[i] = newBucketResponse(bucket)
+		resp.Items[i] = newBucketResponse(bucket, baseURL)
 	}
 	return resp
 }
 
 type bucketResponse struct {
-	Kind        string            `json:"kind"`
-	ID          string            `json:"id"`
-	Name        string            `json:"name"`
-	Versioning  *bucketVersioning `json:"versioning,omitempty"`
-	TimeCreated string            `json:"timeCreated,omitempty"`
+	Kind                  string                 `json:"kind"`
+	ID                    string                 `json:"id"`
+	Name                  string                 `json:"name"`
+	SelfLink              string                 `json:"selfLink,omitempty"`
+	ProjectNumber         string                 `json:"projectNumber"`
+	Location              string                 `json:"location"`
+	StorageClass          string                 `json:"storageClass"`
+	Labels                map[string]string      `json:"labels,omitempty"`
+	Versioning            *bucketVersioning      `json:"versioning,omitempty"`
+	Lifecycle             *bucketLifecycle       `json:"lifecycle,omitempty"`
+	RetentionPolicy       *bucketRetentionPolicy `json:"retentionPolicy,omitempty"`
+	DefaultEventBasedHold bool                   `json:"defaultEventBasedHold,omitempty"`
+	TimeCreated           string                 `json:"timeCreated,omitempty"`
+	Updated               string                 `json:"updated,omitempty"`
+	Metageneration        int64                  `json:"metageneration,string"`
+	Etag                  string                 `json:"etag"`
 }
 
 type bucketVersioning struct {
 	Enabled bool `json:"enabled,omitempty"`
 }
 
-func newBucketResponse(bucket backend.Bucket) bucketResponse {
+// newBucketResponse returns the resource of the given bucket, with a self
+// link relative to the given base URL of the server, if any.
+func newBucketResponse(bucket backend.Bucket, baseURL string) bucketResponse {
+	location := bucket.Location
+	if location == "" {
+		location = defaultLocation
+	}
+	var selfLink string
+	if baseURL != "" {
+		selfLink = baseURL + "/storage/v1/b/" + url.PathEscape(bucket.Name)
+	}
 	return bucketResponse{
-		Kind:        "storage#bucket",
-		ID:          bucket.Name,
-		Name:        bucket.Name,
-		Versioning:  &bucketVersioning{bucket.VersioningEnabled},
-		TimeCreated: bucket.TimeCreated.Format(timestampFormat),
+		Kind:                  "storage#bucket",
+		ID:                    bucket.Name,
+		Name:                  bucket.Name,
+		SelfLink:              selfLink,
+		ProjectNumber:         projectNumber,
+		Location:              location,
+		StorageClass:          getStorageClassIfEmpty(bucket.StorageClass),
+		Labels:                bucket.Labels,
+		Versioning:            &bucketVersioning{bucket.VersioningEnabled},
+		Lifecycle:             newBucketLifecycle(bucket.Lifecycle),
+		RetentionPolicy:       newBucketRetentionPolicy(bucket.RetentionPolicy),
+		DefaultEventBasedHold: bucket.DefaultEventBasedHold,
+		TimeCreated:           bucket.TimeCreated.Format(timestampFormat),
+		Updated:               getUpdatedIfZero(bucket).Format(timestampFormat),
+		Metageneration:        bucket.Metageneration,
+		Etag:                  bucketEtag(bucket.Metageneration),
 	}
 }
 
+// getUpdatedIfZero returns the update time of the bucket, which is its
+// creation time for buckets stored by backends that don't track updates.
+func getUpdatedIfZero(bucket backend.Bucket) time.Time {
+	if bucket.Updated.IsZero() {
+		return bucket.TimeCreated
+	}
+	return bucket.Updated
+}
+
 func newListObjectsResponse(objs []Object, prefixes []string) listResponse {
 	resp := listResponse{
 		Kind:     "storage#objects",
@@ -81,42 +135,66 @@ type objectAccessControl struct {
 }
 
 type objectResponse struct {
-	Kind            string                 `json:"kind"`
-	Name            string                 `json:"name"`
-	ID              string                 `json:"id"`
-	Bucket          string                 `json:"bucket"`
-	Size            int64                  `json:"size,string"`
-	ContentType     string                 `json:"contentType,omitempty"`
-	ContentEncoding string                 `json:"contentEncoding,omitempty"`
-	Crc32c          string                 `json:"crc32c,omitempty"`
-	ACL             []*objectAccessControl `json:"acl,omitempty"`
-	Md5Hash         string                 `json:"md5Hash,omitempty"`
-	TimeCreated     string                 `json:"timeCreated,omitempty"`
-	TimeDeleted     string                 `json:"timeDeleted,omitempty"`
-	Updated         string                 `json:"updated,omitempty"`
-	Generation      int64                  `json:"generation,string"`
-	Metadata        map[string]string      `json:"metadata,omitempty"`
+	Kind               string                 `json:"kind"`
+	Name               string                 `json:"name"`
+	ID                 string                 `json:"id"`
+	Bucket             string                 `json:"bucket"`
+	Size               int64                  `json:"size,string"`
+	ContentType        string                 `json:"contentType,omitempty"`
+	ContentEncoding    string                 `json:"contentEncoding,omitempty"`
+	ContentDisposition string                 `json:"contentDisposition,omitempty"`
+	ContentLanguage    string                 `json:"contentLanguage,omitempty"`
+	CacheControl       string                 `json:"cacheControl,omitempty"`
+	StorageClass       string                 `json:"storageClass,omitempty"`
+	Crc32c             string                 `json:"crc32c,omitempty"`
+	ACL                []*objectAccessControl `json:"acl,omitempty"`
+	Md5Hash            string                 `json:"md5Hash,omitempty"`
+	TimeCreated        string                 `json:"timeCreated,omitempty"`
+	TimeDeleted        string                 `json:"timeDeleted,omitempty"`
+	Updated            string                 `json:"updated,omitempty"`
+	Generation         int64                  `json:"generation,string"`
+	Metageneration     int64                  `json:"metageneration,string"`
+	Etag               string                 `json:"etag,omitempty"`
+	Metadata           map[string]string      `json:"metadata,omitempty"`
+	ComponentCount     int64                  `json:"componentCount,omitempty"`
+	KmsKeyName         string                 `json:"kmsKeyName,omitempty"`
+	CustomTime         string                 `json:"customTime,omitempty"`
+	TemporaryHold      bool                   `json:"temporaryHold,omitempty"`
+	EventBasedHold     bool                   `json:"eventBasedHold,omitempty"`
+	RetentionExpiry    string                 `json:"retentionExpirationTime,omitempty"`
 }
 
 func newObjectResponse(obj Object) objectResponse {
 	acl := getAccessControlsListFromObject(obj)
 
 	return objectResponse{
-		Kind:            "storage#object",
-		ID:              obj.id(),
-		Bucket:          obj.BucketName,
-		Name:            obj.Name,
-		Size:            int64(len(obj.Content)),
-		ContentType:     obj.ContentType,
-		ContentEncoding: obj.ContentEncoding,
-		Crc32c:          obj.Crc32c,
-		Md5Hash:         obj.Md5Hash,
-		ACL:             acl,
-		Metadata:        obj.Metadata,
-		TimeCreated:     obj.Created.Format(timestampFormat),
-		TimeDeleted:     obj.Deleted.Format(timestampFormat),
-		Updated:         obj.Updated.Format(timestampFormat),
-		Generation:      obj.Generation,
+		Kind:               "storage#object",
+		ID:                 obj.id(),
+		Bucket:             obj.BucketName,
+		Name:               obj.Name,
+		Size:               obj.Size,
+		ContentType:        obj.ContentType,
+		ContentEncoding:    obj.ContentEncoding,
+		ContentDisposition: obj.ContentDisposition,
+		ContentLanguage:    obj.ContentLanguage,
+		CacheControl:       obj.CacheControl,
+		StorageClass:       getStorageClassIfEmpty(obj.StorageClass),
+		Crc32c:             obj.Crc32c,
+		Md5Hash:            obj.Md5Hash,
+		ACL:                acl,
+		Metadata:           obj.Metadata,
+		TimeCreated:        obj.Created.Format(timestampFormat),
+		TimeDeleted:        obj.Deleted.Format(timestampFormat),
+		Updated:            obj.Updated.Format(timestampFormat),
+		Generation:         obj.Generation,
+		Metageneration:     obj.Metageneration,
+		Etag:               objectEtag(obj.Generation, obj.Metageneration),
+		ComponentCount:     obj.ComponentCount,
+		KmsKeyName:         obj.KmsKeyName,
+		CustomTime:         formatTimeIfNotZero(obj.CustomTime),
+		TemporaryHold:      obj.TemporaryHold,
+		EventBasedHold:     obj.EventBasedHold,
+		RetentionExpiry:    formatTimeIfNotZero(obj.RetentionExpirationTime),
 	}
 }
 
@@ -145,22 +223,33 @@ func getAccessControlsListFromObject(obj Object) []*objectAccessControl {
 }
 
 type rewriteResponse struct {
-	Kind                string         `json:"kind"`
-	TotalBytesRewritten int64          `json:"totalBytesRewritten,string"`
-	ObjectSize          int64          `json:"objectSize,string"`
-	Done                bool           `json:"done"`
-	RewriteToken        string         `json:"rewriteToken"`
-	Resource            objectResponse `json:"resource"`
+	Kind                string          `json:"kind"`
+	TotalBytesRewritten int64           `json:"totalBytesRewritten,string"`
+	ObjectSize          int64           `json:"objectSize,string"`
+	Done                bool            `json:"done"`
+	RewriteToken        string          `json:"rewriteToken"`
+	Resource            *objectResponse `json:"resource,omitempty"`
 }
 
 func newObjectRewriteResponse(obj Object) rewriteResponse {
+	resource := newObjectResponse(obj)
 	return rewriteResponse{
 		Kind:                "storage#rewriteResponse",
-		TotalBytesRewritten: int64(len(obj.Content)),
-		ObjectSize:          int64(len(obj.Content)),
+		TotalBytesRewritten: obj.Size,
+		ObjectSize:          obj.Size,
 		Done:                true,
 		RewriteToken:        "",
-		Resource:            newObjectResponse(obj),
+		Resource:            &resource,
+	}
+}
+
+func newPartialRewriteResponse(totalBytesRewritten, objectSize int64, token string) rewriteResponse {
+	return rewriteResponse{
+		Kind:                "storage#rewriteResponse",
+		TotalBytesRewritten: totalBytesRewritten,
+		ObjectSize:          objectSize,
+		Done:                false,
+		RewriteToken:        token,
 	}
 }
 
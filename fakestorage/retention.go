@@ -0,0 +1,179 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/fsouza/fake-gcs-server/backend"
+)
+
+// maxRetentionPeriod is the longest retention period accepted by GCS, which
+// is 100 years, in seconds.
+const maxRetentionPeriod = 3155760000
+
+var (
+	errObjectRetained          = errors.New("object is under active hold or retention period")
+	errLockedRetentionPolicy   = errors.New("locked retention policies can't be removed or have their retention period reduced")
+	errInvalidRetentionPeriod  = errors.New("invalid retention period")
+	errMissingRetentionPolicy  = errors.New("bucket has no retention policy to lock")
+	errMissingMetagenerationIf = errors.New("ifMetagenerationMatch is required to lock retention policies")
+)
+
+type bucketRetentionPolicy struct {
+	RetentionPeriod int64  `json:"retentionPeriod,string"`
+	EffectiveTime   string `json:"effectiveTime,omitempty"`
+	IsLocked        bool   `json:"isLocked,omitempty"`
+}
+
+// newBucketRetentionPolicy returns the retention policy resource of a
+// bucket, or nil if the bucket has no retention policy.
+func newBucketRetentionPolicy(policy *backend.RetentionPolicy) *bucketRetentionPolicy {
+	if policy == nil {
+		return nil
+	}
+	return &bucketRetentionPolicy{
+		RetentionPeriod: policy.RetentionPeriod,
+		EffectiveTime:   formatTimeIfNotZero(policy.EffectiveTime),
+		IsLocked:        policy.IsLocked,
+	}
+}
+
+// toBackend validates the retention policy sent by clients, returning it in
+// the format stored by the backend, effective at the given time. It returns
+// nil if the policy is nil or has a zero retention period.
+func (p *bucketRetentionPolicy) toBackend(now time.Time) (*backend.RetentionPolicy, error) {
+	if p == nil || p.RetentionPeriod == 0 {
+		return nil, nil
+	}
+	if p.RetentionPeriod < 0 || p.RetentionPeriod > maxRetentionPeriod {
+		return nil, errInvalidRetentionPeriod
+	}
+	return &backend.RetentionPolicy{RetentionPeriod: p.RetentionPeriod, EffectiveTime: now}, nil
+}
+
+// parseRetentionPolicyPatch returns the retention policy to store for a
+// bucket update request, or nil if the request doesn't change it. When
+// replace is true, a missing retention policy removes the current one.
+//
+// Like in GCS, the isLocked field of the request is ignored: policies are
+// locked with lockRetentionPolicy.
+func parseRetentionPolicyPatch(fields map[string]json.RawMessage, current *backend.RetentionPolicy, replace bool, now time.Time) (*backend.RetentionPolicy, error) {
+	raw, ok := fields["retentionPolicy"]
+	if !ok && !replace {
+		return nil, nil
+	}
+	var policy *bucketRetentionPolicy
+	if ok && !isJSONNull(raw) {
+		if err := json.Unmarshal(raw, &policy); err != nil {
+			return nil, errors.New("invalid retentionPolicy")
+		}
+	}
+	updated, err := policy.toBackend(now)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return updated, nil
+	}
+	if updated == nil {
+		if current.IsLocked {
+			return nil, errLockedRetentionPolicy
+		}
+		return &backend.RetentionPolicy{}, nil
+	}
+	if updated.RetentionPeriod == current.RetentionPeriod {
+		return nil, nil
+	}
+	if current.IsLocked && updated.RetentionPeriod < current.RetentionPeriod {
+		return nil, errLockedRetentionPolicy
+	}
+	updated.IsLocked = current.IsLocked
+	return updated, nil
+}
+
+// lockRetentionPolicy permanently locks the retention policy of the bucket.
+// Like in GCS, the request must include the current metageneration of the
+// bucket.
+func (s *Server) lockRetentionPolicy(r *http.Request) jsonResponse {
+	if r.URL.Query().Get("ifMetagenerationMatch") == "" {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: errMissingMetagenerationIf.Error()}
+	}
+	bucket, resp := s.getBucketWithConditions(r, false)
+	if resp != nil {
+		return *resp
+	}
+	if bucket.RetentionPolicy == nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: errMissingRetentionPolicy.Error()}
+	}
+	if !bucket.RetentionPolicy.IsLocked {
+		policy := *bucket.RetentionPolicy
+		policy.IsLocked = true
+		var err error
+		bucket, err = s.backend.UpdateBucket(bucket.Name, backend.BucketPatch{RetentionPolicy: &policy})
+		if err != nil {
+			return jsonResponse{status: http.StatusNotFound}
+		}
+	}
+	return jsonResponse{data: newBucketResponse(bucket, s.URL())}
+}
+
+// retentionExpirationTime returns the time until which the retention policy
+// of the bucket keeps an object created at the given time, or the zero time
+// if the bucket has no retention policy. The retention period of objects is
+// counted from their creation.
+func retentionExpirationTime(bucket backend.Bucket, created time.Time) time.Time {
+	if bucket.RetentionPolicy == nil {
+		return time.Time{}
+	}
+	return created.Add(time.Duration(bucket.RetentionPolicy.RetentionPeriod) * time.Second)
+}
+
+// isRetained reports whether the object is under a hold or hasn't reached
+// the retention period of its bucket, in which case it can't be deleted or
+// replaced.
+func (s *Server) isRetained(bucket backend.Bucket, attrs backend.ObjectAttrs) bool {
+	if attrs.TemporaryHold || attrs.EventBasedHold {
+		return true
+	}
+	expiration := retentionExpirationTime(bucket, convertTimeWithoutError(attrs.Created))
+	return !expiration.IsZero() && s.clock.Now().Before(expiration)
+}
+
+// checkObjectRetention returns errObjectRetained if the given object exists
+// and is retained. A zero generation targets the live version of the object.
+func (s *Server) checkObjectRetention(bucketName, objectName string, generation int64) error {
+	bucket, err := s.backend.GetBucket(bucketName)
+	if err != nil {
+		return nil
+	}
+	var obj backend.StreamingObject
+	if generation != 0 {
+		obj, err = s.backend.GetObjectWithGeneration(bucketName, objectName, generation)
+	} else {
+		obj, err = s.backend.GetObject(bucketName, objectName)
+	}
+	if err != nil {
+		return nil
+	}
+	obj.Content.Close()
+	if s.isRetained(bucket, obj.ObjectAttrs) {
+		return errObjectRetained
+	}
+	return nil
+}
+
+// withRetentionExpirationTime returns a copy of the object with the
+// retention expiration time set by the retention policy of its bucket.
+func (s *Server) withRetentionExpirationTime(obj Object) Object {
+	bucket, err := s.backend.GetBucket(obj.BucketName)
+	if err == nil {
+		obj.RetentionExpirationTime = retentionExpirationTime(bucket, obj.Created)
+	}
+	return obj
+}
@@ -0,0 +1,246 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/fsouza/fake-gcs-server/backend"
+)
+
+func writeClientObject(t *testing.T, client *storage.Client, bucketName, objectName, content string) error {
+	t.Helper()
+	w := client.Bucket(bucketName).Object(objectName).NewWriter(context.Background())
+	w.Write([]byte(content))
+	return w.Close()
+}
+
+func TestServerClientRetentionPolicy(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		const bucketName = "retained-bucket"
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: bucketName})
+		client := server.Client()
+		bucket := client.Bucket(bucketName)
+		attrs, err := bucket.Update(context.Background(), storage.BucketAttrsToUpdate{
+			RetentionPolicy: &storage.RetentionPolicy{RetentionPeriod: time.Hour},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.RetentionPolicy == nil || attrs.RetentionPolicy.RetentionPeriod != time.Hour {
+			t.Fatalf("wrong retention policy: %+v", attrs.RetentionPolicy)
+		}
+		if attrs.RetentionPolicy.EffectiveTime.IsZero() || attrs.RetentionPolicy.IsLocked {
+			t.Errorf("wrong retention policy: %+v", attrs.RetentionPolicy)
+		}
+
+		if err := writeClientObject(t, client, bucketName, "file.txt", "something"); err != nil {
+			t.Fatal(err)
+		}
+		obj := bucket.Object("file.txt")
+		objAttrs, err := obj.Attrs(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedExpiration := objAttrs.Created.Add(time.Hour)
+		if !objAttrs.RetentionExpirationTime.Equal(expectedExpiration) {
+			t.Errorf("wrong retention expiration time\nwant %s\ngot  %s", expectedExpiration, objAttrs.RetentionExpirationTime)
+		}
+
+		err = obj.Delete(context.Background())
+		if !hasStatusCode(err, http.StatusForbidden) {
+			t.Errorf("expected forbidden deleting a retained object, got %v", err)
+		}
+		err = writeClientObject(t, client, bucketName, "file.txt", "something else")
+		if !hasStatusCode(err, http.StatusForbidden) {
+			t.Errorf("expected forbidden overwriting a retained object, got %v", err)
+		}
+		_, err = obj.CopierFrom(obj).Run(context.Background())
+		if !hasStatusCode(err, http.StatusForbidden) {
+			t.Errorf("expected forbidden rewriting a retained object, got %v", err)
+		}
+
+		if err := server.AdvanceTime(2 * time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := obj.Delete(context.Background()); err != nil {
+			t.Errorf("unexpected error deleting an object after its retention period: %v", err)
+		}
+	})
+}
+
+func TestServerClientLockRetentionPolicy(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		const bucketName = "locked-bucket"
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: bucketName, RetentionPeriod: time.Hour})
+		bucket := server.Client().Bucket(bucketName)
+
+		err := bucket.If(storage.BucketConditions{MetagenerationMatch: 2}).LockRetentionPolicy(context.Background())
+		if !hasStatusCode(err, http.StatusPreconditionFailed) {
+			t.Errorf("expected precondition failure, got %v", err)
+		}
+		err = bucket.If(storage.BucketConditions{MetagenerationMatch: 1}).LockRetentionPolicy(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		attrs, err := bucket.Attrs(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.RetentionPolicy == nil || !attrs.RetentionPolicy.IsLocked {
+			t.Fatalf("retention policy wasn't locked: %+v", attrs.RetentionPolicy)
+		}
+
+		for _, period := range []time.Duration{0, time.Minute} {
+			_, err = bucket.Update(context.Background(), storage.BucketAttrsToUpdate{
+				RetentionPolicy: &storage.RetentionPolicy{RetentionPeriod: period},
+			})
+			if !hasStatusCode(err, http.StatusForbidden) {
+				t.Errorf("expected forbidden setting the retention period of a locked policy to %s, got %v", period, err)
+			}
+		}
+		attrs, err = bucket.Update(context.Background(), storage.BucketAttrsToUpdate{
+			RetentionPolicy: &storage.RetentionPolicy{RetentionPeriod: 2 * time.Hour},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.RetentionPolicy.RetentionPeriod != 2*time.Hour || !attrs.RetentionPolicy.IsLocked {
+			t.Errorf("wrong retention policy after increasing the retention period: %+v", attrs.RetentionPolicy)
+		}
+	})
+}
+
+func TestServerLockRetentionPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           CreateBucketOpts
+		query          string
+		expectedStatus int
+	}{
+		{"missing metageneration", CreateBucketOpts{Name: "some-bucket", RetentionPeriod: time.Hour}, "", http.StatusBadRequest},
+		{"missing retention policy", CreateBucketOpts{Name: "some-bucket"}, "?ifMetagenerationMatch=1", http.StatusBadRequest},
+		{"missing bucket", CreateBucketOpts{Name: "other-bucket"}, "?ifMetagenerationMatch=1", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		test := test
+		runServersTest(t, nil, func(t *testing.T, server *Server) {
+			server.CreateBucketWithOpts(test.opts)
+			req, err := http.NewRequest(http.MethodPost, server.URL()+"/storage/v1/b/some-bucket/lockRetentionPolicy"+test.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := server.HTTPClient().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.expectedStatus {
+				t.Errorf("%s: wrong status\nwant %d\ngot  %d", test.name, test.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestServerClientObjectHolds(t *testing.T) {
+	for _, hold := range []string{"temporary", "event-based"} {
+		hold := hold
+		runServersTest(t, nil, func(t *testing.T, server *Server) {
+			const bucketName = "some-bucket"
+			server.CreateBucketWithOpts(CreateBucketOpts{Name: bucketName})
+			client := server.Client()
+			if err := writeClientObject(t, client, bucketName, "file.txt", "something"); err != nil {
+				t.Fatal(err)
+			}
+			obj := client.Bucket(bucketName).Object("file.txt")
+			var update, release storage.ObjectAttrsToUpdate
+			if hold == "temporary" {
+				update.TemporaryHold, release.TemporaryHold = true, false
+			} else {
+				update.EventBasedHold, release.EventBasedHold = true, false
+			}
+			attrs, err := obj.Update(context.Background(), update)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attrs.TemporaryHold != (hold == "temporary") || attrs.EventBasedHold != (hold == "event-based") {
+				t.Errorf("wrong holds after placing a %s hold: temporary=%t, event-based=%t", hold, attrs.TemporaryHold, attrs.EventBasedHold)
+			}
+
+			err = obj.Delete(context.Background())
+			if !hasStatusCode(err, http.StatusForbidden) {
+				t.Errorf("expected forbidden deleting an object under a %s hold, got %v", hold, err)
+			}
+			err = writeClientObject(t, client, bucketName, "file.txt", "something else")
+			if !hasStatusCode(err, http.StatusForbidden) {
+				t.Errorf("expected forbidden overwriting an object under a %s hold, got %v", hold, err)
+			}
+			_, err = obj.ComposerFrom(obj).Run(context.Background())
+			if !hasStatusCode(err, http.StatusForbidden) {
+				t.Errorf("expected forbidden composing onto an object under a %s hold, got %v", hold, err)
+			}
+
+			if _, err := obj.Update(context.Background(), release); err != nil {
+				t.Fatal(err)
+			}
+			if err := obj.Delete(context.Background()); err != nil {
+				t.Errorf("unexpected error deleting an object after releasing its %s hold: %v", hold, err)
+			}
+		})
+	}
+}
+
+func TestServerClientDefaultEventBasedHold(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		const bucketName = "some-bucket"
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: bucketName})
+		client := server.Client()
+		attrs, err := client.Bucket(bucketName).Update(context.Background(), storage.BucketAttrsToUpdate{DefaultEventBasedHold: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !attrs.DefaultEventBasedHold {
+			t.Error("default event-based hold wasn't enabled")
+		}
+		if err := writeClientObject(t, client, bucketName, "file.txt", "something"); err != nil {
+			t.Fatal(err)
+		}
+		objAttrs, err := client.Bucket(bucketName).Object("file.txt").Attrs(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !objAttrs.EventBasedHold {
+			t.Error("new object wasn't placed under an event-based hold")
+		}
+	})
+}
+
+func TestServerLifecycleSkipsRetainedObjects(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		const bucketName = "some-bucket"
+		server.CreateBucketWithOpts(CreateBucketOpts{
+			Name: bucketName,
+			Lifecycle: backend.Lifecycle{Rules: []backend.LifecycleRule{{
+				Action:    backend.LifecycleAction{Type: lifecycleActionDelete},
+				Condition: backend.LifecycleCondition{Age: int64Ptr(1)},
+			}}},
+		})
+		server.CreateObject(Object{BucketName: bucketName, Name: "held.txt", TemporaryHold: true})
+		server.CreateObject(Object{BucketName: bucketName, Name: "free.txt"})
+		if err := server.AdvanceTime(2 * day); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.GetObject(bucketName, "held.txt"); err != nil {
+			t.Errorf("lifecycle deleted an object under a hold: %v", err)
+		}
+		if _, err := server.GetObject(bucketName, "free.txt"); err == nil {
+			t.Error("lifecycle didn't delete the object without holds")
+		}
+	})
+}
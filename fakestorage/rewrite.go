@@ -0,0 +1,113 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/fsouza/fake-gcs-server/backend"
+)
+
+// rewriteChunkSize is the unit of maxBytesRewrittenPerCall: like in GCS, it
+// must be a multiple of 1 MiB.
+const rewriteChunkSize = 1024 * 1024
+
+var (
+	errInvalidRewriteToken = errors.New("invalid rewriteToken")
+	errInvalidMaxBytes     = errors.New("maxBytesRewrittenPerCall must be a positive multiple of 1048576")
+	errSourceChanged       = errors.New("the source object changed during the rewrite")
+)
+
+// rewriteToken holds the progress of a rewrite that takes multiple calls. The
+// content is only copied on the last call, so the token only needs to
+// identify the rewrite and the number of bytes reported as rewritten so far.
+type rewriteToken struct {
+	SourceBucket      string `json:"sb"`
+	SourceObject      string `json:"so"`
+	SourceGeneration  int64  `json:"sg"`
+	DestinationBucket string `json:"db"`
+	DestinationObject string `json:"do"`
+	BytesRewritten    int64  `json:"n"`
+}
+
+func (t rewriteToken) encode() string {
+	data, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeRewriteToken(value string) (*rewriteToken, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidRewriteToken
+	}
+	var token rewriteToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, errInvalidRewriteToken
+	}
+	return &token, nil
+}
+
+// rewriteParams holds the parameters that control how many calls a rewrite
+// takes. A maxBytes of zero means that the rewrite completes in one call.
+type rewriteParams struct {
+	token    *rewriteToken
+	maxBytes int64
+}
+
+func parseRewriteParams(r *http.Request) (rewriteParams, error) {
+	var params rewriteParams
+	if value := r.URL.Query().Get("maxBytesRewrittenPerCall"); value != "" {
+		maxBytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || maxBytes <= 0 || maxBytes%rewriteChunkSize != 0 {
+			return params, errInvalidMaxBytes
+		}
+		params.maxBytes = maxBytes
+	}
+	var err error
+	params.token, err = decodeRewriteToken(r.URL.Query().Get("rewriteToken"))
+	return params, err
+}
+
+// progress validates the token against the rewrite being requested, returning
+// the number of bytes already reported as rewritten.
+func (p rewriteParams) progress(source backend.ObjectAttrs, dstBucket, dstObject string) (int64, error) {
+	if p.token == nil {
+		return 0, nil
+	}
+	if p.token.SourceBucket != source.BucketName || p.token.SourceObject != source.Name ||
+		p.token.DestinationBucket != dstBucket || p.token.DestinationObject != dstObject {
+		return 0, errInvalidRewriteToken
+	}
+	if p.token.SourceGeneration != source.Generation {
+		return 0, errSourceChanged
+	}
+	if p.token.BytesRewritten > source.Size {
+		return 0, errInvalidRewriteToken
+	}
+	return p.token.BytesRewritten, nil
+}
+
+// next returns the token for the call that follows one that started with the
+// given progress, or nil if the rewrite can complete in this call.
+func (p rewriteParams) next(source backend.ObjectAttrs, dstBucket, dstObject string, progress int64) *rewriteToken {
+	if p.maxBytes == 0 || source.Size-progress <= p.maxBytes {
+		return nil
+	}
+	return &rewriteToken{
+		SourceBucket:      source.BucketName,
+		SourceObject:      source.Name,
+		SourceGeneration:  source.Generation,
+		DestinationBucket: dstBucket,
+		DestinationObject: dstObject,
+		BytesRewritten:    progress + p.maxBytes,
+	}
+}
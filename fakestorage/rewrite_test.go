@@ -0,0 +1,263 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+)
+
+const rewritePath = "/storage/v1/b/some-bucket/o/source.txt/rewriteTo/b/some-bucket/o/destination.txt"
+
+func TestServerRewriteObjectInMultipleCalls(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 5*rewriteChunkSize/2)
+	objs := []Object{{BucketName: "some-bucket", Name: "source.txt", Content: content}}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		query := url.Values{"maxBytesRewrittenPerCall": {"1048576"}}
+		var progress []int64
+		for i := 0; i < 5; i++ {
+			var result rewriteResponse
+			status := sendJSONRequest(t, server, http.MethodPost, rewritePath+"?"+query.Encode(), "{}", &result)
+			if status != http.StatusOK {
+				t.Fatalf("wrong status code\nwant %d\ngot  %d", http.StatusOK, status)
+			}
+			if result.ObjectSize != int64(len(content)) {
+				t.Errorf("wrong object size\nwant %d\ngot  %d", len(content), result.ObjectSize)
+			}
+			progress = append(progress, result.TotalBytesRewritten)
+			if result.Done {
+				if result.Resource == nil {
+					t.Fatal("unexpected nil resource in the last response")
+				}
+				break
+			}
+			if result.Resource != nil {
+				t.Errorf("unexpected resource in partial response: %+v", result.Resource)
+			}
+			if _, err := server.GetObject("some-bucket", "destination.txt"); err == nil {
+				t.Fatal("the destination object was created before the rewrite was done")
+			}
+			query.Set("rewriteToken", result.RewriteToken)
+		}
+		expectedProgress := []int64{rewriteChunkSize, 2 * rewriteChunkSize, int64(len(content))}
+		if len(progress) != len(expectedProgress) {
+			t.Fatalf("wrong number of calls\nwant %v\ngot  %v", expectedProgress, progress)
+		}
+		for i := range progress {
+			if progress[i] != expectedProgress[i] {
+				t.Errorf("wrong progress\nwant %v\ngot  %v", expectedProgress, progress)
+				break
+			}
+		}
+		obj, err := server.GetObject("some-bucket", "destination.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(obj.Content, content) {
+			t.Error("wrong content in the destination object")
+		}
+	})
+}
+
+func TestServerRewriteObjectSourceChanged(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 2*rewriteChunkSize)
+	objs := []Object{{BucketName: "some-bucket", Name: "source.txt", Content: content}}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		query := url.Values{"maxBytesRewrittenPerCall": {"1048576"}}
+		var result rewriteResponse
+		status := sendJSONRequest(t, server, http.MethodPost, rewritePath+"?"+query.Encode(), "{}", &result)
+		if status != http.StatusOK || result.Done {
+			t.Fatalf("unexpected result for the first call: %d %+v", status, result)
+		}
+
+		w := server.Client().Bucket("some-bucket").Object("source.txt").NewWriter(context.Background())
+		w.Write([]byte("new content"))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		query.Set("rewriteToken", result.RewriteToken)
+		status = sendJSONRequest(t, server, http.MethodPost, rewritePath+"?"+query.Encode(), "{}", nil)
+		if status != http.StatusPreconditionFailed {
+			t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusPreconditionFailed, status)
+		}
+	})
+}
+
+func TestServerRewriteObjectInvalidParameters(t *testing.T) {
+	objs := []Object{{BucketName: "some-bucket", Name: "source.txt", Content: []byte("some content")}}
+	otherToken := rewriteToken{
+		SourceBucket:      "some-bucket",
+		SourceObject:      "other.txt",
+		DestinationBucket: "some-bucket",
+		DestinationObject: "destination.txt",
+	}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		tests := []struct {
+			name  string
+			query url.Values
+		}{
+			{"maxBytes not a number", url.Values{"maxBytesRewrittenPerCall": {"a lot"}}},
+			{"maxBytes not a multiple of 1 MiB", url.Values{"maxBytesRewrittenPerCall": {"1000"}}},
+			{"negative maxBytes", url.Values{"maxBytesRewrittenPerCall": {"-1048576"}}},
+			{"malformed token", url.Values{"rewriteToken": {"not a token"}}},
+			{"token for another rewrite", url.Values{"rewriteToken": {otherToken.encode()}}},
+		}
+		for _, test := range tests {
+			test := test
+			t.Run(test.name, func(t *testing.T) {
+				url := server.URL() + rewritePath + "?" + test.query.Encode()
+				resp, err := server.HTTPClient().Post(url, "application/json", strings.NewReader("{}"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+				body, _ := ioutil.ReadAll(resp.Body)
+				if resp.StatusCode != http.StatusBadRequest {
+					t.Errorf("wrong status code\nwant %d\ngot  %d\nbody: %s", http.StatusBadRequest, resp.StatusCode, body)
+				}
+			})
+		}
+	})
+}
+
+func TestServerCopyObject(t *testing.T) {
+	const content = "some content"
+	objs := []Object{{BucketName: "some-bucket", Name: "source.txt", Content: []byte(content)}}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		query := url.Values{
+			"destinationPredefinedAcl": {"publicRead"},
+			"destinationKmsKeyName":    {"projects/p/locations/global/keyRings/r/cryptoKeys/k"},
+		}
+		url := server.URL() + "/storage/v1/b/some-bucket/o/source.txt/copyTo/b/some-bucket/o/destination.txt?" + query.Encode()
+		resp, err := server.HTTPClient().Post(url, "application/json", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("wrong status code\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+		}
+		var object objectResponse
+		if err := json.NewDecoder(resp.Body).Decode(&object); err != nil {
+			t.Fatal(err)
+		}
+		if object.Kind != "storage#object" {
+			t.Errorf("wrong kind\nwant %q\ngot  %q", "storage#object", object.Kind)
+		}
+		if object.Name != "destination.txt" {
+			t.Errorf("wrong name\nwant %q\ngot  %q", "destination.txt", object.Name)
+		}
+		if object.Size != int64(len(content)) {
+			t.Errorf("wrong size\nwant %d\ngot  %d", len(content), object.Size)
+		}
+		if object.KmsKeyName != query.Get("destinationKmsKeyName") {
+			t.Errorf("wrong kms key name\nwant %q\ngot  %q", query.Get("destinationKmsKeyName"), object.KmsKeyName)
+		}
+		if len(object.ACL) != 1 || object.ACL[0].Entity != "allUsers" {
+			t.Errorf("wrong acl: %+v", object.ACL)
+		}
+	})
+}
+
+func TestServerCopyObjectErrors(t *testing.T) {
+	objs := []Object{{BucketName: "some-bucket", Name: "source.txt", Content: []byte("some content")}}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		tests := []struct {
+			name           string
+			path           string
+			expectedStatus int
+		}{
+			{
+				"invalid predefined acl",
+				"/o/source.txt/copyTo/b/some-bucket/o/destination.txt?destinationPredefinedAcl=everyone",
+				http.StatusBadRequest,
+			},
+			{
+				"missing destination bucket",
+				"/o/source.txt/copyTo/b/no-bucket/o/destination.txt",
+				http.StatusNotFound,
+			},
+			{
+				"missing source object",
+				"/o/missing.txt/copyTo/b/some-bucket/o/destination.txt",
+				http.StatusNotFound,
+			},
+			{
+				"destination precondition",
+				"/o/source.txt/copyTo/b/some-bucket/o/source.txt?ifGenerationMatch=0",
+				http.StatusPreconditionFailed,
+			},
+		}
+		for _, test := range tests {
+			test := test
+			t.Run(test.name, func(t *testing.T) {
+				resp, err := server.HTTPClient().Post(server.URL()+"/storage/v1/b/some-bucket"+test.path, "application/json", strings.NewReader("{}"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != test.expectedStatus {
+					t.Errorf("wrong status code\nwant %d\ngot  %d", test.expectedStatus, resp.StatusCode)
+				}
+			})
+		}
+		if _, err := server.GetObject("no-bucket", "destination.txt"); err == nil {
+			t.Error("unexpected object created in a missing bucket")
+		}
+	})
+}
+
+func TestServerClientRewriteOldGenerationInVersionedBucket(t *testing.T) {
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: "some-bucket", VersioningEnabled: true})
+		server.CreateObject(Object{BucketName: "some-bucket", Name: "file.txt", Content: []byte("old"), Generation: 100})
+		server.CreateObject(Object{BucketName: "some-bucket", Name: "file.txt", Content: []byte("new"), Generation: 200})
+
+		client := server.Client()
+		obj := client.Bucket("some-bucket").Object("file.txt")
+		copier := obj.If(storage.Conditions{GenerationMatch: 200}).CopierFrom(obj.Generation(100))
+		attrs, err := copier.Run(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.Generation == 100 || attrs.Generation == 200 {
+			t.Errorf("expected a new generation, got %d", attrs.Generation)
+		}
+
+		live, err := server.GetObject("some-bucket", "file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(live.Content) != "old" {
+			t.Errorf("wrong content in the live version\nwant %q\ngot  %q", "old", live.Content)
+		}
+		versions, _, err := server.ListObjectsWithOptions("some-bucket", ListOptions{Versions: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(versions) != 3 {
+			t.Errorf("wrong number of versions\nwant 3\ngot  %d", len(versions))
+		}
+
+		_, err = obj.If(storage.Conditions{GenerationMatch: 200}).CopierFrom(obj.Generation(100)).Run(context.TODO())
+		if !hasStatusCode(err, http.StatusPreconditionFailed) {
+			t.Errorf("expected precondition failure on the destination, got %v", err)
+		}
+	})
+}
@@ -5,6 +5,7 @@
 package fakestorage
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
 	"fmt"
@@ -13,9 +14,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/storage"
-	"github.com/fsouza/fake-gcs-server/internal/backend"
+	"github.com/fsouza/fake-gcs-server/backend"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"google.golang.org/api/option"
@@ -28,13 +30,20 @@ const defaultPublicHost = "storage.googleapis.com"
 // It provides a fake implementation of the Google Cloud Storage API.
 type Server struct {
 	backend     backend.Storage
-	uploads     sync.Map
+	uploads     backend.UploadStorage
 	transport   http.RoundTripper
 	ts          *httptest.Server
 	mux         *mux.Router
 	options     Options
 	externalURL string
 	publicHost  string
+	clock       *clock
+	uploadLocks uploadLocks
+
+	lifecycleMtx  sync.Mutex
+	stopLifecycle chan struct{}
+	startOnce     sync.Once
+	stopOnce      sync.Once
 }
 
 // NewServer creates a new instance of the server, pre-loaded with the given
@@ -91,6 +100,33 @@ type Options struct {
 
 	// Destination for writing log.
 	Writer io.Writer
+
+	// Optional storage backend. When set, the server stores buckets and
+	// objects in the given backend, pre-loading it with InitialObjects, and
+	// StorageRoot is ignored. When unset, the server uses the filesystem
+	// backend if StorageRoot is defined, and the memory backend otherwise.
+	//
+	// Custom backends can be registered by name using backend.Register.
+	Backend backend.Storage
+
+	// Time after which resumable upload sessions expire. The default is one
+	// week, like in GCS. Sessions are stored in the backend when it
+	// implements backend.UploadStorage, and in memory otherwise.
+	UploadSessionTTL time.Duration
+
+	// Optional function returning the current time, used by the server for
+	// the creation time of objects, the expiration of upload sessions and
+	// the evaluation of lifecycle rules, and by the built-in backends for
+	// the update and deletion times of buckets and objects. Custom backends
+	// get their time from backend.Config.Now instead. The default is
+	// time.Now. The clock of the server can be moved forward with
+	// Server.AdvanceTime.
+	Now func() time.Time
+
+	// Interval at which the lifecycle rules of buckets are applied in the
+	// background. When it's not set, the background loop starts only once a
+	// bucket has lifecycle rules, running every minute.
+	LifecycleInterval time.Duration
 }
 
 // NewServerWithOptions creates a new server configured according to the
@@ -148,13 +184,22 @@ func NewServerWithOptions(options Options) (*Server, error) {
 }
 
 func newServer(options Options) (*Server, error) {
-	backendObjects := toBackendObjects(options.InitialObjects)
+	clock := newClock(options.Now)
+	initialObjects := make([]Object, len(options.InitialObjects))
+	for i, obj := range options.InitialObjects {
+		initialObjects[i] = obj.withTimesIfZero(clock.Now())
+	}
+	backendObjects := toBackendObjects(initialObjects)
 	var backendStorage backend.Storage
 	var err error
-	if options.StorageRoot != "" {
-		backendStorage, err = backend.NewStorageFS(backendObjects, options.StorageRoot)
-	} else {
-		backendStorage = backend.NewStorageMemory(backendObjects)
+	switch {
+	case options.Backend != nil:
+		backendStorage = options.Backend
+		err = loadInitialObjects(backendStorage, backendObjects)
+	case options.StorageRoot != "":
+		backendStorage, err = backend.New(backend.FilesystemBackend, backend.Config{InitialObjects: backendObjects, Root: options.StorageRoot, Now: clock.Now})
+	default:
+		backendStorage, err = backend.New(backend.MemoryBackend, backend.Config{InitialObjects: backendObjects, Now: clock.Now})
 	}
 	if err != nil {
 		return nil, err
@@ -164,16 +209,44 @@ func newServer(options Options) (*Server, error) {
 		publicHost = defaultPublicHost
 	}
 	s := Server{
-		backend:     backendStorage,
-		uploads:     sync.Map{},
-		externalURL: options.ExternalURL,
-		publicHost:  publicHost,
-		options:     options,
+		backend:       backendStorage,
+		uploads:       uploadStorage(backendStorage),
+		externalURL:   options.ExternalURL,
+		publicHost:    publicHost,
+		options:       options,
+		clock:         clock,
+		stopLifecycle: make(chan struct{}),
 	}
 	s.buildMuxer()
+	if options.LifecycleInterval > 0 {
+		s.startLifecycle()
+	} else if buckets, err := backendStorage.ListBuckets(); err == nil {
+		for _, bucket := range buckets {
+			s.watchLifecycle(bucket.Lifecycle)
+		}
+	}
 	return &s, nil
 }
 
+// uploadStorage returns the storage of resumable uploads for the given
+// backend: the backend itself if it stores uploads, or an in-memory storage.
+func uploadStorage(storage backend.Storage) backend.UploadStorage {
+	if uploads, ok := storage.(backend.UploadStorage); ok {
+		return uploads
+	}
+	return backend.NewUploadStorageMemory()
+}
+
+func loadInitialObjects(storage backend.Storage, objects []backend.Object) error {
+	for _, o := range objects {
+		_, err := storage.CreateObject(o.ObjectAttrs, bytes.NewReader(o.Content))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *Server) buildMuxer() {
 	const apiPrefix = "/storage/v1"
 	s.mux = mux.NewRouter()
@@ -188,23 +261,31 @@ func (s *Server) buildMuxer() {
 		r.Path("/b").Methods("POST").HandlerFunc(jsonToHTTPHandler(s.createBucketByPost))
 		r.Path("/b/{bucketName}").Methods("GET").HandlerFunc(jsonToHTTPHandler(s.getBucket))
 		r.Path("/b/{bucketName}").Methods("DELETE").HandlerFunc(jsonToHTTPHandler(s.deleteBucket))
+		r.Path("/b/{bucketName}").Methods("PATCH").HandlerFunc(jsonToHTTPHandler(s.patchBucket))
+		r.Path("/b/{bucketName}").Methods("PUT").HandlerFunc(jsonToHTTPHandler(s.updateBucket))
+		r.Path("/b/{bucketName}/lockRetentionPolicy").Methods("POST").HandlerFunc(jsonToHTTPHandler(s.lockRetentionPolicy))
 		r.Path("/b/{bucketName}/o").Methods("GET").HandlerFunc(jsonToHTTPHandler(s.listObjects))
 		r.Path("/b/{bucketName}/o").Methods("POST").HandlerFunc(jsonToHTTPHandler(s.insertObject))
 		r.Path("/b/{bucketName}/o/{objectName:.+}").Methods("PATCH").HandlerFunc(jsonToHTTPHandler(s.patchObject))
 		r.Path("/b/{bucketName}/o/{objectName:.+}/acl").Methods("GET").HandlerFunc(jsonToHTTPHandler(s.listObjectACL))
 		r.Path("/b/{bucketName}/o/{objectName:.+}/acl").Methods("POST").HandlerFunc(jsonToHTTPHandler(s.setObjectACL))
 		r.Path("/b/{bucketName}/o/{objectName:.+}/acl/{entity}").Methods("PUT").HandlerFunc(jsonToHTTPHandler(s.setObjectACL))
+		r.Path("/b/{bucketName}/o/{objectName:.+}").Methods("PUT").HandlerFunc(jsonToHTTPHandler(s.updateObject))
 		r.Path("/b/{bucketName}/o/{objectName:.+}").Methods("GET").HandlerFunc(s.getObject)
 		r.Path("/b/{bucketName}/o/{objectName:.+}").Methods("DELETE").HandlerFunc(jsonToHTTPHandler(s.deleteObject))
-		r.Path("/b/{sourceBucket}/o/{sourceObject:.+}/copyTo/b/{destinationBucket}/o/{destinationObject:.+}").HandlerFunc(jsonToHTTPHandler(s.rewriteObject))
+		r.Path("/b/{sourceBucket}/o/{sourceObject:.+}/copyTo/b/{destinationBucket}/o/{destinationObject:.+}").HandlerFunc(jsonToHTTPHandler(s.copyObject))
 		r.Path("/b/{sourceBucket}/o/{sourceObject:.+}/rewriteTo/b/{destinationBucket}/o/{destinationObject:.+}").HandlerFunc(jsonToHTTPHandler(s.rewriteObject))
+		r.Path("/b/{bucketName}/o/{objectName:.+}/compose").Methods("POST").HandlerFunc(jsonToHTTPHandler(s.composeObject))
 	}
 
 	bucketHost := fmt.Sprintf("{bucketName}.%s", s.publicHost)
 	s.mux.Host(bucketHost).Path("/{objectName:.+}").Methods("GET", "HEAD").HandlerFunc(s.downloadObject)
 	s.mux.Path("/download/storage/v1/b/{bucketName}/o/{objectName:.+}").Methods("GET").HandlerFunc(s.downloadObject)
+	s.mux.Path("/upload/storage/v1/b/{bucketName}/o").Methods("PUT", "POST").Queries("upload_id", "{uploadId}").HandlerFunc(jsonToHTTPHandler(s.uploadFileContent))
+	s.mux.Path("/upload/storage/v1/b/{bucketName}/o").Methods("DELETE").Queries("upload_id", "{uploadId}").HandlerFunc(jsonToHTTPHandler(s.cancelUpload))
 	s.mux.Path("/upload/storage/v1/b/{bucketName}/o").Methods("POST").HandlerFunc(jsonToHTTPHandler(s.insertObject))
 	s.mux.Path("/upload/resumable/{uploadId}").Methods("PUT", "POST").HandlerFunc(jsonToHTTPHandler(s.uploadFileContent))
+	s.mux.Path("/upload/resumable/{uploadId}").Methods("DELETE").HandlerFunc(jsonToHTTPHandler(s.cancelUpload))
 
 	s.mux.Host(s.publicHost).Path("/{bucketName}/{objectName:.+}").Methods("GET", "HEAD").HandlerFunc(s.downloadObject)
 	s.mux.Host("{bucketName:.+}").Path("/{objectName:.+}").Methods("GET", "HEAD").HandlerFunc(s.downloadObject)
@@ -217,6 +298,7 @@ func (s *Server) buildMuxer() {
 
 // Stop stops the server, closing all connections.
 func (s *Server) Stop() {
+	s.stopOnce.Do(func() { close(s.stopLifecycle) })
 	if s.ts != nil {
 		if transport, ok := s.transport.(*http.Transport); ok {
 			transport.CloseIdleConnections()
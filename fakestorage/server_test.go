@@ -6,11 +6,16 @@ package fakestorage
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
+
+	"github.com/fsouza/fake-gcs-server/backend"
 )
 
 func TestNewServer(t *testing.T) {
@@ -41,6 +46,48 @@ func TestNewServerNoListener(t *testing.T) {
 	}
 }
 
+type countingBackend struct {
+	backend.Storage
+	created int32
+}
+
+func (b *countingBackend) CreateObject(attrs backend.ObjectAttrs, content io.Reader) (backend.ObjectAttrs, error) {
+	atomic.AddInt32(&b.created, 1)
+	return b.Storage.CreateObject(attrs, content)
+}
+
+func TestNewServerCustomBackend(t *testing.T) {
+	t.Parallel()
+	storage := &countingBackend{Storage: backend.NewStorageMemory(nil)}
+	server, err := NewServerWithOptions(Options{
+		NoListener: true,
+		Backend:    storage,
+		InitialObjects: []Object{
+			{BucketName: "some-bucket", Name: "img/party-01.jpg", Content: []byte("some content")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	server.CreateObject(Object{BucketName: "some-bucket", Name: "img/party-02.jpg", Content: []byte("other content")})
+	if created := atomic.LoadInt32(&storage.created); created != 2 {
+		t.Errorf("wrong number of objects created in the backend\nwant 2\ngot  %d", created)
+	}
+	obj, err := storage.GetObject("some-bucket", "img/party-01.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Content.Close()
+	content, err := ioutil.ReadAll(obj.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "some content" {
+		t.Errorf("wrong content in the backend\nwant %q\ngot  %q", "some content", content)
+	}
+}
+
 func TestNewServerExternalHost(t *testing.T) {
 	t.Parallel()
 	server, err := NewServerWithOptions(Options{ExternalURL: "https://gcs.example.com"})
@@ -425,8 +472,9 @@ func TestCORSRequests(t *testing.T) {
 
 func runServersTest(t *testing.T, objs []Object, fn func(*testing.T, *Server)) {
 	var testScenarios = []struct {
-		name    string
-		options Options
+		name      string
+		options   Options
+		fsStorage bool
 	}{
 		{
 			name:    "https listener",
@@ -440,11 +488,24 @@ func runServersTest(t *testing.T, objs []Object, fn func(*testing.T, *Server)) {
 			name:    "no listener",
 			options: Options{NoListener: true, InitialObjects: objs},
 		},
+		{
+			name:      "filesystem storage",
+			options:   Options{NoListener: true, InitialObjects: objs},
+			fsStorage: true,
+		},
 	}
 	for _, test := range testScenarios {
 		test := test
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
+			if test.fsStorage {
+				dir, err := ioutil.TempDir("", "fakestorage-test-root-")
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { os.RemoveAll(dir) })
+				test.options.StorageRoot = dir
+			}
 			server, err := NewServerWithOptions(test.options)
 			if err != nil {
 				t.Fatal(err)
@@ -454,3 +515,26 @@ func runServersTest(t *testing.T, objs []Object, fn func(*testing.T, *Server)) {
 		})
 	}
 }
+
+// sendJSONRequest sends a request with the given JSON body to the path in the
+// server, decoding the response into result when the request succeeds. It
+// returns the status code of the response.
+func sendJSONRequest(t *testing.T, server *Server, method, path, body string, result interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL()+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := server.HTTPClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
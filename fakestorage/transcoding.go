@@ -0,0 +1,75 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fsouza/fake-gcs-server/backend"
+)
+
+// storedContentEncoding returns the value of the
+// X-Goog-Stored-Content-Encoding header for an object with the given content
+// encoding.
+func storedContentEncoding(contentEncoding string) string {
+	if contentEncoding == "" {
+		return "identity"
+	}
+	return contentEncoding
+}
+
+// shouldTranscode returns whether the object should be decompressed before
+// being served, which is what GCS does for gzip-encoded objects when the
+// client doesn't accept gzip responses, unless either the request or the
+// metadata of the object opt out of it with "Cache-Control: no-transform".
+func shouldTranscode(r *http.Request, attrs backend.ObjectAttrs) bool {
+	if attrs.ContentEncoding != "gzip" {
+		return false
+	}
+	if headerHasToken(r.Header.Get("Cache-Control"), "no-transform") || headerHasToken(attrs.CacheControl, "no-transform") {
+		return false
+	}
+	return !headerHasToken(r.Header.Get("Accept-Encoding"), "gzip")
+}
+
+// writeTranscoded serves the decompressed content of a gzip-encoded object.
+// Like GCS, it ignores the Range header, as the ranges would refer to the
+// stored content, and it doesn't send a Content-Length, as the size of the
+// decompressed content isn't known upfront.
+//
+// It returns false, without writing anything, if the content of the object
+// isn't valid gzip data, in which case the object should be served as is.
+func (s *Server) writeTranscoded(w http.ResponseWriter, r *http.Request, obj backend.StreamingObject) bool {
+	if _, err := obj.Content.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	gzipReader, err := gzip.NewReader(obj.Content)
+	if err != nil {
+		obj.Content.Seek(0, io.SeekStart)
+		return false
+	}
+	defer gzipReader.Close()
+	w.Header().Set(contentTypeHeader, obj.ContentType)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		io.Copy(w, gzipReader)
+	}
+	return true
+}
+
+// headerHasToken returns whether the given comma-separated header value
+// contains the token, ignoring parameters such as quality values.
+func headerHasToken(value, token string) bool {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(strings.SplitN(item, ";", 2)[0])
+		if strings.EqualFold(item, token) {
+			return true
+		}
+	}
+	return false
+}
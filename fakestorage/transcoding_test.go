@@ -0,0 +1,182 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func gzipContent(t *testing.T, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestServerObjectDecompressiveTranscoding(t *testing.T) {
+	const content = "some log lines that were compressed before being uploaded"
+	compressed := gzipContent(t, content)
+	server := NewServer([]Object{{
+		BucketName:      "some-bucket",
+		Name:            "logs.txt",
+		ContentType:     "text/plain",
+		ContentEncoding: "gzip",
+		Content:         compressed,
+	}})
+	defer server.Stop()
+
+	tests := []struct {
+		name             string
+		headers          map[string]string
+		expectedStatus   int
+		expectedEncoding string
+		expectedBody     []byte
+	}{
+		{
+			"no gzip support",
+			map[string]string{"Accept-Encoding": "identity"},
+			http.StatusOK,
+			"",
+			[]byte(content),
+		},
+		{
+			"no gzip support with range",
+			map[string]string{"Accept-Encoding": "identity", "Range": "bytes=0-3"},
+			http.StatusOK,
+			"",
+			[]byte(content),
+		},
+		{
+			"gzip support",
+			map[string]string{"Accept-Encoding": "deflate, gzip;q=0.8"},
+			http.StatusOK,
+			"gzip",
+			compressed,
+		},
+		{
+			"gzip support with range",
+			map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-3"},
+			http.StatusPartialContent,
+			"gzip",
+			compressed[:4],
+		},
+		{
+			"no-transform",
+			map[string]string{"Accept-Encoding": "identity", "Cache-Control": "no-transform"},
+			http.StatusOK,
+			"gzip",
+			compressed,
+		},
+	}
+	for _, url := range []string{
+		server.URL() + "/download/storage/v1/b/some-bucket/o/logs.txt",
+		server.PublicURL() + "/some-bucket/logs.txt",
+	} {
+		for _, test := range tests {
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range test.headers {
+				req.Header.Set(name, value)
+			}
+			resp, err := server.HTTPClient().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.expectedStatus {
+				t.Errorf("%s: wrong status code\nwant %d\ngot  %d", test.name, test.expectedStatus, resp.StatusCode)
+			}
+			if enc := resp.Header.Get("Content-Encoding"); enc != test.expectedEncoding {
+				t.Errorf("%s: wrong Content-Encoding\nwant %q\ngot  %q", test.name, test.expectedEncoding, enc)
+			}
+			if !bytes.Equal(body, test.expectedBody) {
+				t.Errorf("%s: wrong body\nwant %q\ngot  %q", test.name, test.expectedBody, body)
+			}
+			if enc := resp.Header.Get("X-Goog-Stored-Content-Encoding"); enc != "gzip" {
+				t.Errorf("%s: wrong X-Goog-Stored-Content-Encoding\nwant %q\ngot  %q", test.name, "gzip", enc)
+			}
+			expectedLength := strconv.Itoa(len(compressed))
+			if length := resp.Header.Get("X-Goog-Stored-Content-Length"); length != expectedLength {
+				t.Errorf("%s: wrong X-Goog-Stored-Content-Length\nwant %q\ngot  %q", test.name, expectedLength, length)
+			}
+		}
+	}
+}
+
+func TestServerObjectNoTransformMetadata(t *testing.T) {
+	compressed := gzipContent(t, "some content")
+	server := NewServer([]Object{{
+		BucketName:      "some-bucket",
+		Name:            "logs.txt",
+		ContentEncoding: "gzip",
+		CacheControl:    "public, no-transform",
+		Content:         compressed,
+	}})
+	defer server.Stop()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL()+"/download/storage/v1/b/some-bucket/o/logs.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := server.HTTPClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc := resp.Header.Get("Content-Encoding"); enc != "gzip" {
+		t.Errorf("wrong Content-Encoding\nwant %q\ngot  %q", "gzip", enc)
+	}
+	if !bytes.Equal(body, compressed) {
+		t.Errorf("wrong body\nwant %q\ngot  %q", compressed, body)
+	}
+}
+
+func TestServerClientObjectReaderTranscoding(t *testing.T) {
+	const content = "some log lines that were compressed before being uploaded"
+	objs := []Object{{
+		BucketName:      "some-bucket",
+		Name:            "logs.txt",
+		ContentEncoding: "gzip",
+		Content:         gzipContent(t, content),
+	}}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		reader, err := server.Client().Bucket("some-bucket").Object("logs.txt").NewReader(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("wrong data returned\nwant %q\ngot  %q", content, string(data))
+		}
+	})
+}
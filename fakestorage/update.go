@@ -0,0 +1,261 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/fsouza/fake-gcs-server/backend"
+	"github.com/gorilla/mux"
+)
+
+// immutableObjectFields are the fields of the object resource that can't be
+// changed by PATCH or PUT requests. Requests may still include them, as long
+// as their values match the object, which is what clients that send back the
+// whole resource do.
+var immutableObjectFields = []string{
+	"bucket",
+	"name",
+	"generation",
+	"size",
+	"md5Hash",
+	"crc32c",
+	"storageClass",
+	"kmsKeyName",
+	"componentCount",
+}
+
+// patchObject updates the writable fields of the object present in the
+// request, leaving the others unchanged. Fields set to null are cleared, as
+// are metadata keys set to null.
+func (s *Server) patchObject(r *http.Request) jsonResponse {
+	return s.updateObjectMetadata(r, false)
+}
+
+// updateObject replaces all the writable fields of the object with the ones
+// in the request, clearing the fields the request omits.
+func (s *Server) updateObject(r *http.Request) jsonResponse {
+	return s.updateObjectMetadata(r, true)
+}
+
+func (s *Server) updateObjectMetadata(r *http.Request, replace bool) jsonResponse {
+	vars := mux.Vars(r)
+	bucketName := vars["bucketName"]
+	objectName := vars["objectName"]
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		return jsonResponse{
+			status:       http.StatusBadRequest,
+			errorMessage: "Metadata in the request couldn't decode",
+		}
+	}
+	conds, err := parseObjectConditions(r)
+	if err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	predefinedACL := r.URL.Query().Get("predefinedAcl")
+	if predefinedACL != "" && !isValidPredefinedACL(predefinedACL) {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: "invalid predefinedAcl"}
+	}
+	if resp := s.checkExistingObjectConditions(conds, bucketName, objectName, 0); resp != nil {
+		return *resp
+	}
+	current, err := s.getObjectAttrs(bucketName, objectName, 0)
+	if err != nil {
+		return jsonResponse{status: http.StatusNotFound, errorMessage: "Object not found to be updated"}
+	}
+	resource := newObjectResponse(fromBackendObjectsAttrs([]backend.ObjectAttrs{*current})[0])
+	if err := checkImmutableFields(fields, resource, immutableObjectFields); err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	patch, err := parseObjectPatch(fields, *current, replace)
+	if err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	if predefinedACL != "" {
+		patch.ACL = getObjectACL(predefinedACL)
+	}
+	backendObj, err := s.backend.PatchObject(bucketName, objectName, patch)
+	if err != nil {
+		return jsonResponse{status: http.StatusNotFound, errorMessage: "Object not found to be updated"}
+	}
+	return jsonResponse{data: newObjectResponse(s.withRetentionExpirationTime(fromBackendObjectsAttrs([]backend.ObjectAttrs{backendObj})[0]))}
+}
+
+// checkImmutableFields returns an error if the request changes any of the
+// given immutable fields of the resource.
+func checkImmutableFields(fields map[string]json.RawMessage, resource interface{}, immutableFields []string) error {
+	encoded, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	var current map[string]interface{}
+	if err := decodeJSONWithNumbers(encoded, &current); err != nil {
+		return err
+	}
+	for _, name := range immutableFields {
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		var value interface{}
+		if err := decodeJSONWithNumbers(raw, &value); err != nil || normalizeJSONValue(value) != normalizeJSONValue(current[name]) {
+			return fmt.Errorf("the %s field can't be changed", name)
+		}
+	}
+	return nil
+}
+
+// decodeJSONWithNumbers decodes the given JSON, keeping numbers as
+// json.Number, so large integers aren't rounded.
+func decodeJSONWithNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// normalizeJSONValue returns the text used to compare a decoded JSON value
+// with the one of a resource. GCS encodes 64-bit integers as strings, but
+// clients may send them as numbers, so both are compared by their text, and
+// null is the same as an empty string, as empty fields are omitted from
+// resources.
+func normalizeJSONValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+}
+
+// parseObjectPatch builds the patch for the given fields of an update
+// request. When replace is true, the fields that aren't in the request are
+// cleared, except for customTime, which can't be removed.
+func parseObjectPatch(fields map[string]json.RawMessage, current backend.ObjectAttrs, replace bool) (backend.ObjectPatch, error) {
+	var patch backend.ObjectPatch
+	stringFields := []struct {
+		name  string
+		field **string
+	}{
+		{"contentType", &patch.ContentType},
+		{"contentEncoding", &patch.ContentEncoding},
+		{"contentDisposition", &patch.ContentDisposition},
+		{"contentLanguage", &patch.ContentLanguage},
+		{"cacheControl", &patch.CacheControl},
+	}
+	for _, f := range stringFields {
+		raw, ok := fields[f.name]
+		if !ok && !replace {
+			continue
+		}
+		var value string
+		if ok && !isJSONNull(raw) {
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return patch, fmt.Errorf("invalid %s", f.name)
+			}
+		}
+		*f.field = &value
+	}
+
+	holdFields := []struct {
+		name  string
+		field **bool
+	}{
+		{"temporaryHold", &patch.TemporaryHold},
+		{"eventBasedHold", &patch.EventBasedHold},
+	}
+	for _, f := range holdFields {
+		raw, ok := fields[f.name]
+		if !ok && !replace {
+			continue
+		}
+		var hold bool
+		if ok && !isJSONNull(raw) {
+			if err := json.Unmarshal(raw, &hold); err != nil {
+				return patch, fmt.Errorf("invalid %s", f.name)
+			}
+		}
+		*f.field = &hold
+	}
+
+	if raw, ok := fields["customTime"]; ok {
+		customTime, err := parseCustomTime(raw, current.CustomTime)
+		if err != nil {
+			return patch, err
+		}
+		patch.CustomTime = &customTime
+	}
+
+	if raw, ok := fields["acl"]; ok || replace {
+		acl, err := parseACL(raw)
+		if err != nil {
+			return patch, err
+		}
+		patch.ACL = acl
+	}
+
+	patch.ReplaceMetadata = replace
+	if raw, ok := fields["metadata"]; ok {
+		if isJSONNull(raw) {
+			patch.ReplaceMetadata = true
+		} else if err := json.Unmarshal(raw, &patch.Metadata); err != nil {
+			return patch, fmt.Errorf("invalid metadata")
+		}
+	}
+	return patch, nil
+}
+
+// parseCustomTime parses the customTime of an update request. Like in GCS,
+// once set, the custom time of an object can't be removed or decreased.
+func parseCustomTime(raw json.RawMessage, current string) (string, error) {
+	if isJSONNull(raw) {
+		if current != "" {
+			return "", fmt.Errorf("the customTime of an object can't be removed")
+		}
+		return "", nil
+	}
+	var customTime time.Time
+	if err := json.Unmarshal(raw, &customTime); err != nil {
+		return "", fmt.Errorf("invalid customTime")
+	}
+	if current != "" && customTime.Before(convertTimeWithoutError(current)) {
+		return "", fmt.Errorf("the customTime of an object can't be decreased")
+	}
+	return formatTimeIfNotZero(customTime), nil
+}
+
+// parseACL parses the acl field of an update request, returning the default
+// ACL of objects when it's null or missing.
+func parseACL(raw json.RawMessage) ([]storage.ACLRule, error) {
+	if raw == nil || isJSONNull(raw) {
+		return getObjectACL(""), nil
+	}
+	var items []objectAccessControl
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, fmt.Errorf("invalid acl")
+	}
+	acl := make([]storage.ACLRule, 0, len(items))
+	for _, item := range items {
+		if item.Entity == "" || item.Role == "" {
+			return nil, fmt.Errorf("acl entries require an entity and a role")
+		}
+		acl = append(acl, storage.ACLRule{Entity: storage.ACLEntity(item.Entity), Role: storage.ACLRole(item.Role)})
+	}
+	return acl, nil
+}
+
+func isJSONNull(raw json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
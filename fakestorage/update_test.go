@@ -0,0 +1,171 @@
+// Copyright 2021 Francisco Souza. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakestorage
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+const objectUpdatePath = "/storage/v1/b/some-bucket/o/file.txt"
+
+func TestServerClientObjectPatch(t *testing.T) {
+	objs := []Object{{
+		BucketName:      "some-bucket",
+		Name:            "file.txt",
+		Content:         []byte("some content"),
+		ContentType:     "text/plain",
+		ContentLanguage: "en",
+		Metadata:        map[string]string{"a": "1", "b": "2"},
+	}}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		obj := server.Client().Bucket("some-bucket").Object("file.txt")
+		attrs, err := obj.If(storage.Conditions{MetagenerationMatch: 1}).Update(context.TODO(), storage.ObjectAttrsToUpdate{
+			ContentType: "text/csv",
+			Metadata:    map[string]string{"c": "3"},
+			ACL:         []storage.ACLRule{{Entity: storage.AllUsers, Role: storage.RoleReader}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attrs.ContentType != "text/csv" {
+			t.Errorf("wrong content type\nwant %q\ngot  %q", "text/csv", attrs.ContentType)
+		}
+		if attrs.ContentLanguage != "en" {
+			t.Errorf("wrong content language\nwant %q\ngot  %q", "en", attrs.ContentLanguage)
+		}
+		expectedMetadata := map[string]string{"a": "1", "b": "2", "c": "3"}
+		if !reflect.DeepEqual(attrs.Metadata, expectedMetadata) {
+			t.Errorf("wrong metadata\nwant %v\ngot  %v", expectedMetadata, attrs.Metadata)
+		}
+		if len(attrs.ACL) != 1 || attrs.ACL[0].Entity != storage.AllUsers {
+			t.Errorf("wrong acl: %+v", attrs.ACL)
+		}
+		if attrs.Metageneration != 2 {
+			t.Errorf("wrong metageneration\nwant 2\ngot  %d", attrs.Metageneration)
+		}
+
+		_, err = obj.If(storage.Conditions{MetagenerationMatch: 1}).Update(context.TODO(), storage.ObjectAttrsToUpdate{ContentType: "text/plain"})
+		if !hasStatusCode(err, http.StatusPreconditionFailed) {
+			t.Errorf("expected a precondition failure, got %v", err)
+		}
+	})
+}
+
+func TestServerObjectPatchFields(t *testing.T) {
+	customTime := time.Date(2021, 5, 6, 7, 8, 9, 0, time.UTC)
+	objs := []Object{{
+		BucketName:   "some-bucket",
+		Name:         "file.txt",
+		Content:      []byte("some content"),
+		ContentType:  "text/plain",
+		CacheControl: "no-cache",
+		CustomTime:   customTime,
+		Metadata:     map[string]string{"a": "1", "b": "2"},
+		Generation:   1760000000000000,
+	}}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		tests := []struct {
+			name           string
+			body           string
+			expectedStatus int
+		}{
+			{"unchanged immutable fields", `{"name": "file.txt", "bucket": "some-bucket", "size": "12"}`, http.StatusOK},
+			{"unchanged immutable numbers", `{"generation": 1760000000000000, "size": 12}`, http.StatusOK},
+			{"empty immutable field", `{"kmsKeyName": "", "componentCount": null}`, http.StatusOK},
+			{"changed name", `{"name": "other.txt"}`, http.StatusBadRequest},
+			{"changed generation", `{"generation": 1760000000000001}`, http.StatusBadRequest},
+			{"changed storage class", `{"storageClass": "COLDLINE"}`, http.StatusBadRequest},
+			{"removed custom time", `{"customTime": null}`, http.StatusBadRequest},
+			{"decreased custom time", `{"customTime": "2020-01-01T00:00:00Z"}`, http.StatusBadRequest},
+			{"invalid acl", `{"acl": [{"role": "READER"}]}`, http.StatusBadRequest},
+			{"invalid body", `not json`, http.StatusBadRequest},
+		}
+		for _, test := range tests {
+			test := test
+			t.Run(test.name, func(t *testing.T) {
+				status := sendJSONRequest(t, server, http.MethodPatch, objectUpdatePath, test.body, nil)
+				if status != test.expectedStatus {
+					t.Errorf("wrong status code\nwant %d\ngot  %d", test.expectedStatus, status)
+				}
+			})
+		}
+
+		var obj objectResponse
+		status := sendJSONRequest(t, server, http.MethodPatch, objectUpdatePath, `{"cacheControl": null, "customTime": "2022-01-01T00:00:00Z", "metadata": {"a": null, "c": "3"}}`, &obj)
+		if status != http.StatusOK {
+			t.Fatalf("wrong status code\nwant %d\ngot  %d", http.StatusOK, status)
+		}
+		if obj.Kind != "storage#object" {
+			t.Errorf("wrong kind\nwant %q\ngot  %q", "storage#object", obj.Kind)
+		}
+		if obj.CacheControl != "" {
+			t.Errorf("unexpected cache control %q", obj.CacheControl)
+		}
+		if obj.ContentType != "text/plain" {
+			t.Errorf("wrong content type\nwant %q\ngot  %q", "text/plain", obj.ContentType)
+		}
+		if obj.CustomTime != "2022-01-01T00:00:00Z" {
+			t.Errorf("wrong custom time\nwant %q\ngot  %q", "2022-01-01T00:00:00Z", obj.CustomTime)
+		}
+		if expected := map[string]string{"b": "2", "c": "3"}; !reflect.DeepEqual(obj.Metadata, expected) {
+			t.Errorf("wrong metadata\nwant %v\ngot  %v", expected, obj.Metadata)
+		}
+	})
+}
+
+func TestServerObjectUpdate(t *testing.T) {
+	objs := []Object{{
+		BucketName:      "some-bucket",
+		Name:            "file.txt",
+		Content:         []byte("some content"),
+		ContentType:     "text/plain",
+		ContentLanguage: "en",
+		Metadata:        map[string]string{"a": "1"},
+	}}
+
+	runServersTest(t, objs, func(t *testing.T, server *Server) {
+		status := sendJSONRequest(t, server, http.MethodPut, objectUpdatePath+"?ifMetagenerationMatch=2", `{"contentType": "text/csv"}`, nil)
+		if status != http.StatusPreconditionFailed {
+			t.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusPreconditionFailed, status)
+		}
+
+		var obj objectResponse
+		status = sendJSONRequest(t, server, http.MethodPut, objectUpdatePath+"?ifMetagenerationMatch=1&predefinedAcl=publicRead", `{"contentType": "text/csv", "metadata": {"b": "2"}}`, &obj)
+		if status != http.StatusOK {
+			t.Fatalf("wrong status code\nwant %d\ngot  %d", http.StatusOK, status)
+		}
+		if obj.ContentType != "text/csv" {
+			t.Errorf("wrong content type\nwant %q\ngot  %q", "text/csv", obj.ContentType)
+		}
+		if obj.ContentLanguage != "" {
+			t.Errorf("unexpected content language %q", obj.ContentLanguage)
+		}
+		if expected := map[string]string{"b": "2"}; !reflect.DeepEqual(obj.Metadata, expected) {
+			t.Errorf("wrong metadata\nwant %v\ngot  %v", expected, obj.Metadata)
+		}
+		if len(obj.ACL) != 1 || obj.ACL[0].Entity != "allUsers" {
+			t.Errorf("wrong acl: %+v", obj.ACL)
+		}
+		if obj.Metageneration != 2 {
+			t.Errorf("wrong metageneration\nwant 2\ngot  %d", obj.Metageneration)
+		}
+
+		stored, err := server.GetObject("some-bucket", "file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(stored.Content) != "some content" {
+			t.Errorf("wrong content after update\nwant %q\ngot  %q", "some content", stored.Content)
+		}
+	})
+}
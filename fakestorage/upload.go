@@ -7,33 +7,73 @@ package fakestorage
 import (
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"mime"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/storage"
-	"github.com/fsouza/fake-gcs-server/internal/checksum"
+	"github.com/fsouza/fake-gcs-server/backend"
 	"github.com/gorilla/mux"
 )
 
 const contentTypeHeader = "Content-Type"
 
+// defaultUploadSessionTTL is the time resumable upload sessions are valid for
+// when Options.UploadSessionTTL isn't set, which is the same as in GCS.
+const defaultUploadSessionTTL = 7 * 24 * time.Hour
+
+var errInvalidChunk = errors.New("invalid chunk")
+
 const (
 	uploadTypeMedia     = "media"
 	uploadTypeMultipart = "multipart"
 	uploadTypeResumable = "resumable"
 )
 
+// defaultStorageClass is the storage class of objects created without one.
+const defaultStorageClass = "STANDARD"
+
+var errInvalidStorageClass = errors.New("invalid storageClass")
+
 type multipartMetadata struct {
-	ContentType     string            `json:"contentType"`
-	ContentEncoding string            `json:"contentEncoding"`
-	Name            string            `json:"name"`
-	Metadata        map[string]string `json:"metadata"`
+	ContentType        string            `json:"contentType"`
+	ContentEncoding    string            `json:"contentEncoding"`
+	ContentDisposition string            `json:"contentDisposition"`
+	ContentLanguage    string            `json:"contentLanguage"`
+	CacheControl       string            `json:"cacheControl"`
+	StorageClass       string            `json:"storageClass"`
+	CustomTime         time.Time         `json:"customTime"`
+	TemporaryHold      bool              `json:"temporaryHold"`
+	EventBasedHold     bool              `json:"eventBasedHold"`
+	Name               string            `json:"name"`
+	Metadata           map[string]string `json:"metadata"`
+	Md5Hash            string            `json:"md5Hash"`
+	Crc32c             string            `json:"crc32c"`
+}
+
+// validate checks the fields of the metadata whose values are restricted by
+// GCS.
+func (m *multipartMetadata) validate() error {
+	if m.StorageClass != "" && !isValidStorageClass(m.StorageClass) {
+		return errInvalidStorageClass
+	}
+	return nil
+}
+
+func isValidStorageClass(storageClass string) bool {
+	switch storageClass {
+	case "STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE", "MULTI_REGIONAL", "REGIONAL", "DURABLE_REDUCED_AVAILABILITY":
+		return true
+	}
+	return false
 }
 
 type contentRange struct {
@@ -44,6 +84,16 @@ type contentRange struct {
 	Total      int  // Total bytes expected, -1 if unknown
 }
 
+// isStatusQuery returns whether the range is the one sent to query the status
+// of a resumable upload ("bytes */*").
+func (c contentRange) isStatusQuery() bool {
+	return c.Start < 0 && !c.KnownTotal
+}
+
+// statusClientClosedRequest is the non-standard status code that GCS uses to
+// respond to the cancellation of a resumable upload.
+const statusClientClosedRequest = 499
+
 func (s *Server) insertObject(r *http.Request) jsonResponse {
 	bucketName := mux.Vars(r)["bucketName"]
 
@@ -77,23 +127,11 @@ func (s *Server) insertObject(r *http.Request) jsonResponse {
 }
 
 func (s *Server) checkUploadPreconditions(r *http.Request, bucketName string, objectName string) *jsonResponse {
-	ifGenerationMatch := r.URL.Query().Get("ifGenerationMatch")
-
-	if ifGenerationMatch == "0" {
-		if _, err := s.backend.GetObject(bucketName, objectName); err == nil {
-			return &jsonResponse{
-				status:       http.StatusPreconditionFailed,
-				errorMessage: "Precondition failed",
-			}
-		}
-	} else if ifGenerationMatch != "" || r.URL.Query().Get("ifGenerationNotMatch") != "" {
-		return &jsonResponse{
-			status:       http.StatusNotImplemented,
-			errorMessage: "Precondition support not implemented",
-		}
+	conds, err := parseObjectConditions(r)
+	if err != nil {
+		return &jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
 	}
-
-	return nil
+	return s.checkObjectConditions(conds, bucketName, objectName, 0)
 }
 
 func (s *Server) simpleUpload(bucketName string, r *http.Request) jsonResponse {
@@ -107,25 +145,25 @@ func (s *Server) simpleUpload(bucketName string, r *http.Request) jsonResponse {
 			errorMessage: "name is required for simple uploads",
 		}
 	}
-	data, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return jsonResponse{errorMessage: err.Error()}
+	if resp := s.checkUploadPreconditions(r, bucketName, name); resp != nil {
+		return *resp
+	}
+	var hashes expectedHashes
+	if err := hashes.addFromHeader(r.Header); err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
 	}
 	obj := Object{
 		BucketName:      bucketName,
 		Name:            name,
-		Content:         data,
 		ContentType:     r.Header.Get(contentTypeHeader),
 		ContentEncoding: contentEncoding,
-		Crc32c:          checksum.EncodedCrc32cChecksum(data),
-		Md5Hash:         checksum.EncodedMd5Hash(data),
 		ACL:             getObjectACL(predefinedACL),
 	}
-	obj, err = s.createObject(obj)
+	obj, err := s.createObjectFromReader(obj, hashes.validatingReader(r.Body))
 	if err != nil {
-		return jsonResponse{errorMessage: err.Error()}
+		return uploadErrorResponse(err)
 	}
-	return jsonResponse{data: obj}
+	return jsonResponse{data: newObjectResponse(obj)}
 }
 
 func (s *Server) signedUpload(bucketName string, r *http.Request) jsonResponse {
@@ -147,26 +185,51 @@ func (s *Server) signedUpload(bucketName string, r *http.Request) jsonResponse {
 		}
 	}
 
-	data, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return jsonResponse{errorMessage: err.Error()}
+	var hashes expectedHashes
+	if err := hashes.addFromHeader(r.Header); err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
 	}
-	obj := Object{
-		BucketName:      bucketName,
-		Name:            name,
-		Content:         data,
-		ContentType:     r.Header.Get(contentTypeHeader),
-		ContentEncoding: contentEncoding,
-		Crc32c:          checksum.EncodedCrc32cChecksum(data),
-		Md5Hash:         checksum.EncodedMd5Hash(data),
-		ACL:             getObjectACL(predefinedACL),
-		Metadata:        metaData,
+	storageClass := r.Header.Get("X-Goog-Storage-Class")
+	if storageClass != "" && !isValidStorageClass(storageClass) {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: errInvalidStorageClass.Error()}
 	}
-	obj, err = s.createObject(obj)
+	var customTime time.Time
+	if value := r.Header.Get("X-Goog-Custom-Time"); value != "" {
+		var err error
+		customTime, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return jsonResponse{status: http.StatusBadRequest, errorMessage: "invalid x-goog-custom-time"}
+		}
+	}
+
+	obj := Object{
+		BucketName:         bucketName,
+		Name:               name,
+		ContentType:        r.Header.Get(contentTypeHeader),
+		ContentEncoding:    contentEncoding,
+		ContentDisposition: r.Header.Get("Content-Disposition"),
+		ContentLanguage:    r.Header.Get("Content-Language"),
+		CacheControl:       r.Header.Get("Cache-Control"),
+		StorageClass:       storageClass,
+		CustomTime:         customTime,
+		ACL:                getObjectACL(predefinedACL),
+		Metadata:           metaData,
+	}
+	obj, err := s.createObjectFromReader(obj, hashes.validatingReader(r.Body))
 	if err != nil {
-		return jsonResponse{errorMessage: err.Error()}
+		return uploadErrorResponse(err)
 	}
-	return jsonResponse{data: obj}
+	return jsonResponse{data: newObjectResponse(obj)}
+}
+
+// isValidPredefinedACL reports whether the given value is one of the
+// predefined ACLs that GCS accepts for objects.
+func isValidPredefinedACL(predefinedACL string) bool {
+	switch predefinedACL {
+	case "authenticatedRead", "bucketOwnerFullControl", "bucketOwnerRead", "private", "projectPrivate", "publicRead":
+		return true
+	}
+	return false
 }
 
 func getObjectACL(predefinedACL string) []storage.ACLRule {
@@ -196,26 +259,28 @@ func (s *Server) multipartUpload(bucketName string, r *http.Request) jsonRespons
 			errorMessage: "invalid Content-Type header",
 		}
 	}
-	var (
-		metadata *multipartMetadata
-		content  []byte
-	)
-	var contentType string
 	reader := multipart.NewReader(r.Body, params["boundary"])
 	part, err := reader.NextPart()
-	for ; err == nil; part, err = reader.NextPart() {
-		if metadata == nil {
-			metadata, err = loadMetadata(part)
-			contentType = metadata.ContentType
-		} else {
-			contentType = part.Header.Get(contentTypeHeader)
-			content, err = loadContent(part)
-		}
-		if err != nil {
-			break
-		}
+	if err != nil {
+		return jsonResponse{errorMessage: err.Error()}
+	}
+	metadata, err := loadMetadata(part)
+	if err != nil {
+		return jsonResponse{errorMessage: err.Error()}
 	}
-	if err != io.EOF {
+	if err := metadata.validate(); err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	contentType := metadata.ContentType
+
+	// The content is streamed straight from the second part, if any.
+	var content io.Reader = strings.NewReader("")
+	part, err = reader.NextPart()
+	if err == nil {
+		defer part.Close()
+		contentType = part.Header.Get(contentTypeHeader)
+		content = part
+	} else if err != io.EOF {
 		return jsonResponse{errorMessage: err.Error()}
 	}
 
@@ -229,22 +294,34 @@ func (s *Server) multipartUpload(bucketName string, r *http.Request) jsonRespons
 		return *resp
 	}
 
-	obj := Object{
-		BucketName:      bucketName,
-		Name:            objName,
-		Content:         content,
-		ContentType:     contentType,
-		ContentEncoding: metadata.ContentEncoding,
-		Crc32c:          checksum.EncodedCrc32cChecksum(content),
-		Md5Hash:         checksum.EncodedMd5Hash(content),
-		ACL:             getObjectACL(predefinedACL),
-		Metadata:        metadata.Metadata,
+	var hashes expectedHashes
+	if err := hashes.add(metadata.Md5Hash, metadata.Crc32c); err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	if err := hashes.addFromHeader(r.Header); err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
 	}
-	obj, err = s.createObject(obj)
+
+	obj := Object{
+		BucketName:         bucketName,
+		Name:               objName,
+		ContentType:        contentType,
+		ContentEncoding:    metadata.ContentEncoding,
+		ContentDisposition: metadata.ContentDisposition,
+		ContentLanguage:    metadata.ContentLanguage,
+		CacheControl:       metadata.CacheControl,
+		StorageClass:       metadata.StorageClass,
+		CustomTime:         metadata.CustomTime,
+		TemporaryHold:      metadata.TemporaryHold,
+		EventBasedHold:     metadata.EventBasedHold,
+		ACL:                getObjectACL(predefinedACL),
+		Metadata:           metadata.Metadata,
+	}
+	obj, err = s.createObjectFromReader(obj, hashes.validatingReader(content))
 	if err != nil {
-		return jsonResponse{errorMessage: err.Error()}
+		return uploadErrorResponse(err)
 	}
-	return jsonResponse{data: obj}
+	return jsonResponse{data: newObjectResponse(obj)}
 }
 
 func (s *Server) resumableUpload(bucketName string, r *http.Request) jsonResponse {
@@ -254,30 +331,65 @@ func (s *Server) resumableUpload(bucketName string, r *http.Request) jsonRespons
 	if err != nil {
 		return jsonResponse{errorMessage: err.Error()}
 	}
+	if err := metadata.validate(); err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
 	objName := r.URL.Query().Get("name")
 	if objName == "" {
 		objName = metadata.Name
 	}
+	if resp := s.checkUploadPreconditions(r, bucketName, objName); resp != nil {
+		return *resp
+	}
+	var hashes expectedHashes
+	if err := hashes.add(metadata.Md5Hash, metadata.Crc32c); err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	if contentEncoding == "" {
+		contentEncoding = metadata.ContentEncoding
+	}
 	obj := Object{
-		BucketName:      bucketName,
-		Name:            objName,
-		ContentEncoding: contentEncoding,
-		ACL:             getObjectACL(predefinedACL),
-		Metadata:        metadata.Metadata,
+		BucketName:         bucketName,
+		Name:               objName,
+		ContentType:        metadata.ContentType,
+		ContentEncoding:    contentEncoding,
+		ContentDisposition: metadata.ContentDisposition,
+		ContentLanguage:    metadata.ContentLanguage,
+		CacheControl:       metadata.CacheControl,
+		StorageClass:       metadata.StorageClass,
+		CustomTime:         metadata.CustomTime,
+		TemporaryHold:      metadata.TemporaryHold,
+		EventBasedHold:     metadata.EventBasedHold,
+		ACL:                getObjectACL(predefinedACL),
+		Metadata:           metadata.Metadata,
 	}
 	uploadID, err := generateUploadID()
 	if err != nil {
 		return jsonResponse{errorMessage: err.Error()}
 	}
-	s.uploads.Store(uploadID, obj)
+	s.expireUploads()
+	now := s.clock.Now()
+	err = s.uploads.CreateUpload(backend.Upload{
+		ID:              uploadID,
+		BucketName:      bucketName,
+		ObjectName:      objName,
+		Attrs:           toBackendObjects([]Object{obj.withTimesIfZero(now)})[0].ObjectAttrs,
+		ExpectedMd5Hash: hashes.md5Hash,
+		ExpectedCrc32c:  hashes.crc32c,
+		Created:         now,
+		Expires:         now.Add(s.uploadSessionTTL()),
+	})
+	if err != nil {
+		return jsonResponse{errorMessage: err.Error()}
+	}
 	header := make(http.Header)
-	header.Set("Location", s.URL()+"/upload/resumable/"+uploadID)
+	header.Set("Location", s.uploadURL(bucketName, uploadID))
 	if r.Header.Get("X-Goog-Upload-Command") == "start" {
-		header.Set("X-Goog-Upload-URL", s.URL()+"/upload/resumable/"+uploadID)
+		header.Set("X-Goog-Upload-URL", s.uploadURL(bucketName, uploadID))
 		header.Set("X-Goog-Upload-Status", "active")
 	}
 	return jsonResponse{
-		data:   obj,
+		data:   newObjectResponse(obj),
 		header: header,
 	}
 }
@@ -318,70 +430,324 @@ func (s *Server) resumableUpload(bucketName string, r *http.Request) jsonRespons
 // then has a status of "200 OK", with a header "X-Http-Status-Code-Override"
 // set to "308".
 func (s *Server) uploadFileContent(r *http.Request) jsonResponse {
-	uploadID := mux.Vars(r)["uploadId"]
-	rawObj, ok := s.uploads.Load(uploadID)
-	if !ok {
-		return jsonResponse{status: http.StatusNotFound}
+	defer r.Body.Close()
+	upload, resp := s.getUpload(r)
+	if resp != nil {
+		return *resp
+	}
+	if upload.Completed != nil {
+		return jsonResponse{data: newObjectResponse(completedUploadObject(upload))}
+	}
+	parsed := contentRange{Start: -1, End: -1, Total: -1}
+	if rawRange := r.Header.Get("Content-Range"); rawRange != "" {
+		var err error
+		parsed, err = parseContentRange(rawRange)
+		if err != nil {
+			return jsonResponse{errorMessage: err.Error(), status: http.StatusBadRequest}
+		}
+		if parsed.isStatusQuery() {
+			return uploadStatusResponse(r, upload.Size)
+		}
+	}
+	if parsed.Start >= 0 && int64(parsed.Start) != upload.Size {
+		return invalidChunkOffsetResponse(upload.Size, int64(parsed.Start))
+	}
+	hashes := expectedHashes{md5Hash: upload.ExpectedMd5Hash, crc32c: upload.ExpectedCrc32c}
+	if err := hashes.addFromHeader(r.Header); err != nil {
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	}
+	if hashes.md5Hash != upload.ExpectedMd5Hash || hashes.crc32c != upload.ExpectedCrc32c {
+		upload.ExpectedMd5Hash = hashes.md5Hash
+		upload.ExpectedCrc32c = hashes.crc32c
+		if err := s.uploads.UpdateUpload(upload); err != nil {
+			return jsonResponse{errorMessage: err.Error()}
+		}
+	}
+	chunk := &chunkReader{r: r.Body, expected: -1, max: -1}
+	if parsed.KnownRange {
+		chunk.expected = int64(parsed.End - parsed.Start + 1)
+	}
+	if parsed.KnownTotal {
+		chunk.max = int64(parsed.Total) - upload.Size
 	}
-	obj := rawObj.(Object)
-	content, err := loadContent(r.Body)
+	offset := upload.Size
+	uploadID := upload.ID
+	upload, err := s.uploads.AppendUpload(uploadID, offset, chunk)
 	if err != nil {
-		return jsonResponse{errorMessage: err.Error()}
+		return s.appendUploadErrorResponse(uploadID, offset, err)
 	}
+
 	commit := true
 	status := http.StatusOK
-	obj.Content = append(obj.Content, content...)
-	obj.Crc32c = checksum.EncodedCrc32cChecksum(obj.Content)
-	obj.Md5Hash = checksum.EncodedMd5Hash(obj.Content)
-	obj.ContentType = r.Header.Get(contentTypeHeader)
 	responseHeader := make(http.Header)
-	if contentRange := r.Header.Get("Content-Range"); contentRange != "" {
-		parsed, err := parseContentRange(contentRange)
-		if err != nil {
-			return jsonResponse{errorMessage: err.Error(), status: http.StatusBadRequest}
-		}
-		if parsed.KnownRange {
-			// Middle of streaming request, or any part of chunked request
-			responseHeader.Set("Range", fmt.Sprintf("bytes=0-%d", parsed.End))
-			// Complete if the range covers the known total
-			commit = parsed.KnownTotal && (parsed.End+1 >= parsed.Total)
-		} else {
-			// End of a streaming request
-			responseHeader.Set("Range", fmt.Sprintf("bytes=0-%d", len(obj.Content)))
+	if parsed.Start >= 0 || parsed.KnownTotal {
+		// Complete if the total is known and everything was received
+		commit = !parsed.KnownRange || (parsed.KnownTotal && int64(parsed.Total) == upload.Size)
+		if parsed.KnownTotal && !parsed.KnownRange && int64(parsed.Total) != upload.Size {
+			if upload.Size == offset && int64(parsed.Total) > upload.Size {
+				// an empty "bytes */N" request on an unfinished upload is
+				// also a status query.
+				return uploadStatusResponse(r, upload.Size)
+			}
+			return jsonResponse{
+				status:       http.StatusBadRequest,
+				errorMessage: fmt.Sprintf("invalid upload: declared %d bytes, but received %d bytes", parsed.Total, upload.Size),
+			}
 		}
+		setUploadRangeHeader(responseHeader, upload.Size)
+	}
+	upload.Attrs.BucketName = upload.BucketName
+	upload.Attrs.Name = upload.ObjectName
+	if contentType := r.Header.Get(contentTypeHeader); contentType != "" {
+		upload.Attrs.ContentType = contentType
 	}
+	obj := fromBackendObjectsAttrs([]backend.ObjectAttrs{upload.Attrs})[0]
 	if commit {
-		s.uploads.Delete(uploadID)
-		obj, err = s.createObject(obj)
+		unlock := s.uploadLocks.lock(uploadID)
+		defer unlock()
+		// concurrent requests may finalize the same upload, in which case
+		// only the first one creates the object.
+		upload, err = s.uploads.GetUpload(uploadID)
+		if err != nil {
+			return jsonResponse{status: http.StatusNotFound}
+		}
+		if upload.Completed != nil {
+			return jsonResponse{data: newObjectResponse(completedUploadObject(upload))}
+		}
+		content, err := s.uploads.OpenUpload(uploadID)
 		if err != nil {
 			return jsonResponse{errorMessage: err.Error()}
 		}
-	} else {
-		if _, no308 := r.Header["X-Guploader-No-308"]; no308 {
-			// Go client
-			responseHeader.Set("X-Http-Status-Code-Override", "308")
-		} else {
-			// Python client
-			status = http.StatusPermanentRedirect
+		defer content.Close()
+		obj, err = s.createObjectFromReader(obj, hashes.validatingReader(content))
+		if err != nil {
+			return uploadErrorResponse(err)
+		}
+		_, err = s.uploads.CompleteUpload(uploadID, toBackendObjects([]Object{obj})[0].ObjectAttrs)
+		if err != nil {
+			return jsonResponse{errorMessage: err.Error()}
 		}
-		s.uploads.Store(uploadID, obj)
+	} else {
+		setIncompleteUploadStatus(r, responseHeader, &status)
 	}
 	if r.Header.Get("X-Goog-Upload-Command") == "upload, finalize" {
 		responseHeader.Set("X-Goog-Upload-Status", "final")
 	}
 	return jsonResponse{
 		status: status,
-		data:   obj,
+		data:   newObjectResponse(obj),
 		header: responseHeader,
 	}
 }
 
+// uploadURL returns the URL of the session of a resumable upload, in the
+// same format used by GCS.
+func (s *Server) uploadURL(bucketName, uploadID string) string {
+	query := url.Values{}
+	query.Set("uploadType", uploadTypeResumable)
+	query.Set("upload_id", uploadID)
+	return s.URL() + "/upload/storage/v1/b/" + url.PathEscape(bucketName) + "/o?" + query.Encode()
+}
+
+// getUpload returns the resumable upload targeted by the request, or the
+// response to send if it doesn't exist or has expired. Expired uploads are
+// removed.
+//
+// Uploads are addressed either by the upload_id parameter of the URL
+// returned by uploadURL, or by the legacy /upload/resumable/{uploadId} path,
+// which doesn't include the bucket name.
+func (s *Server) getUpload(r *http.Request) (backend.Upload, *jsonResponse) {
+	vars := mux.Vars(r)
+	upload, err := s.uploads.GetUpload(vars["uploadId"])
+	if err != nil {
+		return upload, &jsonResponse{status: http.StatusNotFound}
+	}
+	if bucketName, ok := vars["bucketName"]; ok && bucketName != upload.BucketName {
+		return upload, &jsonResponse{status: http.StatusNotFound}
+	}
+	if isUploadExpired(upload, s.clock.Now()) {
+		s.uploads.DeleteUpload(upload.ID)
+		return upload, &jsonResponse{status: http.StatusGone, errorMessage: "upload session expired"}
+	}
+	return upload, nil
+}
+
+// expireUploads removes the resumable uploads that have expired, so
+// abandoned sessions don't pile up in the backend.
+func (s *Server) expireUploads() {
+	uploads, err := s.uploads.ListUploads()
+	if err != nil {
+		return
+	}
+	now := s.clock.Now()
+	for _, upload := range uploads {
+		if isUploadExpired(upload, now) {
+			s.uploads.DeleteUpload(upload.ID)
+		}
+	}
+}
+
+func isUploadExpired(upload backend.Upload, now time.Time) bool {
+	return !upload.Expires.IsZero() && now.After(upload.Expires)
+}
+
+func (s *Server) uploadSessionTTL() time.Duration {
+	if s.options.UploadSessionTTL > 0 {
+		return s.options.UploadSessionTTL
+	}
+	return defaultUploadSessionTTL
+}
+
+// completedUploadObject returns the object created by a completed upload.
+func completedUploadObject(upload backend.Upload) Object {
+	attrs := *upload.Completed
+	attrs.BucketName = upload.BucketName
+	attrs.Name = upload.ObjectName
+	return fromBackendObjectsAttrs([]backend.ObjectAttrs{attrs})[0]
+}
+
+func (s *Server) appendUploadErrorResponse(id string, offset int64, err error) jsonResponse {
+	switch {
+	case errors.Is(err, backend.UploadNotFound):
+		return jsonResponse{status: http.StatusNotFound}
+	case errors.Is(err, backend.UploadCompleted):
+		if upload, getErr := s.uploads.GetUpload(id); getErr == nil && upload.Completed != nil {
+			return jsonResponse{data: newObjectResponse(completedUploadObject(upload))}
+		}
+		return jsonResponse{status: http.StatusNotFound}
+	case errors.Is(err, backend.UploadOffsetInvalid):
+		upload, getErr := s.uploads.GetUpload(id)
+		if getErr != nil {
+			return jsonResponse{status: http.StatusNotFound}
+		}
+		return invalidChunkOffsetResponse(upload.Size, offset)
+	case errors.Is(err, errInvalidChunk):
+		return jsonResponse{status: http.StatusBadRequest, errorMessage: err.Error()}
+	default:
+		return jsonResponse{errorMessage: err.Error(), status: http.StatusBadRequest}
+	}
+}
+
+// uploadLocks serializes the commit of each resumable upload, so an upload
+// creates a single object even when finalized by concurrent requests. The
+// zero value is ready to use.
+type uploadLocks struct {
+	mtx   sync.Mutex
+	locks map[string]*uploadLock
+}
+
+type uploadLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the upload with the given ID, returning the function that
+// unlocks it.
+func (l *uploadLocks) lock(id string) func() {
+	l.mtx.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*uploadLock)
+	}
+	lock, ok := l.locks[id]
+	if !ok {
+		lock = &uploadLock{}
+		l.locks[id] = lock
+	}
+	lock.refs++
+	l.mtx.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, id)
+		}
+	}
+}
+
+func invalidChunkOffsetResponse(expected, got int64) jsonResponse {
+	return jsonResponse{
+		status:       http.StatusBadRequest,
+		errorMessage: fmt.Sprintf("invalid chunk: expected it to start at byte %d, but it starts at byte %d", expected, got),
+	}
+}
+
+// chunkReader reads a chunk of a resumable upload, failing with
+// errInvalidChunk if its size doesn't match the expected size or exceeds the
+// maximum size. Negative sizes aren't checked.
+type chunkReader struct {
+	r        io.Reader
+	expected int64
+	max      int64
+	read     int64
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += int64(n)
+	if c.max >= 0 && c.read > c.max {
+		return n, fmt.Errorf("%w: it goes past the declared total size of the upload", errInvalidChunk)
+	}
+	if err == io.EOF && c.expected >= 0 && c.read != c.expected {
+		return n, fmt.Errorf("%w: Content-Range declares %d bytes, but the body has %d bytes", errInvalidChunk, c.expected, c.read)
+	}
+	return n, err
+}
+
+// uploadStatusResponse responds to a status query on a resumable upload
+// session with the range of bytes persisted so far.
+func uploadStatusResponse(r *http.Request, size int64) jsonResponse {
+	status := http.StatusOK
+	header := make(http.Header)
+	setUploadRangeHeader(header, size)
+	setIncompleteUploadStatus(r, header, &status)
+	return jsonResponse{status: status, header: header}
+}
+
+// setUploadRangeHeader sets the Range header of the response to a resumable
+// upload request, which is omitted while no bytes have been persisted.
+func setUploadRangeHeader(header http.Header, size int64) {
+	if size > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=0-%d", size-1))
+	}
+}
+
+// setIncompleteUploadStatus sets the status of the response to a resumable
+// upload that expects more data, which is 308, unless the client can't
+// handle it.
+func setIncompleteUploadStatus(r *http.Request, header http.Header, status *int) {
+	if _, no308 := r.Header["X-Guploader-No-308"]; no308 {
+		// Go client
+		header.Set("X-Http-Status-Code-Override", "308")
+	} else {
+		// Python client
+		*status = http.StatusPermanentRedirect
+	}
+}
+
+// cancelUpload cancels a resumable upload session, discarding the data
+// received so far.
+func (s *Server) cancelUpload(r *http.Request) jsonResponse {
+	upload, resp := s.getUpload(r)
+	if resp != nil {
+		return *resp
+	}
+	if err := s.uploads.DeleteUpload(upload.ID); err != nil {
+		return jsonResponse{status: http.StatusNotFound}
+	}
+	return jsonResponse{status: statusClientClosedRequest, errorMessage: "upload cancelled"}
+}
+
 // Parse a Content-Range header
 // Some possible valid header values:
 //   bytes 0-1023/4096 (first 1024 bytes of a 4096-byte document)
 //   bytes 1024-2047/* (second 1024 bytes of a streaming document)
 //   bytes */4096      (The end of 4096 byte streaming document)
 //   bytes 0-*/*       (start and end of a streaming document as sent by nodeJS client lib)
+//   bytes */*         (status query of a resumable upload)
 func parseContentRange(r string) (parsed contentRange, err error) {
 	invalidErr := fmt.Errorf("invalid Content-Range: %v", r)
 
@@ -426,10 +792,6 @@ func parseContentRange(r string) (parsed contentRange, err error) {
 	// Process total length
 	if parts[1] == "*" {
 		parsed.Total = -1
-
-		if parsed.Start < 0 {
-			return parsed, invalidErr
-		}
 	} else {
 		parsed.KnownTotal = true
 		parsed.Total, err = strconv.Atoi(parts[1])
@@ -448,11 +810,6 @@ func loadMetadata(rc io.ReadCloser) (*multipartMetadata, error) {
 	return &m, err
 }
 
-func loadContent(rc io.ReadCloser) ([]byte, error) {
-	defer rc.Close()
-	return ioutil.ReadAll(rc)
-}
-
 func generateUploadID() (string, error) {
 	var raw [16]byte
 	_, err := rand.Read(raw[:])
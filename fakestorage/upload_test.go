@@ -10,15 +10,20 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/fsouza/fake-gcs-server/backend"
 	"github.com/fsouza/fake-gcs-server/internal/checksum"
 	"google.golang.org/api/googleapi"
 )
@@ -126,6 +131,29 @@ func checkChecksum(t *testing.T, content []byte, obj Object) {
 	}
 }
 
+func TestServerClientObjectWriterEtag(t *testing.T) {
+	content := strings.Repeat("some nice content\n", googleapi.MinUploadChunkSize/8)
+	runServersTest(t, nil, func(t *testing.T, server *Server) {
+		server.CreateBucketWithOpts(CreateBucketOpts{Name: "some-bucket"})
+		for _, chunkSize := range []int{0, googleapi.MinUploadChunkSize} {
+			objHandle := server.Client().Bucket("some-bucket").Object("file.txt")
+			w := objHandle.NewWriter(context.Background())
+			w.ChunkSize = chunkSize
+			w.Write([]byte(content))
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			attrs, err := objHandle.Attrs(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if etag := w.Attrs().Etag; etag == "" || etag != attrs.Etag {
+				t.Errorf("wrong etag in the upload response with chunk size %d\nwant %q\ngot  %q", chunkSize, attrs.Etag, etag)
+			}
+		}
+	})
+}
+
 func TestServerClientObjectWriterOverwrite(t *testing.T) {
 	runServersTest(t, nil, func(t *testing.T, server *Server) {
 		const content = "other content"
@@ -495,6 +523,10 @@ func TestParseContentRange(t *testing.T) {
 			"bytes 0-1024/*", // A streaming request, unknown total
 			contentRange{KnownRange: true, Start: 0, End: 1024, Total: -1},
 		},
+		{
+			"bytes */*", // Status query of a resumable upload
+			contentRange{Start: -1, End: -1, Total: -1},
+		},
 	}
 
 	for _, test := range goodHeaderTests {
@@ -518,7 +550,6 @@ func TestParseContentRange(t *testing.T) {
 		"bytes start-20/100",  // Non-integer range start
 		"bytes 20-end/100",    // Non-integer range end
 		"bytes 100-200/total", // Non-integer size
-		"bytes */*",           // Unknown range or size
 	}
 	for _, test := range badHeaderTests {
 		test := test
@@ -662,3 +693,417 @@ func isACLPublic(acl []storage.ACLRule) bool {
 	}
 	return false
 }
+
+func TestServerClientObjectWriterChecksumValidation(t *testing.T) {
+	const content = "some content that will be validated"
+	const original = "original content"
+	tests := []struct {
+		name      string
+		chunkSize int
+		setup     func(w *storage.Writer)
+		expectErr bool
+	}{
+		{
+			"matching md5",
+			0,
+			func(w *storage.Writer) { w.MD5 = checksum.MD5Hash([]byte(content)) },
+			false,
+		},
+		{
+			"mismatched md5",
+			0,
+			func(w *storage.Writer) { w.MD5 = checksum.MD5Hash([]byte("other content")) },
+			true,
+		},
+		{
+			"mismatched crc32c",
+			0,
+			func(w *storage.Writer) {
+				w.CRC32C = 42
+				w.SendCRC32C = true
+			},
+			true,
+		},
+		{
+			"mismatched crc32c on resumable upload",
+			googleapi.MinUploadChunkSize,
+			func(w *storage.Writer) {
+				w.CRC32C = 42
+				w.SendCRC32C = true
+			},
+			true,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		runServersTest(t, nil, func(t *testing.T, server *Server) {
+			server.CreateObject(Object{BucketName: "some-bucket", Name: "some-object.txt", Content: []byte(original)})
+			data := []byte(content)
+			if test.chunkSize > 0 {
+				data = bytes.Repeat(data, 2*test.chunkSize/len(data))
+			}
+			w := server.Client().Bucket("some-bucket").Object("some-object.txt").NewWriter(context.TODO())
+			w.ChunkSize = test.chunkSize
+			test.setup(w)
+			w.Write(data)
+			err := w.Close()
+			var apiErr *googleapi.Error
+			if test.expectErr {
+				if !errors.As(err, &apiErr) || apiErr.Code != http.StatusBadRequest {
+					t.Fatalf("%s: expected bad request error, got %v", test.name, err)
+				}
+			} else if err != nil {
+				t.Fatalf("%s: unexpected error: %v", test.name, err)
+			}
+			obj, err := server.GetObject("some-bucket", "some-object.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedContent := string(data)
+			if test.expectErr {
+				expectedContent = original
+			}
+			if string(obj.Content) != expectedContent {
+				t.Errorf("%s: wrong content\nwant %q\ngot  %q", test.name, expectedContent, string(obj.Content))
+			}
+		})
+	}
+}
+
+func TestServerSimpleUploadChecksumHeaders(t *testing.T) {
+	const data = "some nice content"
+	md5Hash := checksum.EncodedMd5Hash([]byte(data))
+	crc32c := checksum.EncodedCrc32cChecksum([]byte(data))
+	otherMd5Hash := checksum.EncodedMd5Hash([]byte("other content"))
+	tests := []struct {
+		name           string
+		header         string
+		value          string
+		expectedStatus int
+	}{
+		{"matching x-goog-hash", "X-Goog-Hash", "crc32c=" + crc32c + ",md5=" + md5Hash, http.StatusOK},
+		{"mismatched x-goog-hash md5", "X-Goog-Hash", "crc32c=" + crc32c + ",md5=" + otherMd5Hash, http.StatusBadRequest},
+		{"mismatched x-goog-hash crc32c", "X-Goog-Hash", "crc32c=AAAAAA==", http.StatusBadRequest},
+		{"invalid x-goog-hash", "X-Goog-Hash", "md5=not-base64", http.StatusBadRequest},
+		{"matching content-md5", "Content-MD5", md5Hash, http.StatusOK},
+		{"mismatched content-md5", "Content-MD5", otherMd5Hash, http.StatusBadRequest},
+	}
+	server := NewServer([]Object{{BucketName: "some-bucket", Name: "some-object.txt", Content: []byte("original")}})
+	defer server.Stop()
+	for _, test := range tests {
+		for _, url := range []string{
+			server.URL() + "/upload/storage/v1/b/some-bucket/o?uploadType=media&name=some-object.txt",
+			server.PublicURL() + "/some-bucket/some-object.txt?X-Goog-Algorithm=GOOG4-RSA-SHA256",
+		} {
+			server.CreateObject(Object{BucketName: "some-bucket", Name: "some-object.txt", Content: []byte("original")})
+			method := http.MethodPost
+			if strings.Contains(url, "X-Goog-Algorithm") {
+				method = http.MethodPut
+			}
+			req, err := http.NewRequest(method, url, strings.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set(test.header, test.value)
+			resp, err := server.HTTPClient().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.expectedStatus {
+				t.Errorf("%s, %s: wrong status code\nwant %d\ngot  %d", test.name, method, test.expectedStatus, resp.StatusCode)
+			}
+			obj, err := server.GetObject("some-bucket", "some-object.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedContent := data
+			if test.expectedStatus != http.StatusOK {
+				expectedContent = "original"
+			}
+			if string(obj.Content) != expectedContent {
+				t.Errorf("%s, %s: wrong content\nwant %q\ngot  %q", test.name, method, expectedContent, string(obj.Content))
+			}
+		}
+	}
+}
+
+func startResumableUpload(t *testing.T, server *Server, objectName string) string {
+	t.Helper()
+	url := server.URL() + "/upload/storage/v1/b/some-bucket/o?uploadType=resumable&name=" + objectName
+	resp, err := server.HTTPClient().Post(url, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code starting the upload\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+	}
+	return resp.Header.Get("Location")
+}
+
+func sendUploadRequest(t *testing.T, server *Server, method, url, contentRange, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentRange != "" {
+		req.Header.Set("Content-Range", contentRange)
+	}
+	resp, err := server.HTTPClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestServerResumableUploadStatusQuery(t *testing.T) {
+	server := NewServer([]Object{{BucketName: "some-bucket", Name: "existing.txt"}})
+	defer server.Stop()
+	location := startResumableUpload(t, server, "some-object.txt")
+
+	steps := []struct {
+		name           string
+		contentRange   string
+		body           string
+		expectedStatus int
+		expectedRange  string
+	}{
+		{"empty status", "bytes */*", "", http.StatusPermanentRedirect, ""},
+		{"first chunk", "bytes 0-4/*", "hello", http.StatusPermanentRedirect, "bytes=0-4"},
+		{"status after first chunk", "bytes */*", "", http.StatusPermanentRedirect, "bytes=0-4"},
+		{"status with the total size", "bytes */11", "", http.StatusPermanentRedirect, "bytes=0-4"},
+		{"misaligned chunk", "bytes 3-7/*", "lo wo", http.StatusBadRequest, ""},
+		{"chunk with wrong size", "bytes 5-9/*", " wo", http.StatusBadRequest, ""},
+		{"status after rejected chunks", "bytes */*", "", http.StatusPermanentRedirect, "bytes=0-4"},
+		{"last chunk", "bytes 5-10/11", " world", http.StatusOK, "bytes=0-10"},
+		{"status after completion", "bytes */*", "", http.StatusOK, ""},
+	}
+	for _, step := range steps {
+		resp := sendUploadRequest(t, server, http.MethodPut, location, step.contentRange, step.body)
+		if resp.StatusCode != step.expectedStatus {
+			t.Errorf("%s: wrong status code\nwant %d\ngot  %d", step.name, step.expectedStatus, resp.StatusCode)
+		}
+		if rng := resp.Header.Get("Range"); rng != step.expectedRange {
+			t.Errorf("%s: wrong Range header\nwant %q\ngot  %q", step.name, step.expectedRange, rng)
+		}
+	}
+	obj, err := server.GetObject("some-bucket", "some-object.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(obj.Content) != "hello world" {
+		t.Errorf("wrong content\nwant %q\ngot  %q", "hello world", string(obj.Content))
+	}
+}
+
+// slowOpenUploads delays the opening of the content of uploads, so
+// concurrent requests finalizing an upload overlap.
+type slowOpenUploads struct {
+	backend.UploadStorage
+}
+
+func (u slowOpenUploads) OpenUpload(id string) (backend.ReadSeekCloser, error) {
+	time.Sleep(50 * time.Millisecond)
+	return u.UploadStorage.OpenUpload(id)
+}
+
+func TestServerResumableUploadConcurrentFinalize(t *testing.T) {
+	server := NewServer(nil)
+	defer server.Stop()
+	server.CreateBucketWithOpts(CreateBucketOpts{Name: "some-bucket", VersioningEnabled: true})
+	server.uploads = slowOpenUploads{server.uploads}
+	location := startResumableUpload(t, server, "some-object.txt")
+	resp := sendUploadRequest(t, server, http.MethodPut, location, "bytes 0-4/*", "hello")
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		t.Fatalf("wrong status code\nwant %d\ngot  %d", http.StatusPermanentRedirect, resp.StatusCode)
+	}
+
+	const requests = 10
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			req, err := http.NewRequest(http.MethodPut, location, strings.NewReader(""))
+			if err != nil {
+				errs <- err
+				return
+			}
+			req.Header.Set("Content-Range", "bytes */5")
+			resp, err := server.HTTPClient().Do(req)
+			if err != nil {
+				errs <- err
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("wrong status code\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < requests; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	objs, _, err := server.ListObjectsWithOptions("some-bucket", ListOptions{Versions: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 {
+		t.Errorf("wrong number of generations created by the upload\nwant 1\ngot  %d", len(objs))
+	}
+}
+
+func TestServerResumableUploadCancel(t *testing.T) {
+	server := NewServer([]Object{{BucketName: "some-bucket", Name: "existing.txt"}})
+	defer server.Stop()
+	location := startResumableUpload(t, server, "some-object.txt")
+
+	resp := sendUploadRequest(t, server, http.MethodPut, location, "bytes 0-4/*", "hello")
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		t.Fatalf("wrong status code\nwant %d\ngot  %d", http.StatusPermanentRedirect, resp.StatusCode)
+	}
+	resp = sendUploadRequest(t, server, http.MethodDelete, location, "", "")
+	if resp.StatusCode != statusClientClosedRequest {
+		t.Errorf("wrong status code cancelling the upload\nwant %d\ngot  %d", statusClientClosedRequest, resp.StatusCode)
+	}
+	resp = sendUploadRequest(t, server, http.MethodPut, location, "bytes 5-10/11", " world")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("wrong status code after cancelling the upload\nwant %d\ngot  %d", http.StatusNotFound, resp.StatusCode)
+	}
+	if _, err := server.GetObject("some-bucket", "some-object.txt"); err == nil {
+		t.Error("unexpected object created by a cancelled upload")
+	}
+}
+
+func TestServerResumableUploadExpiration(t *testing.T) {
+	server, err := NewServerWithOptions(Options{
+		InitialObjects:   []Object{{BucketName: "some-bucket", Name: "existing.txt"}},
+		NoListener:       true,
+		UploadSessionTTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	location := startResumableUpload(t, server, "some-object.txt")
+	parsedLocation, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := server.uploads.GetUpload(parsedLocation.Query().Get("upload_id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := upload.Expires.Sub(upload.Created); ttl != time.Hour {
+		t.Errorf("wrong session TTL\nwant %s\ngot  %s", time.Hour, ttl)
+	}
+
+	upload.Expires = time.Now().Add(-time.Minute)
+	if err := server.uploads.UpdateUpload(upload); err != nil {
+		t.Fatal(err)
+	}
+	resp := sendUploadRequest(t, server, http.MethodPut, location, "bytes 0-4/5", "hello")
+	if resp.StatusCode != http.StatusGone {
+		t.Errorf("wrong status code for expired session\nwant %d\ngot  %d", http.StatusGone, resp.StatusCode)
+	}
+	resp = sendUploadRequest(t, server, http.MethodPut, location, "bytes 0-4/5", "hello")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("wrong status code for removed session\nwant %d\ngot  %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestServerResumableUploadSurvivesRestart(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "fakegcstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	opts := Options{
+		InitialObjects: []Object{{BucketName: "some-bucket", Name: "existing.txt"}},
+		NoListener:     true,
+		StorageRoot:    tempDir,
+	}
+	server, err := NewServerWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	location := startResumableUpload(t, server, "some-object.txt")
+	resp := sendUploadRequest(t, server, http.MethodPut, location, "bytes 0-4/*", "hello")
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		t.Fatalf("wrong status code\nwant %d\ngot  %d", http.StatusPermanentRedirect, resp.StatusCode)
+	}
+	server.Stop()
+
+	opts.InitialObjects = nil
+	server, err = NewServerWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	resp = sendUploadRequest(t, server, http.MethodPut, location, "bytes */*", "")
+	if rng := resp.Header.Get("Range"); rng != "bytes=0-4" {
+		t.Errorf("wrong Range after restart\nwant %q\ngot  %q", "bytes=0-4", rng)
+	}
+	resp = sendUploadRequest(t, server, http.MethodPut, location, "bytes 5-10/11", " world")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("wrong status code\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+	}
+	obj, err := server.GetObject("some-bucket", "some-object.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(obj.Content) != "hello world" {
+		t.Errorf("wrong content\nwant %q\ngot  %q", "hello world", string(obj.Content))
+	}
+}
+
+func TestServerResumableUploadLocation(t *testing.T) {
+	server := NewServer([]Object{{BucketName: "some-bucket", Name: "existing.txt"}})
+	defer server.Stop()
+	location := startResumableUpload(t, server, "some-object.txt")
+	parsedLocation, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsedLocation.Path != "/upload/storage/v1/b/some-bucket/o" {
+		t.Errorf("wrong path in the Location\nwant %q\ngot  %q", "/upload/storage/v1/b/some-bucket/o", parsedLocation.Path)
+	}
+	if uploadType := parsedLocation.Query().Get("uploadType"); uploadType != "resumable" {
+		t.Errorf("wrong uploadType in the Location\nwant %q\ngot  %q", "resumable", uploadType)
+	}
+	uploadID := parsedLocation.Query().Get("upload_id")
+	if uploadID == "" {
+		t.Fatal("missing upload_id in the Location")
+	}
+
+	otherBucketURL := server.URL() + "/upload/storage/v1/b/other-bucket/o?uploadType=resumable&upload_id=" + uploadID
+	resp := sendUploadRequest(t, server, http.MethodPut, otherBucketURL, "bytes 0-4/*", "hello")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("wrong status code for a different bucket\nwant %d\ngot  %d", http.StatusNotFound, resp.StatusCode)
+	}
+	resp = sendUploadRequest(t, server, http.MethodPut, location, "bytes 0-4/*", "hello")
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		t.Errorf("wrong status code for the first chunk\nwant %d\ngot  %d", http.StatusPermanentRedirect, resp.StatusCode)
+	}
+	legacyURL := server.URL() + "/upload/resumable/" + uploadID
+	resp = sendUploadRequest(t, server, http.MethodPut, legacyURL, "bytes 5-10/11", " world")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("wrong status code for the last chunk on the legacy path\nwant %d\ngot  %d", http.StatusOK, resp.StatusCode)
+	}
+	obj, err := server.GetObject("some-bucket", "some-object.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(obj.Content) != "hello world" {
+		t.Errorf("wrong content\nwant %q\ngot  %q", "hello world", string(obj.Content))
+	}
+
+	location = startResumableUpload(t, server, "cancelled.txt")
+	resp = sendUploadRequest(t, server, http.MethodDelete, location, "", "")
+	if resp.StatusCode != statusClientClosedRequest {
+		t.Errorf("wrong status code cancelling the upload\nwant %d\ngot  %d", statusClientClosedRequest, resp.StatusCode)
+	}
+}
@@ -7,6 +7,9 @@ package checksum
 import (
 	"crypto/md5"
 	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
 	"hash/crc32"
 )
 
@@ -39,3 +42,115 @@ func EncodedHash(hash []byte) string {
 func EncodedMd5Hash(content []byte) string {
 	return EncodedHash(MD5Hash(content))
 }
+
+// StreamingHasher computes the size, CRC32C checksum and MD5 hash of all the
+// data written to it, so content can be checksummed while it's streamed.
+type StreamingHasher struct {
+	crc32c hash.Hash32
+	md5    hash.Hash
+	size   int64
+}
+
+func NewStreamingHasher() *StreamingHasher {
+	return &StreamingHasher{
+		crc32c: crc32.New(crc32cTable),
+		md5:    md5.New(),
+	}
+}
+
+func (h *StreamingHasher) Write(p []byte) (int, error) {
+	h.crc32c.Write(p)
+	h.md5.Write(p)
+	h.size += int64(len(p))
+	return len(p), nil
+}
+
+func (h *StreamingHasher) Size() int64 {
+	return h.size
+}
+
+func (h *StreamingHasher) EncodedCrc32cChecksum() string {
+	return EncodedChecksum(h.crc32c.Sum(make([]byte, 0, 4)))
+}
+
+func (h *StreamingHasher) EncodedMd5Hash() string {
+	return EncodedHash(h.md5.Sum(nil))
+}
+
+// DecodeCrc32cChecksum decodes a base64-encoded CRC32C checksum, as stored in
+// object attributes.
+func DecodeCrc32cChecksum(encoded string) (uint32, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, err
+	}
+	if len(decoded) != 4 {
+		return 0, fmt.Errorf("invalid crc32c checksum %q", encoded)
+	}
+	return binary.BigEndian.Uint32(decoded), nil
+}
+
+// EncodedCrc32cValue base64-encodes a CRC32C checksum.
+func EncodedCrc32cValue(crc uint32) string {
+	var encoded [4]byte
+	binary.BigEndian.PutUint32(encoded[:], crc)
+	return EncodedChecksum(encoded[:])
+}
+
+// CombineCrc32c returns the CRC32C checksum of the concatenation of two blocks
+// of data, given the checksum of each block and the size of the second one,
+// without reading the data itself.
+func CombineCrc32c(crc1, crc2 uint32, size2 int64) uint32 {
+	if size2 <= 0 {
+		return crc1
+	}
+
+	// operator for a single zero bit, and then for two and four zero bits.
+	var even, odd [32]uint32
+	odd[0] = crc32.Castagnoli
+	row := uint32(1)
+	for n := 1; n < 32; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+	gf2MatrixSquare(&even, &odd)
+	gf2MatrixSquare(&odd, &even)
+
+	// apply size2 zero bytes to crc1, squaring the operator for each bit of
+	// size2.
+	for {
+		gf2MatrixSquare(&even, &odd)
+		if size2&1 != 0 {
+			crc1 = gf2MatrixTimes(&even, crc1)
+		}
+		size2 >>= 1
+		if size2 == 0 {
+			break
+		}
+		gf2MatrixSquare(&odd, &even)
+		if size2&1 != 0 {
+			crc1 = gf2MatrixTimes(&odd, crc1)
+		}
+		size2 >>= 1
+		if size2 == 0 {
+			break
+		}
+	}
+	return crc1 ^ crc2
+}
+
+func gf2MatrixTimes(mat *[32]uint32, vec uint32) uint32 {
+	var sum uint32
+	for i := 0; vec != 0; i, vec = i+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square, mat *[32]uint32) {
+	for n := 0; n < 32; n++ {
+		square[n] = gf2MatrixTimes(mat, mat[n])
+	}
+}
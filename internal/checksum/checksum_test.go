@@ -48,3 +48,47 @@ func TestEncodedMd5Hash(t *testing.T) {
 		t.Errorf("incorrect value after decoding\nwant %x, got  %x", expected, decoded)
 	}
 }
+
+func TestStreamingHasher(t *testing.T) {
+	var data [64]byte
+	_, err := rand.Read(data[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasher := NewStreamingHasher()
+	hasher.Write(data[:10])
+	hasher.Write(data[10:])
+	if size := hasher.Size(); size != int64(len(data)) {
+		t.Errorf("incorrect size\nwant %d, got  %d", len(data), size)
+	}
+	if crc32c := hasher.EncodedCrc32cChecksum(); crc32c != EncodedCrc32cChecksum(data[:]) {
+		t.Errorf("incorrect checksum\nwant %s, got  %s", EncodedCrc32cChecksum(data[:]), crc32c)
+	}
+	if md5Hash := hasher.EncodedMd5Hash(); md5Hash != EncodedMd5Hash(data[:]) {
+		t.Errorf("incorrect hash\nwant %s, got  %s", EncodedMd5Hash(data[:]), md5Hash)
+	}
+}
+
+func TestCombineCrc32c(t *testing.T) {
+	var data [1500]byte
+	_, err := rand.Read(data[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, split := range []int{0, 1, 7, 750, 1499, 1500} {
+		crc1, err := DecodeCrc32cChecksum(EncodedCrc32cChecksum(data[:split]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		crc2, err := DecodeCrc32cChecksum(EncodedCrc32cChecksum(data[split:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		combined := EncodedCrc32cValue(CombineCrc32c(crc1, crc2, int64(len(data)-split)))
+		if expected := EncodedCrc32cChecksum(data[:]); combined != expected {
+			t.Errorf("incorrect combined checksum splitting at %d\nwant %s, got  %s", split, expected, combined)
+		}
+	}
+}
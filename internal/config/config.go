@@ -11,16 +11,13 @@ import (
 	"fmt"
 	"math"
 	"strings"
+	"time"
 
+	"github.com/fsouza/fake-gcs-server/backend"
 	"github.com/fsouza/fake-gcs-server/fakestorage"
 	"github.com/sirupsen/logrus"
 )
 
-const (
-	filesystemBackend = "filesystem"
-	memoryBackend     = "memory"
-)
-
 type Config struct {
 	Seed               string
 	publicHost         string
@@ -31,6 +28,7 @@ type Config struct {
 	port               uint
 	backend            string
 	fsRoot             string
+	uploadSessionTTL   time.Duration
 }
 
 // Load parses the given arguments list and return a config object (and/or an
@@ -40,7 +38,7 @@ func Load(args []string) (Config, error) {
 	var allowedCORSHeaders string
 
 	fs := flag.NewFlagSet("fake-gcs-server", flag.ContinueOnError)
-	fs.StringVar(&cfg.backend, "backend", filesystemBackend, "storage backend (memory or filesystem)")
+	fs.StringVar(&cfg.backend, "backend", backend.FilesystemBackend, fmt.Sprintf("storage backend (%s)", strings.Join(backend.Names(), ", ")))
 	fs.StringVar(&cfg.fsRoot, "filesystem-root", "/storage", "filesystem root (required for the filesystem backend). folder will be created if it doesn't exist")
 	fs.StringVar(&cfg.publicHost, "public-host", "storage.googleapis.com", "Optional URL for public host")
 	fs.StringVar(&cfg.externalURL, "external-url", "", "optional external URL, returned in the Location header for uploads. Defaults to the address where the server is running")
@@ -49,6 +47,7 @@ func Load(args []string) (Config, error) {
 	fs.StringVar(&cfg.Seed, "data", "", "where to load data from (provided that the directory exists)")
 	fs.StringVar(&allowedCORSHeaders, "cors-headers", "", "comma separated list of headers to add to the CORS allowlist")
 	fs.UintVar(&cfg.port, "port", 4443, "port to bind to")
+	fs.DurationVar(&cfg.uploadSessionTTL, "upload-session-ttl", 7*24*time.Hour, "time after which resumable upload sessions expire")
 
 	err := fs.Parse(args)
 	if err != nil {
@@ -63,10 +62,10 @@ func Load(args []string) (Config, error) {
 }
 
 func (c *Config) validate() error {
-	if c.backend != memoryBackend && c.backend != filesystemBackend {
-		return fmt.Errorf(`invalid backend %q, must be either "memory" or "filesystem"`, c.backend)
+	if !backend.IsRegistered(c.backend) {
+		return fmt.Errorf("invalid backend %q, must be one of: %s", c.backend, strings.Join(backend.Names(), ", "))
 	}
-	if c.backend == filesystemBackend && c.fsRoot == "" {
+	if c.backend == backend.FilesystemBackend && c.fsRoot == "" {
 		return fmt.Errorf("backend %q requires the filesystem-root to be defined", c.backend)
 	}
 	if c.scheme != "http" && c.scheme != "https" {
@@ -75,16 +74,17 @@ func (c *Config) validate() error {
 	if c.port > math.MaxUint16 {
 		return fmt.Errorf("port %d is too high, maximum value is %d", c.port, math.MaxUint16)
 	}
+	if c.uploadSessionTTL <= 0 {
+		return fmt.Errorf("invalid upload session TTL %s, must be positive", c.uploadSessionTTL)
+	}
 	return nil
 }
 
-func (c *Config) ToFakeGcsOptions() fakestorage.Options {
-	storageRoot := c.fsRoot
-	if c.backend == memoryBackend {
-		storageRoot = ""
-	}
-	return fakestorage.Options{
-		StorageRoot:        storageRoot,
+// ToFakeGcsOptions converts the config to the options used to start the
+// server. Backends other than the builtin memory and filesystem backends are
+// created using the factory registered under the configured name.
+func (c *Config) ToFakeGcsOptions() (fakestorage.Options, error) {
+	opts := fakestorage.Options{
 		Scheme:             c.scheme,
 		Host:               c.host,
 		Port:               uint16(c.port),
@@ -92,5 +92,18 @@ func (c *Config) ToFakeGcsOptions() fakestorage.Options {
 		ExternalURL:        c.externalURL,
 		AllowedCORSHeaders: c.allowedCORSHeaders,
 		Writer:             logrus.New().Writer(),
+		UploadSessionTTL:   c.uploadSessionTTL,
+	}
+	switch c.backend {
+	case backend.FilesystemBackend:
+		opts.StorageRoot = c.fsRoot
+	case backend.MemoryBackend:
+	default:
+		storage, err := backend.New(c.backend, backend.Config{Root: c.fsRoot})
+		if err != nil {
+			return opts, err
+		}
+		opts.Backend = storage
 	}
+	return opts, nil
 }
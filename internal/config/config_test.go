@@ -6,12 +6,22 @@ package config
 
 import (
 	"testing"
+	"time"
 
+	"github.com/fsouza/fake-gcs-server/backend"
 	"github.com/fsouza/fake-gcs-server/fakestorage"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
+const customBackend = "custom-config-test"
+
+func init() {
+	backend.Register(customBackend, func(cfg backend.Config) (backend.Storage, error) {
+		return backend.NewStorageMemory(cfg.InitialObjects), nil
+	})
+}
+
 func TestLoadConfig(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -32,6 +42,7 @@ func TestLoadConfig(t *testing.T) {
 				"-port", "443",
 				"-data", "/var/gcs",
 				"-scheme", "http",
+				"-upload-session-ttl", "1h",
 			},
 			expectedConfig: Config{
 				Seed:               "/var/gcs",
@@ -43,6 +54,7 @@ func TestLoadConfig(t *testing.T) {
 				host:               "127.0.0.1",
 				port:               443,
 				scheme:             "http",
+				uploadSessionTTL:   time.Hour,
 			},
 		},
 		{
@@ -57,6 +69,20 @@ func TestLoadConfig(t *testing.T) {
 				host:               "0.0.0.0",
 				port:               4443,
 				scheme:             "https",
+				uploadSessionTTL:   7 * 24 * time.Hour,
+			},
+		},
+		{
+			name: "registered backend",
+			args: []string{"-backend", customBackend},
+			expectedConfig: Config{
+				backend:          customBackend,
+				fsRoot:           "/storage",
+				publicHost:       "storage.googleapis.com",
+				host:             "0.0.0.0",
+				port:             4443,
+				scheme:           "https",
+				uploadSessionTTL: 7 * 24 * time.Hour,
 			},
 		},
 		{
@@ -69,6 +95,11 @@ func TestLoadConfig(t *testing.T) {
 			args:      []string{"-port", "65536"},
 			expectErr: true,
 		},
+		{
+			name:      "invalid upload session TTL",
+			args:      []string{"-upload-session-ttl", "0s"},
+			expectErr: true,
+		},
 		{
 			name:      "invalid backend",
 			args:      []string{"-backend", "in-memory"},
@@ -146,7 +177,10 @@ func TestToFakeGcsOptions(t *testing.T) {
 		test := test
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
-			opts := test.config.ToFakeGcsOptions()
+			opts, err := test.config.ToFakeGcsOptions()
+			if err != nil {
+				t.Fatal(err)
+			}
 			ignWriter := cmpopts.IgnoreFields(fakestorage.Options{}, "Writer")
 			if diff := cmp.Diff(opts, test.expected, ignWriter); diff != "" {
 				t.Errorf("wrong set of options returned\nwant %#v\ngot  %#v\ndiff: %v", test.expected, opts, diff)
@@ -154,3 +188,18 @@ func TestToFakeGcsOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestToFakeGcsOptionsRegisteredBackend(t *testing.T) {
+	t.Parallel()
+	cfg := Config{backend: customBackend}
+	opts, err := cfg.ToFakeGcsOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Backend == nil {
+		t.Error("unexpected <nil> backend")
+	}
+	if opts.StorageRoot != "" {
+		t.Errorf("unexpected storage root %q", opts.StorageRoot)
+	}
+}
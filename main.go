@@ -31,7 +31,10 @@ func main() {
 	logger := logrus.New()
 
 	var emptyBuckets []string
-	opts := cfg.ToFakeGcsOptions()
+	opts, err := cfg.ToFakeGcsOptions()
+	if err != nil {
+		logger.WithError(err).Fatal("couldn't create the storage backend")
+	}
 	if cfg.Seed != "" {
 		opts.InitialObjects, emptyBuckets = generateObjectsFromFiles(logger, cfg.Seed)
 	}